@@ -24,10 +24,12 @@ func SetupRouter() *gin.Engine {
 	r.GET("/api/rag", listRags)
 	r.GET("/api/rag/:name", getRag)
 	r.DELETE("/api/rag/:name", deleteRag)
+	r.GET("/api/rag/:name/documents", listDocuments)
+	r.POST("/api/rag/:name/documents", addDocuments)
+	r.DELETE("/api/rag/:name/documents/*pattern", removeDocuments)
 	r.POST("/api/query/:name", queryRag)
+	r.POST("/api/query/:name/stream", queryRagStream)
 	r.POST("/api/upload", handleFileUpload)
 
 	return r
 }
-
-// Handler implementations would follow here...
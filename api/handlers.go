@@ -0,0 +1,765 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golvellius32/rlama/internal/config"
+	"github.com/golvellius32/rlama/internal/domain"
+	"github.com/golvellius32/rlama/internal/repository"
+	"github.com/golvellius32/rlama/internal/service"
+)
+
+// errorResponse is the JSON body returned for every failed request
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// documentResponse describes an indexed document without its content
+type documentResponse struct {
+	ID          string    `json:"id"`
+	Path        string    `json:"path"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ragResponse describes a RAG system as returned by the API
+type ragResponse struct {
+	Name           string                 `json:"name"`
+	ModelName      string                 `json:"model_name"`
+	EmbeddingModel string                 `json:"embedding_model"`
+	Description    string                 `json:"description"`
+	CreatedAt      time.Time              `json:"created_at"`
+	UpdatedAt      time.Time              `json:"updated_at"`
+	Retrieval      domain.RetrievalConfig `json:"retrieval"`
+	Documents      []documentResponse     `json:"documents"`
+}
+
+// queryRequest is the JSON body expected by the query endpoint. The optional
+// retrieval settings override those of the RAG for this query; filter takes
+// the same expressions as `rlama run --filter`.
+type queryRequest struct {
+	Query         string   `json:"query" binding:"required"`
+	TopK          *int     `json:"top_k"`
+	MinScore      *float64 `json:"min_score"`
+	ContextTokens *int     `json:"context_tokens"`
+	VectorWeight  *float64 `json:"vector_weight"`
+	KeywordWeight *float64 `json:"keyword_weight"`
+	// Filter restricts the search to passages whose metadata match it
+	Filter *string `json:"filter"`
+}
+
+// retrieval returns the search settings of the query
+func (r queryRequest) retrieval(rag *domain.RagSystem) (domain.RetrievalConfig, error) {
+	retrieval := rag.GetRetrievalConfig()
+	if r.TopK != nil {
+		retrieval.TopK = *r.TopK
+	}
+	if r.MinScore != nil {
+		retrieval.MinScore = *r.MinScore
+	}
+	if r.ContextTokens != nil {
+		retrieval.ContextTokens = *r.ContextTokens
+	}
+	if r.VectorWeight != nil {
+		retrieval.VectorWeight = *r.VectorWeight
+	}
+	if r.KeywordWeight != nil {
+		retrieval.KeywordWeight = *r.KeywordWeight
+	}
+	if r.Filter != nil {
+		retrieval.Filter = *r.Filter
+	}
+	return retrieval, retrieval.Validate()
+}
+
+// queryResponse is the JSON body returned by the query endpoint
+type queryResponse struct {
+	Response string           `json:"response"`
+	Sources  []service.Source `json:"sources"`
+}
+
+// newQueryResponse converts a query result into its API representation
+func newQueryResponse(result *service.QueryResult) queryResponse {
+	sources := result.Sources
+	if sources == nil {
+		sources = []service.Source{}
+	}
+	return queryResponse{Response: result.Answer, Sources: sources}
+}
+
+// uploadResponse is the JSON body returned by the upload endpoint
+type uploadResponse struct {
+	Folder string   `json:"folder"`
+	Files  []string `json:"files"`
+}
+
+// newDocumentResponse converts a document into its API representation
+func newDocumentResponse(doc *domain.Document) documentResponse {
+	return documentResponse{
+		ID:          doc.ID,
+		Path:        doc.Path,
+		Name:        doc.Name,
+		ContentType: doc.ContentType,
+		Size:        doc.Size,
+		CreatedAt:   doc.CreatedAt,
+	}
+}
+
+// newRagSummaryResponse converts the summary of a RAG system into its API
+// representation
+func newRagSummaryResponse(summary repository.RagSummary) ragResponse {
+	docs := make([]documentResponse, 0, len(summary.Documents))
+	for _, doc := range summary.Documents {
+		docs = append(docs, newDocumentResponse(doc))
+	}
+
+	return ragResponse{
+		Name:           summary.Name,
+		ModelName:      summary.ModelName,
+		EmbeddingModel: summary.EmbeddingModel,
+		Description:    summary.Description,
+		CreatedAt:      summary.CreatedAt,
+		UpdatedAt:      summary.UpdatedAt,
+		Retrieval:      summary.Retrieval,
+		Documents:      docs,
+	}
+}
+
+// newRagResponse converts a RAG system into its API representation
+func newRagResponse(rag *domain.RagSystem) ragResponse {
+	docs := make([]documentResponse, 0, len(rag.Documents))
+	for _, doc := range rag.Documents {
+		docs = append(docs, newDocumentResponse(doc))
+	}
+
+	return ragResponse{
+		Name:           rag.Name,
+		ModelName:      rag.ModelName,
+		EmbeddingModel: rag.GetEmbeddingModel(),
+		Description:    rag.Description,
+		CreatedAt:      rag.CreatedAt,
+		UpdatedAt:      rag.UpdatedAt,
+		Retrieval:      rag.GetRetrievalConfig(),
+		Documents:      docs,
+	}
+}
+
+// respondError aborts the request with a structured JSON error
+func respondError(c *gin.Context, status int, format string, args ...interface{}) {
+	c.AbortWithStatusJSON(status, errorResponse{Error: fmt.Sprintf(format, args...)})
+}
+
+// logMigration logs the document IDs migrated when a RAG created by a
+// previous version was loaded, and the documents that shared an ID
+func logMigration(ragName string, migration *domain.IDMigration) {
+	if migration == nil {
+		return
+	}
+	log.Printf("RAG '%s': document IDs of %d documents migrated to unique, path-based IDs", ragName, migration.Renamed)
+	ids := make([]string, 0, len(migration.Collisions))
+	for id := range migration.Collisions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		log.Printf("RAG '%s': documents %s shared the ID %s and are not searchable until 'rlama verify %s --repair'",
+			ragName, strings.Join(migration.Collisions[id], ", "), id, ragName)
+	}
+}
+
+// errorStatus returns the status of a failed change to a RAG: 409 if the RAG
+// is being modified by another process or request, status otherwise
+func errorStatus(err error, status int) int {
+	if errors.Is(err, repository.ErrLocked) {
+		return http.StatusConflict
+	}
+	return status
+}
+
+// queryErrorStatus returns the status of a failed query: 502 if Ollama or the
+// reranker failed, 409 if the RAG can't be searched with its embedding model,
+// as errorStatus otherwise
+func queryErrorStatus(err error) int {
+	var upstream *service.UpstreamError
+	switch {
+	case errors.As(err, &upstream):
+		return http.StatusBadGateway
+	case errors.Is(err, service.ErrIncompatibleEmbeddings):
+		return http.StatusConflict
+	}
+	return errorStatus(err, http.StatusInternalServerError)
+}
+
+// chunkingFromForm reads the optional chunking settings of a multipart form,
+// which replace those of chunking
+func chunkingFromForm(c *gin.Context, chunking domain.ChunkingConfig) (domain.ChunkingConfig, error) {
+
+	if v := c.PostForm("chunkSize"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			return chunking, fmt.Errorf("invalid chunkSize: %s", v)
+		}
+		chunking.ChunkSize = size
+	}
+	if v := c.PostForm("chunkOverlap"); v != "" {
+		overlap, err := strconv.Atoi(v)
+		if err != nil {
+			return chunking, fmt.Errorf("invalid chunkOverlap: %s", v)
+		}
+		chunking.ChunkOverlap = overlap
+	}
+	if v := c.PostForm("chunkStrategy"); v != "" {
+		chunking.Strategy = v
+	}
+
+	return chunking, chunking.Validate()
+}
+
+// retrievalFromForm reads the optional retrieval settings of a multipart form,
+// which replace those of retrieval
+func retrievalFromForm(c *gin.Context, retrieval domain.RetrievalConfig) (domain.RetrievalConfig, error) {
+
+	if v := c.PostForm("topK"); v != "" {
+		topK, err := strconv.Atoi(v)
+		if err != nil {
+			return retrieval, fmt.Errorf("invalid topK: %s", v)
+		}
+		retrieval.TopK = topK
+	}
+	if v := c.PostForm("minScore"); v != "" {
+		minScore, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return retrieval, fmt.Errorf("invalid minScore: %s", v)
+		}
+		retrieval.MinScore = minScore
+	}
+	if v := c.PostForm("contextTokens"); v != "" {
+		contextTokens, err := strconv.Atoi(v)
+		if err != nil {
+			return retrieval, fmt.Errorf("invalid contextTokens: %s", v)
+		}
+		retrieval.ContextTokens = contextTokens
+	}
+	if v := c.PostForm("vectorWeight"); v != "" {
+		weight, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return retrieval, fmt.Errorf("invalid vectorWeight: %s", v)
+		}
+		retrieval.VectorWeight = weight
+	}
+	if v := c.PostForm("keywordWeight"); v != "" {
+		weight, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return retrieval, fmt.Errorf("invalid keywordWeight: %s", v)
+		}
+		retrieval.KeywordWeight = weight
+	}
+	if v := c.PostForm("reranker"); v != "" {
+		retrieval.Rerank.Reranker = v
+		retrieval.Rerank.Candidates = domain.DefaultRerankCandidates
+	}
+	if v := c.PostForm("rerankCandidates"); v != "" {
+		candidates, err := strconv.Atoi(v)
+		if err != nil {
+			return retrieval, fmt.Errorf("invalid rerankCandidates: %s", v)
+		}
+		retrieval.Rerank.Candidates = candidates
+	}
+	retrieval.Rerank.Model = c.PostForm("rerankModel")
+	retrieval.Rerank.URL = c.PostForm("rerankUrl")
+
+	return retrieval, retrieval.Validate()
+}
+
+// saveUploadedFiles writes the multipart files of the request into folder
+func saveUploadedFiles(c *gin.Context, folder string) ([]string, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, fmt.Errorf("invalid multipart form: %w", err)
+	}
+
+	files := form.File["files"]
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files provided")
+	}
+
+	var saved []string
+	seen := make(map[string]bool)
+	for _, file := range files {
+		// Only keep the base name to prevent writing outside the folder
+		name := filepath.Base(file.Filename)
+		if name == "." || name == string(filepath.Separator) {
+			return nil, fmt.Errorf("invalid file name: %q", file.Filename)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("several files are named %s", name)
+		}
+		seen[name] = true
+
+		dst := filepath.Join(folder, name)
+		if err := saveUploadedFile(file, dst); err != nil {
+			return nil, fmt.Errorf("unable to save %s: %w", name, err)
+		}
+		saved = append(saved, name)
+	}
+
+	return saved, nil
+}
+
+// saveUploadedFile copies a single multipart file to dst
+func saveUploadedFile(file *multipart.FileHeader, dst string) error {
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, src)
+	return err
+}
+
+// errPathNotAllowed is returned for a path of the server that requests may
+// not index
+var errPathNotAllowed = errors.New("only uploaded folders and paths under the api.root setting can be indexed")
+
+// serverPath checks a path of the server that a request asks to index. Pending
+// uploads are accepted, as well as paths under root, the api.root setting.
+// Symbolic links are resolved so that they can't lead out of root.
+func serverPath(repo *repository.RagRepository, root, path string) (string, error) {
+	if repo.IsPendingUpload(path) {
+		return path, nil
+	}
+	if root == "" || !filepath.IsAbs(path) {
+		return "", fmt.Errorf("%s: %w", path, errPathNotAllowed)
+	}
+
+	root, err := filepath.EvalSymlinks(os.ExpandEnv(root))
+	if err != nil {
+		return "", fmt.Errorf("invalid api.root setting: %w", err)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to access %s", path)
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: %w", path, errPathNotAllowed)
+	}
+	return resolved, nil
+}
+
+// validRagName reports whether name can be used as the folder of a RAG
+func validRagName(name string) bool {
+	return name == filepath.Base(name) && !strings.HasPrefix(name, ".")
+}
+
+// createRag creates a RAG system from uploaded files or an existing folder
+func createRag(c *gin.Context) {
+	modelName := strings.TrimSpace(c.PostForm("modelName"))
+	ragName := strings.TrimSpace(c.PostForm("ragName"))
+	folderPath := strings.TrimSpace(c.PostForm("folderPath"))
+
+	// The configuration file gives the settings missing from the request
+	cfg, err := config.Load()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	if modelName == "" {
+		modelName = cfg.Defaults.Model
+	}
+
+	if modelName == "" || ragName == "" {
+		respondError(c, http.StatusBadRequest, "modelName and ragName are required")
+		return
+	}
+	if !validRagName(ragName) {
+		respondError(c, http.StatusBadRequest, "invalid RAG name '%s'", ragName)
+		return
+	}
+
+	chunking, err := chunkingFromForm(c, cfg.Defaults.Chunking())
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "%v", err)
+		return
+	}
+	retrieval, err := retrievalFromForm(c, cfg.Defaults.Retrieval())
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	repo := repository.NewRagRepository()
+	if repo.Exists(ragName) {
+		respondError(c, http.StatusConflict, "a RAG with name '%s' already exists", ragName)
+		return
+	}
+
+	if folderPath != "" {
+		if folderPath, err = serverPath(repo, cfg.API.Root, folderPath); err != nil {
+			respondError(c, http.StatusBadRequest, "%v", err)
+			return
+		}
+	}
+
+	// Without an explicit folder, index the files sent with the request. The
+	// RAG keeps them in its folder; they are only removed if it can't be created.
+	if folderPath == "" {
+		upload, err := repo.NewPendingUpload()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		defer os.RemoveAll(upload)
+
+		if _, err := saveUploadedFiles(c, upload); err != nil {
+			respondError(c, http.StatusBadRequest, "%v", err)
+			return
+		}
+		folderPath = upload
+	}
+
+	embeddingModel := strings.TrimSpace(c.PostForm("embeddingModel"))
+	if embeddingModel == "" {
+		embeddingModel = cfg.Defaults.EmbeddingModel
+	}
+
+	opts := service.CreateRagOptions{
+		EmbeddingModel: embeddingModel,
+		Chunking:       chunking,
+		Retrieval:      retrieval,
+	}
+
+	ragService := service.NewRagService()
+	// A client that disconnects cancels the indexing
+	if err := ragService.CreateRag(c.Request.Context(), modelName, ragName, folderPath, opts); err != nil {
+		respondError(c, errorStatus(err, http.StatusInternalServerError), "%v", err)
+		return
+	}
+
+	rag, err := ragService.LoadRag(ragName)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	defer rag.Close()
+
+	c.JSON(http.StatusCreated, newRagResponse(rag))
+}
+
+// listRags returns all available RAG systems
+func listRags(c *gin.Context) {
+	repo := repository.NewRagRepository()
+	summaries, err := repo.ListAll()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	rags := make([]ragResponse, 0, len(summaries))
+	for _, summary := range summaries {
+		if summary.Err != nil {
+			// Skip RAGs that can't be read rather than failing the whole list
+			continue
+		}
+		rags = append(rags, newRagSummaryResponse(summary))
+	}
+
+	c.JSON(http.StatusOK, rags)
+}
+
+// getRag returns the details of a RAG system
+func getRag(c *gin.Context) {
+	ragName := c.Param("name")
+
+	repo := repository.NewRagRepository()
+	if !repo.Exists(ragName) {
+		respondError(c, http.StatusNotFound, "the RAG system '%s' does not exist", ragName)
+		return
+	}
+
+	rag, err := repo.Load(ragName)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	defer rag.Close()
+	logMigration(ragName, rag.Migration)
+
+	c.JSON(http.StatusOK, newRagResponse(rag))
+}
+
+// deleteRag deletes a RAG system
+func deleteRag(c *gin.Context) {
+	ragName := c.Param("name")
+
+	repo := repository.NewRagRepository()
+	if !repo.Exists(ragName) {
+		respondError(c, http.StatusNotFound, "the RAG system '%s' does not exist", ragName)
+		return
+	}
+
+	if err := repo.Delete(ragName); err != nil {
+		respondError(c, errorStatus(err, http.StatusInternalServerError), "%v", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// addDocumentsRequest is the JSON body expected by the add documents
+// endpoint: folders returned by the upload endpoint, or files or folders of
+// the server under the api.root setting
+type addDocumentsRequest struct {
+	Paths []string `json:"paths" binding:"required"`
+}
+
+// addDocumentsResponse lists the files indexed by the add documents endpoint
+type addDocumentsResponse struct {
+	Added     []string `json:"added"`
+	Updated   []string `json:"updated"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// removeDocumentsResponse lists the documents removed from a RAG system
+type removeDocumentsResponse struct {
+	Removed []documentResponse `json:"removed"`
+}
+
+// listDocuments returns the documents of a RAG system
+func listDocuments(c *gin.Context) {
+	ragName := c.Param("name")
+
+	repo := repository.NewRagRepository()
+	if !repo.Exists(ragName) {
+		respondError(c, http.StatusNotFound, "the RAG system '%s' does not exist", ragName)
+		return
+	}
+
+	docs, err := service.NewRagService().ListDocuments(ragName)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, docs)
+}
+
+// addDocuments indexes files or folders of the server into a RAG system
+func addDocuments(c *gin.Context) {
+	ragName := c.Param("name")
+
+	var req addDocumentsRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Paths) == 0 {
+		respondError(c, http.StatusBadRequest, "a non-empty 'paths' field is required")
+		return
+	}
+
+	repo := repository.NewRagRepository()
+	if !repo.Exists(ragName) {
+		respondError(c, http.StatusNotFound, "the RAG system '%s' does not exist", ragName)
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	paths := make([]string, 0, len(req.Paths))
+	for _, path := range req.Paths {
+		path, err := serverPath(repo, cfg.API.Root, path)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "%v", err)
+			return
+		}
+		paths = append(paths, path)
+	}
+
+	// A client that disconnects cancels the indexing
+	report, err := service.NewRagService().AddDocuments(c.Request.Context(), ragName, paths)
+	if err != nil {
+		respondError(c, errorStatus(err, http.StatusInternalServerError), "%v", err)
+		return
+	}
+	logMigration(ragName, report.Migration)
+
+	resp := addDocumentsResponse{Added: report.Added, Updated: report.Updated, Unchanged: report.Unchanged}
+	if resp.Added == nil {
+		resp.Added = []string{}
+	}
+	if resp.Updated == nil {
+		resp.Updated = []string{}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// removeDocuments removes the documents matching an ID or a glob from a RAG system
+func removeDocuments(c *gin.Context) {
+	ragName := c.Param("name")
+	pattern := strings.TrimPrefix(c.Param("pattern"), "/")
+	if pattern == "" {
+		respondError(c, http.StatusBadRequest, "a document ID or glob is required")
+		return
+	}
+
+	repo := repository.NewRagRepository()
+	if !repo.Exists(ragName) {
+		respondError(c, http.StatusNotFound, "the RAG system '%s' does not exist", ragName)
+		return
+	}
+
+	removed, err := service.NewRagService().RemoveDocuments(ragName, pattern)
+	if errors.Is(err, service.ErrNoMatchingDocument) {
+		respondError(c, http.StatusNotFound, "%v", err)
+		return
+	}
+	if err != nil {
+		respondError(c, errorStatus(err, http.StatusBadRequest), "%v", err)
+		return
+	}
+
+	resp := removeDocumentsResponse{Removed: make([]documentResponse, 0, len(removed))}
+	for _, doc := range removed {
+		resp.Removed = append(resp.Removed, newDocumentResponse(doc))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// queryRag answers a question using a RAG system
+func queryRag(c *gin.Context) {
+	ragName := c.Param("name")
+
+	var req queryRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Query) == "" {
+		respondError(c, http.StatusBadRequest, "a non-empty 'query' field is required")
+		return
+	}
+
+	repo := repository.NewRagRepository()
+	if !repo.Exists(ragName) {
+		respondError(c, http.StatusNotFound, "the RAG system '%s' does not exist", ragName)
+		return
+	}
+
+	ragService := service.NewRagService()
+	rag, err := ragService.LoadRag(ragName)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	defer rag.Close()
+	logMigration(ragName, rag.Migration)
+
+	retrieval, err := req.retrieval(rag)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	result, err := ragService.Query(c.Request.Context(), rag, req.Query, retrieval)
+	if err != nil {
+		respondError(c, queryErrorStatus(err), "%v", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newQueryResponse(result))
+}
+
+// queryRagStream answers a question using a RAG system and streams the
+// answer as Server-Sent Events: one "token" event per generated piece,
+// followed by a "done" event holding the answer and its sources, or an
+// "error" event
+func queryRagStream(c *gin.Context) {
+	ragName := c.Param("name")
+
+	var req queryRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Query) == "" {
+		respondError(c, http.StatusBadRequest, "a non-empty 'query' field is required")
+		return
+	}
+
+	repo := repository.NewRagRepository()
+	if !repo.Exists(ragName) {
+		respondError(c, http.StatusNotFound, "the RAG system '%s' does not exist", ragName)
+		return
+	}
+
+	ragService := service.NewRagService()
+	rag, err := ragService.LoadRag(ragName)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	defer rag.Close()
+	logMigration(ragName, rag.Migration)
+
+	retrieval, err := req.retrieval(rag)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+
+	result, err := ragService.QueryStream(c.Request.Context(), rag, req.Query, retrieval, func(token string) error {
+		// Stop generating as soon as the client goes away
+		if err := c.Request.Context().Err(); err != nil {
+			return err
+		}
+		c.SSEvent("token", token)
+		c.Writer.Flush()
+		return nil
+	})
+	if err != nil {
+		c.SSEvent("error", errorResponse{Error: err.Error()})
+		c.Writer.Flush()
+		return
+	}
+
+	c.SSEvent("done", newQueryResponse(result))
+	c.Writer.Flush()
+}
+
+// handleFileUpload stores uploaded files in a new folder that can then be
+// passed as folderPath when creating a RAG, or in the paths of documents to
+// add. Folders that are not used within a day are removed.
+func handleFileUpload(c *gin.Context) {
+	folder, err := repository.NewRagRepository().NewPendingUpload()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	files, err := saveUploadedFiles(c, folder)
+	if err != nil {
+		os.RemoveAll(folder)
+		respondError(c, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, uploadResponse{Folder: folder, Files: files})
+}
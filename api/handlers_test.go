@@ -0,0 +1,390 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"hash/fnv"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golvellius32/rlama/internal/client"
+	"github.com/golvellius32/rlama/internal/repository"
+)
+
+// fakeOllama answers the requests of the Ollama client: embeddings are bags
+// of hashed words, answers repeat the end of the prompt. /api/embed is not
+// provided, so embeddings are requested one by one.
+type fakeOllama struct {
+	mu sync.Mutex
+	// dimension of the embeddings, 16 by default
+	dimension int
+	// failGeneration makes generation requests fail with a 500
+	failGeneration bool
+}
+
+func (f *fakeOllama) settings() (int, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.dimension, f.failGeneration
+}
+
+func (f *fakeOllama) embed(text string) []float32 {
+	dimension, _ := f.settings()
+	vector := make([]float32, dimension)
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		vector[h.Sum32()%uint32(dimension)]++
+	}
+	return vector
+}
+
+func (f *fakeOllama) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req map[string]interface{}
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	_, failGeneration := f.settings()
+
+	switch r.URL.Path {
+	case "/api/version":
+		json.NewEncoder(w).Encode(map[string]string{"version": "0.1.0"})
+	case "/api/embeddings":
+		prompt, _ := req["prompt"].(string)
+		json.NewEncoder(w).Encode(map[string]interface{}{"embedding": f.embed(prompt)})
+	case "/api/generate", "/api/chat":
+		if failGeneration {
+			http.Error(w, `{"error":"model crashed"}`, http.StatusInternalServerError)
+			return
+		}
+		prompt, _ := json.Marshal(req)
+		answer := "Answer: " + string(prompt[len(prompt)-min(len(prompt), 30):])
+		if stream, ok := req["stream"].(bool); !ok || stream {
+			// A single piece, then the end of the stream
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"response": answer, "message": map[string]string{"role": "assistant", "content": answer}, "done": false,
+			})
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"response": "", "message": map[string]string{"role": "assistant", "content": ""}, "done": true,
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"response": answer, "message": map[string]string{"role": "assistant", "content": answer}, "done": true,
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// testServer is the API router with its data folder and fake Ollama
+type testServer struct {
+	router  *gin.Engine
+	ollama  *fakeOllama
+	dataDir string
+	home    string
+}
+
+// newTestServer sets up the API with an empty data folder and a fake Ollama
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	dataDir := filepath.Join(home, "data")
+	repository.SetDataDir(dataDir)
+	t.Cleanup(func() { repository.SetDataDir("") })
+
+	ollama := &fakeOllama{dimension: 16}
+	server := httptest.NewServer(ollama)
+	t.Cleanup(server.Close)
+	config := client.DefaultConfig()
+	config.Host = server.URL
+	config.Retries = 0
+	client.SetDefaultConfig(config)
+	t.Cleanup(func() { client.SetDefaultConfig(client.DefaultConfig()) })
+
+	return &testServer{router: SetupRouter(), ollama: ollama, dataDir: dataDir, home: home}
+}
+
+// upload is a file sent in a multipart request
+type upload struct {
+	name    string
+	content string
+}
+
+// multipartRequest builds a multipart POST request with form fields and files
+func multipartRequest(t *testing.T, url string, fields map[string]string, files ...upload) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	for _, file := range files {
+		part, err := writer.CreateFormFile("files", file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(file.content))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, url, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// jsonRequest builds a request with a JSON body
+func jsonRequest(method, url string, body interface{}) *http.Request {
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, url, reader)
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// do sends a request to the API, checks its status and decodes its body into out
+func (s *testServer) do(t *testing.T, req *http.Request, status int, out interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	if rec.Code != status {
+		t.Fatalf("%s %s: got status %d, want %d: %s", req.Method, req.URL.Path, rec.Code, status, rec.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: invalid JSON body %q: %v", req.Method, req.URL.Path, rec.Body.String(), err)
+		}
+	}
+}
+
+// expectError sends a request that must fail with status and a JSON error body
+func (s *testServer) expectError(t *testing.T, req *http.Request, status int) string {
+	t.Helper()
+	var resp errorResponse
+	s.do(t, req, status, &resp)
+	if resp.Error == "" {
+		t.Fatalf("%s %s: empty error message", req.Method, req.URL.Path)
+	}
+	return resp.Error
+}
+
+// createUploadedRag creates a RAG from uploaded files
+func (s *testServer) createUploadedRag(t *testing.T, name string) ragResponse {
+	t.Helper()
+	var rag ragResponse
+	s.do(t, multipartRequest(t, "/api/rag", map[string]string{"modelName": "llama3", "ragName": name},
+		upload{"install.md", "# Install\n\nRun make install to install the tool."},
+		upload{"errors.txt", "Error E1234 means the disk is full."},
+	), http.StatusCreated, &rag)
+	return rag
+}
+
+func TestCreateListGetDeleteRag(t *testing.T) {
+	s := newTestServer(t)
+
+	rag := s.createUploadedRag(t, "docs")
+	if rag.Name != "docs" || rag.ModelName != "llama3" || len(rag.Documents) != 2 {
+		t.Fatalf("unexpected RAG: %+v", rag)
+	}
+	// Uploaded files are kept with the RAG, so that it can be updated
+	uploads := filepath.Join(s.dataDir, "docs", "uploads")
+	for _, doc := range rag.Documents {
+		if filepath.Dir(doc.Path) != uploads {
+			t.Fatalf("document %s is not in %s", doc.Path, uploads)
+		}
+		if _, err := os.Stat(doc.Path); err != nil {
+			t.Fatalf("uploaded file removed: %v", err)
+		}
+	}
+
+	var rags []ragResponse
+	s.do(t, jsonRequest(http.MethodGet, "/api/rag", nil), http.StatusOK, &rags)
+	if len(rags) != 1 || rags[0].Name != "docs" || len(rags[0].Documents) != 2 {
+		t.Fatalf("unexpected list: %+v", rags)
+	}
+
+	var detail ragResponse
+	s.do(t, jsonRequest(http.MethodGet, "/api/rag/docs", nil), http.StatusOK, &detail)
+	if detail.Name != "docs" || len(detail.Documents) != 2 {
+		t.Fatalf("unexpected detail: %+v", detail)
+	}
+
+	var docs []map[string]interface{}
+	s.do(t, jsonRequest(http.MethodGet, "/api/rag/docs/documents", nil), http.StatusOK, &docs)
+	if len(docs) != 2 {
+		t.Fatalf("got %d documents, want 2", len(docs))
+	}
+
+	s.do(t, jsonRequest(http.MethodDelete, "/api/rag/docs", nil), http.StatusNoContent, nil)
+	s.expectError(t, jsonRequest(http.MethodGet, "/api/rag/docs", nil), http.StatusNotFound)
+	s.expectError(t, jsonRequest(http.MethodDelete, "/api/rag/docs", nil), http.StatusNotFound)
+	if _, err := os.Stat(uploads); !os.IsNotExist(err) {
+		t.Fatal("uploaded files not deleted with the RAG")
+	}
+}
+
+func TestCreateRagErrors(t *testing.T) {
+	s := newTestServer(t)
+	s.createUploadedRag(t, "docs")
+
+	s.expectError(t, multipartRequest(t, "/api/rag", map[string]string{"modelName": "llama3"},
+		upload{"a.txt", "a"}), http.StatusBadRequest)
+	s.expectError(t, multipartRequest(t, "/api/rag", map[string]string{"modelName": "llama3", "ragName": "../escape"},
+		upload{"a.txt", "a"}), http.StatusBadRequest)
+	s.expectError(t, multipartRequest(t, "/api/rag", map[string]string{"modelName": "llama3", "ragName": "docs"},
+		upload{"a.txt", "a"}), http.StatusConflict)
+	s.expectError(t, multipartRequest(t, "/api/rag", map[string]string{"modelName": "llama3", "ragName": "other", "chunkSize": "x"},
+		upload{"a.txt", "a"}), http.StatusBadRequest)
+	s.expectError(t, multipartRequest(t, "/api/rag", map[string]string{"modelName": "llama3", "ragName": "other"}),
+		http.StatusBadRequest)
+
+	msg := s.expectError(t, multipartRequest(t, "/api/rag", map[string]string{"modelName": "llama3", "ragName": "other"},
+		upload{"a.txt", "first"}, upload{"a.txt", "second"}), http.StatusBadRequest)
+	if !strings.Contains(msg, "a.txt") {
+		t.Fatalf("unexpected error for duplicate names: %s", msg)
+	}
+
+	// Files of the server can't be indexed without api.root
+	s.expectError(t, multipartRequest(t, "/api/rag", map[string]string{"modelName": "llama3", "ragName": "other", "folderPath": "/etc"}),
+		http.StatusBadRequest)
+
+	// Nothing is left behind by the failed creations
+	if _, err := os.Stat(filepath.Join(s.dataDir, "other")); !os.IsNotExist(err) {
+		t.Fatal("a failed creation left the RAG folder")
+	}
+}
+
+func TestCreateRagUnderRoot(t *testing.T) {
+	s := newTestServer(t)
+
+	root := filepath.Join(s.home, "documents")
+	os.MkdirAll(filepath.Join(root, "manuals"), 0755)
+	os.WriteFile(filepath.Join(root, "manuals", "install.md"), []byte("Run make install."), 0644)
+	os.MkdirAll(filepath.Join(s.home, ".config", "rlama"), 0755)
+	os.WriteFile(filepath.Join(s.home, ".config", "rlama", "config.yaml"), []byte("api:\n  root: "+root+"\n"), 0644)
+	// A link can't lead out of the root
+	os.Symlink(s.home, filepath.Join(root, "home"))
+
+	var rag ragResponse
+	s.do(t, multipartRequest(t, "/api/rag", map[string]string{"modelName": "llama3", "ragName": "manuals", "folderPath": filepath.Join(root, "manuals")}),
+		http.StatusCreated, &rag)
+	if len(rag.Documents) != 1 {
+		t.Fatalf("got %d documents, want 1", len(rag.Documents))
+	}
+
+	s.expectError(t, multipartRequest(t, "/api/rag", map[string]string{"modelName": "llama3", "ragName": "home", "folderPath": filepath.Join(root, "home")}),
+		http.StatusBadRequest)
+	s.expectError(t, multipartRequest(t, "/api/rag", map[string]string{"modelName": "llama3", "ragName": "up", "folderPath": filepath.Join(root, "..")}),
+		http.StatusBadRequest)
+}
+
+func TestUploadAndAddDocuments(t *testing.T) {
+	s := newTestServer(t)
+	s.createUploadedRag(t, "docs")
+
+	var uploaded uploadResponse
+	s.do(t, multipartRequest(t, "/api/upload", nil, upload{"faq.md", "Use brew install on macOS."}), http.StatusCreated, &uploaded)
+	if len(uploaded.Files) != 1 || uploaded.Files[0] != "faq.md" {
+		t.Fatalf("unexpected upload: %+v", uploaded)
+	}
+
+	s.expectError(t, jsonRequest(http.MethodPost, "/api/rag/docs/documents", map[string]interface{}{"paths": []string{"/etc/passwd"}}),
+		http.StatusBadRequest)
+	s.expectError(t, jsonRequest(http.MethodPost, "/api/rag/docs/documents", map[string]interface{}{"paths": []string{}}),
+		http.StatusBadRequest)
+	s.expectError(t, jsonRequest(http.MethodPost, "/api/rag/missing/documents", map[string]interface{}{"paths": []string{uploaded.Folder}}),
+		http.StatusNotFound)
+
+	var added addDocumentsResponse
+	s.do(t, jsonRequest(http.MethodPost, "/api/rag/docs/documents", map[string]interface{}{"paths": []string{uploaded.Folder}}),
+		http.StatusOK, &added)
+	if len(added.Added) != 1 || !strings.HasPrefix(added.Added[0], filepath.Join(s.dataDir, "docs", "uploads")) {
+		t.Fatalf("unexpected added documents: %+v", added)
+	}
+	if _, err := os.Stat(uploaded.Folder); !os.IsNotExist(err) {
+		t.Fatal("the upload was not moved into the RAG")
+	}
+
+	// An upload from which nothing is indexed is not kept
+	var empty uploadResponse
+	s.do(t, multipartRequest(t, "/api/upload", nil, upload{"empty.md", " \n"}), http.StatusCreated, &empty)
+	var none addDocumentsResponse
+	s.do(t, jsonRequest(http.MethodPost, "/api/rag/docs/documents", map[string]interface{}{"paths": []string{empty.Folder}}),
+		http.StatusOK, &none)
+	if len(none.Added) != 0 || len(none.Updated) != 0 {
+		t.Fatalf("unexpected added documents: %+v", none)
+	}
+	if _, err := os.Stat(filepath.Join(s.dataDir, "docs", "uploads", filepath.Base(empty.Folder))); !os.IsNotExist(err) {
+		t.Fatal("an upload from which nothing was indexed was kept in the RAG")
+	}
+
+	var removed removeDocumentsResponse
+	s.do(t, jsonRequest(http.MethodDelete, "/api/rag/docs/documents/**/faq.md", nil), http.StatusOK, &removed)
+	if len(removed.Removed) != 1 {
+		t.Fatalf("got %d removed documents, want 1", len(removed.Removed))
+	}
+	s.expectError(t, jsonRequest(http.MethodDelete, "/api/rag/docs/documents/nothing*", nil), http.StatusNotFound)
+}
+
+func TestQueryRag(t *testing.T) {
+	s := newTestServer(t)
+	s.createUploadedRag(t, "docs")
+
+	var resp queryResponse
+	s.do(t, jsonRequest(http.MethodPost, "/api/query/docs", map[string]interface{}{"query": "what does E1234 mean"}), http.StatusOK, &resp)
+	if resp.Response == "" || len(resp.Sources) == 0 {
+		t.Fatalf("unexpected answer: %+v", resp)
+	}
+	if resp.Sources[0].Name != "errors.txt" {
+		t.Fatalf("got source %s first, want errors.txt", resp.Sources[0].Name)
+	}
+
+	// The minimum similarity doesn't drop passages found by their keywords
+	var strict queryResponse
+	s.do(t, jsonRequest(http.MethodPost, "/api/query/docs", map[string]interface{}{"query": "E1234", "min_score": 0.99}), http.StatusOK, &strict)
+	if len(strict.Sources) != 1 || strict.Sources[0].Name != "errors.txt" {
+		t.Fatalf("got sources %+v, want errors.txt only", strict.Sources)
+	}
+	var vectorOnly queryResponse
+	s.do(t, jsonRequest(http.MethodPost, "/api/query/docs", map[string]interface{}{"query": "E1234", "min_score": 0.99, "keyword_weight": 0}), http.StatusOK, &vectorOnly)
+	if len(vectorOnly.Sources) != 0 {
+		t.Fatalf("got %d sources below the minimum similarity", len(vectorOnly.Sources))
+	}
+
+	s.expectError(t, jsonRequest(http.MethodPost, "/api/query/docs", map[string]interface{}{"query": " "}), http.StatusBadRequest)
+	s.expectError(t, jsonRequest(http.MethodPost, "/api/query/docs", map[string]interface{}{"query": "install", "top_k": 0}), http.StatusBadRequest)
+	s.expectError(t, jsonRequest(http.MethodPost, "/api/query/docs", map[string]interface{}{"query": "install", "filter": "(("}), http.StatusBadRequest)
+	s.expectError(t, jsonRequest(http.MethodPost, "/api/query/missing", map[string]interface{}{"query": "install"}), http.StatusNotFound)
+
+	// Only failures of Ollama are reported as a bad gateway
+	s.ollama.mu.Lock()
+	s.ollama.failGeneration = true
+	s.ollama.mu.Unlock()
+	s.expectError(t, jsonRequest(http.MethodPost, "/api/query/docs", map[string]interface{}{"query": "install"}), http.StatusBadGateway)
+
+	s.ollama.mu.Lock()
+	s.ollama.failGeneration = false
+	s.ollama.dimension = 8
+	s.ollama.mu.Unlock()
+	// Questions are embedded again: their embeddings are not cached
+	s.expectError(t, jsonRequest(http.MethodPost, "/api/query/docs", map[string]interface{}{"query": "what does E1234 mean"}), http.StatusConflict)
+}
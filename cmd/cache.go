@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/golvellius32/rlama/internal/repository"
+	"github.com/spf13/cobra"
+)
+
+var cacheModel string
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the embedding cache",
+	Long: `Embeddings are cached on disk, keyed by embedding model and text, so that
+re-creating a RAG or indexing the same file into several RAGs doesn't ask
+Ollama again. The least recently used entries are evicted once the cache
+reaches its size limit.`,
+}
+
+var cacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show the size and content of the embedding cache",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cache := repository.NewEmbeddingCache()
+		stats, err := cache.Stats()
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Location: %s\n", cache.Dir())
+		fmt.Printf("Entries:  %d\n", stats.Entries)
+		fmt.Printf("Size:     %s of %s\n", formatBytes(stats.Bytes), formatBytes(stats.MaxBytes))
+		if stats.Entries == 0 {
+			return nil
+		}
+		fmt.Printf("Last used: %s (oldest entry %s)\n",
+			stats.Newest.Format("2006-01-02 15:04:05"), stats.Oldest.Format("2006-01-02 15:04:05"))
+
+		models := make([]string, 0, len(stats.Models))
+		for model := range stats.Models {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "MODEL\tENTRIES")
+		for _, model := range models {
+			fmt.Fprintf(w, "%s\t%d\n", model, stats.Models[model])
+		}
+		return w.Flush()
+	},
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove the cached embeddings",
+	Long: `Remove all cached embeddings, or only those of one embedding model with --model.
+RAG systems are not affected: they keep their own copy of their embeddings.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		removed, err := repository.NewEmbeddingCache().Clear(cacheModel)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Removed %d cached embeddings.\n", removed)
+		return nil
+	},
+}
+
+// formatBytes formats a size with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheStatsCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+	cacheClearCmd.Flags().StringVar(&cacheModel, "model", "", "Only remove the embeddings of this model")
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/golvellius32/rlama/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show or edit the configuration file",
+	Long: `Show or edit the configuration file, ~/.config/rlama/config.yaml (or
+$XDG_CONFIG_HOME/rlama/config.yaml). It sets where RAG systems are stored,
+how Ollama is reached and the defaults of new RAG systems.
+
+Settings: ` + strings.Join(config.Keys(), ", ") + `
+Example: rlama config set defaults.model llama3.2`,
+	// The configuration file is edited even if it is invalid, to fix it
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get [key]",
+	Short: "Print the value of a setting",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		value, ok, err := config.Get(args[0])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s is not set in %s", args[0], config.Path())
+		}
+		fmt.Println(value)
+		return nil
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set [key] [value]",
+	Short: "Change a setting",
+	Long: `Change a setting of the configuration file, which is created if needed.
+An empty value removes the setting. Comments of the file are kept.
+Example: rlama config set ollama.headers.Authorization 'Bearer ${OLLAMA_TOKEN}'`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := config.Set(args[0], args[1]); err != nil {
+			return err
+		}
+		if args[1] == "" {
+			fmt.Printf("%s removed from %s.\n", args[0], config.Path())
+		} else {
+			fmt.Printf("%s set to '%s' in %s.\n", args[0], args[1], config.Path())
+		}
+		return nil
+	},
+}
+
+var configListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the settings of the configuration file",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		settings, err := config.List()
+		if err != nil {
+			return err
+		}
+		if len(settings) == 0 {
+			fmt.Printf("No settings in %s.\n", config.Path())
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, setting := range settings {
+			fmt.Fprintf(w, "%s\t%s\n", setting.Key, setting.Value)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configListCmd)
+}
@@ -13,14 +13,15 @@ var forceDelete bool
 var deleteCmd = &cobra.Command{
 	Use:   "delete [rag-name]",
 	Short: "Delete a RAG system",
-	Long:  `Permanently delete a RAG system and all its indexed documents.`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Permanently delete a RAG system and all its indexed documents.
+Also deletes the embeddings kept by an interrupted 'rlama rag'.`,
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ragName := args[0]
 		repo := repository.NewRagRepository()
 
 		// Check if the RAG exists
-		if !repo.Exists(ragName) {
+		if !repo.Exists(ragName) && !repo.StagingExists(ragName) {
 			return fmt.Errorf("the RAG system '%s' does not exist", ragName)
 		}
 
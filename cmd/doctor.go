@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/golvellius32/rlama/internal/client"
+	"github.com/golvellius32/rlama/internal/service"
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the tools available to RLAMA",
+	Long: `Check that Ollama is reachable and report, for each supported file format,
+which text extractors can be used and which external programs are missing.
+
+RLAMA never installs anything by itself: install the missing programs with your
+package manager (or scripts/install_deps.sh) to improve extraction.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ollamaClient := client.NewOllamaClient()
+		if _, err := ollamaClient.IsOllamaRunning(context.Background()); err != nil {
+			fmt.Printf("Ollama: not reachable at %s (%v)\n\n", ollamaClient.BaseURL, err)
+		} else {
+			fmt.Printf("Ollama: running at %s\n\n", ollamaClient.BaseURL)
+		}
+
+		registry := service.DefaultExtractorRegistry()
+
+		// Group the formats that share the same extractors
+		var groups [][]string
+		groupOf := make(map[string]int)
+		for _, ext := range registry.Extensions() {
+			var names []string
+			for _, e := range registry.Extractors(ext) {
+				names = append(names, e.Name())
+			}
+			key := strings.Join(names, ",")
+			if i, ok := groupOf[key]; ok {
+				groups[i] = append(groups[i], ext)
+				continue
+			}
+			groupOf[key] = len(groups)
+			groups = append(groups, []string{ext})
+		}
+
+		missingBinaries := make(map[string]bool)
+		for _, exts := range groups {
+			fmt.Println(strings.Join(exts, " "))
+
+			// Use tabwriter for aligned display
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, e := range registry.Extractors(exts[0]) {
+				status := "available"
+				if missing := service.MissingBinaries(e); len(missing) > 0 {
+					status = "missing " + strings.Join(missing, ", ")
+					for _, bin := range missing {
+						missingBinaries[bin] = true
+					}
+				} else if bins := e.RequiredBinaries(); len(bins) > 0 {
+					status = "available (" + strings.Join(bins, ", ") + ")"
+				}
+				fmt.Fprintf(w, "  %s\t%s\n", e.Name(), status)
+			}
+			w.Flush()
+		}
+
+		if len(missingBinaries) == 0 {
+			fmt.Println("\nAll extractors are available.")
+		} else {
+			fmt.Printf("\n%d external programs are missing. Extractors that need them are skipped.\n", len(missingBinaries))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
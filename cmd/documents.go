@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/golvellius32/rlama/internal/service"
+	"github.com/spf13/cobra"
+)
+
+var forceRemoveDoc bool
+
+var addDocsCmd = &cobra.Command{
+	Use:   "add-docs [rag-name] [path...]",
+	Short: "Add files or folders to an existing RAG system",
+	Long: `Index files into an existing RAG system. Folders are walked for supported files.
+Files that are already indexed are re-indexed if they changed.
+Example: rlama add-docs rag1 ~/Downloads/report.pdf ./notes
+
+Files outside the source folder of the RAG are kept by 'rlama update-rag' as
+long as they exist. Press Ctrl-C to stop: the RAG is left unchanged.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ragName := args[0]
+
+		// Ctrl-C cancels the indexing instead of killing the process
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		ragService := service.NewRagService()
+		ragService.SetEmbeddingOptions(service.EmbeddingOptions{
+			Concurrency: embedConcurrency,
+			Progress:    newProgressBar("Embedding").Update,
+		})
+		report, err := ragService.AddDocuments(ctx, ragName, args[1:])
+		if errors.Is(err, context.Canceled) {
+			return fmt.Errorf("indexing interrupted, RAG '%s' was left unchanged", ragName)
+		}
+		if err != nil {
+			return err
+		}
+
+		printMigration(ragName, report.Migration)
+		if report.Migration != nil && len(report.Migration.Collisions) > 0 {
+			fmt.Printf("Run 'rlama verify %s --repair' to index them again.\n", ragName)
+		}
+		for _, path := range report.Added {
+			fmt.Printf("+ %s\n", path)
+		}
+		for _, path := range report.Updated {
+			fmt.Printf("~ %s\n", path)
+		}
+
+		if !report.HasChanges() {
+			fmt.Printf("RAG '%s' already contains these documents (%d unchanged).\n", ragName, report.Unchanged)
+			return nil
+		}
+
+		fmt.Printf("RAG '%s' updated: %d added, %d modified, %d unchanged.\n",
+			ragName, len(report.Added), len(report.Updated), report.Unchanged)
+		return nil
+	},
+}
+
+var removeDocCmd = &cobra.Command{
+	Use:   "remove-doc [rag-name] [doc-id|glob]",
+	Short: "Remove documents from a RAG system",
+	Long: `Remove a document from a RAG system, by its ID as shown by 'rlama list-docs',
+or all documents whose path relative to the source folder matches a glob
+(* does not cross folders, ** does). The files themselves are not deleted.
+Example: rlama remove-doc rag1 'drafts/**'`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ragName, pattern := args[0], args[1]
+		ragService := service.NewRagService()
+
+		// Ask for confirmation unless --force is specified
+		if !forceRemoveDoc {
+			rag, err := ragService.LoadRag(ragName)
+			if err != nil {
+				return err
+			}
+			docs, err := rag.FindDocuments(pattern)
+			rag.Close()
+			if err != nil {
+				return err
+			}
+			if len(docs) == 0 {
+				return fmt.Errorf("%w in RAG '%s' for '%s'", service.ErrNoMatchingDocument, ragName, pattern)
+			}
+
+			for _, doc := range docs {
+				fmt.Printf("  %s\n", doc.ID)
+			}
+			fmt.Printf("Remove these %d documents from the RAG system '%s'? (y/n): ", len(docs), ragName)
+			var response string
+			fmt.Scanln(&response)
+
+			response = strings.ToLower(strings.TrimSpace(response))
+			if response != "y" && response != "yes" {
+				fmt.Println("Removal cancelled.")
+				return nil
+			}
+		}
+
+		removed, err := ragService.RemoveDocuments(ragName, pattern)
+		if err != nil {
+			return err
+		}
+
+		for _, doc := range removed {
+			fmt.Printf("- %s\n", doc.Path)
+		}
+		fmt.Printf("%d documents removed from RAG '%s'.\n", len(removed), ragName)
+		return nil
+	},
+}
+
+var listDocsCmd = &cobra.Command{
+	Use:   "list-docs [rag-name]",
+	Short: "List the documents of a RAG system",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ragName := args[0]
+
+		docs, err := service.NewRagService().ListDocuments(ragName)
+		if err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			fmt.Printf("RAG '%s' has no documents.\n", ragName)
+			return nil
+		}
+
+		fmt.Printf("Documents of RAG '%s' (%d found):\n\n", ragName, len(docs))
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tCHUNKS\tSIZE\tMODIFIED")
+		for _, doc := range docs {
+			modified := "-"
+			if !doc.ModifiedAt.IsZero() {
+				modified = doc.ModifiedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", doc.ID, doc.Chunks, formatBytes(doc.Size), modified)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(addDocsCmd)
+	rootCmd.AddCommand(removeDocCmd)
+	rootCmd.AddCommand(listDocsCmd)
+	addDocsCmd.Flags().IntVar(&embedConcurrency, "concurrency", service.DefaultEmbeddingConcurrency, "Number of embedding requests sent to Ollama in parallel")
+	removeDocCmd.Flags().BoolVarP(&forceRemoveDoc, "force", "f", false, "Remove without asking for confirmation")
+}
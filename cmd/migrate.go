@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golvellius32/rlama/internal/repository"
+	"github.com/spf13/cobra"
+)
+
+var migrateTo string
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate [rag-name...]",
+	Short: "Convert RAG systems to another storage",
+	Long: `Convert RAG systems to another storage: json keeps each part of a RAG in its
+own file, sqlite keeps the whole RAG in a single database that saves only
+rewrite where documents changed, which suits large RAG systems.
+All RAG systems are converted unless names are given.
+Set 'storage: sqlite' in the configuration file to create new RAG systems
+in SQLite.
+Example: rlama migrate --to sqlite`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		to, err := repository.StorageByName(migrateTo)
+		if err != nil {
+			return err
+		}
+		repo := repository.NewRagRepository()
+
+		ragNames := args
+		if len(ragNames) == 0 {
+			summaries, err := repo.ListAll()
+			if err != nil {
+				return err
+			}
+			for _, summary := range summaries {
+				ragNames = append(ragNames, summary.Name)
+			}
+		}
+		if len(ragNames) == 0 {
+			fmt.Println("No RAG systems found.")
+			return nil
+		}
+
+		failed := 0
+		for _, ragName := range ragNames {
+			from := repo.StorageOf(ragName)
+			if from == nil {
+				fmt.Printf("Error: RAG '%s' does not exist\n", ragName)
+				failed++
+				continue
+			}
+			if from.Name() == to.Name() {
+				fmt.Printf("RAG '%s' already uses %s.\n", ragName, to.Name())
+				continue
+			}
+			if err := repo.Convert(ragName, to); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				failed++
+				continue
+			}
+			fmt.Printf("RAG '%s' converted from %s to %s.\n", ragName, from.Name(), to.Name())
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d of %d RAG systems could not be converted", failed, len(ragNames))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+	migrateCmd.Flags().StringVar(&migrateTo, "to", "", "Storage to convert to ("+strings.Join(repository.StorageNames(), ", ")+")")
+	migrateCmd.MarkFlagRequired("to")
+}
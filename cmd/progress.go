@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// progressBarWidth is the number of characters of the bar itself
+const progressBarWidth = 30
+
+// progressBar prints the progress of a long operation with an estimate of the
+// remaining time. On a terminal the bar is redrawn in place; otherwise a line
+// is printed every 10%.
+type progressBar struct {
+	label       string
+	start       time.Time
+	terminal    bool
+	lastDraw    time.Time
+	lastPercent int
+}
+
+// newProgressBar creates a progress bar starting now
+func newProgressBar(label string) *progressBar {
+	terminal := false
+	if info, err := os.Stdout.Stat(); err == nil {
+		terminal = info.Mode()&os.ModeCharDevice != 0
+	}
+	return &progressBar{
+		label:       label,
+		start:       time.Now(),
+		terminal:    terminal,
+		lastPercent: -1,
+	}
+}
+
+// Update shows that done items out of total are finished
+func (p *progressBar) Update(done, total int) {
+	if total <= 0 {
+		return
+	}
+	percent := done * 100 / total
+	finished := done >= total
+
+	if p.terminal {
+		// Redrawing more often than this only makes the terminal flicker
+		if !finished && time.Since(p.lastDraw) < 100*time.Millisecond {
+			return
+		}
+		p.lastDraw = time.Now()
+
+		filled := progressBarWidth * done / total
+		bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+		fmt.Printf("\r%s [%s] %d/%d %3d%% %s", p.label, bar, done, total, percent, p.remaining(done, total))
+		if finished {
+			fmt.Println()
+		}
+		return
+	}
+
+	if percent/10 == p.lastPercent/10 && !finished {
+		return
+	}
+	p.lastPercent = percent
+	fmt.Printf("%s: %d/%d (%d%%) %s\n", p.label, done, total, percent, p.remaining(done, total))
+}
+
+// remaining estimates the time left from the average speed so far
+func (p *progressBar) remaining(done, total int) string {
+	elapsed := time.Since(p.start)
+	if done >= total {
+		return fmt.Sprintf("done in %s", elapsed.Round(time.Second))
+	}
+	if done == 0 {
+		return "ETA --"
+	}
+	eta := time.Duration(float64(elapsed) / float64(done) * float64(total-done))
+	return fmt.Sprintf("ETA %s", eta.Round(time.Second))
+}
@@ -1,40 +1,149 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
 	"strings"
 
 	"github.com/golvellius32/rlama/internal/client"
+	"github.com/golvellius32/rlama/internal/config"
+	"github.com/golvellius32/rlama/internal/domain"
 	"github.com/golvellius32/rlama/internal/service"
+	"github.com/golvellius32/rlama/pkg/vector"
 	"github.com/spf13/cobra"
 )
 
+var (
+	embeddingModel string
+	chunkSize      int
+	chunkOverlap   int
+	chunkStrategy  string
+	indexConfig    vector.HNSWConfig
+	promptTemplate string
+	retrieval      domain.RetrievalConfig
+	// embedConcurrency is shared by the commands that embed documents
+	embedConcurrency int
+	resumeIndexing   bool
+)
+
 var ragCmd = &cobra.Command{
-	Use:   "rag [model] [rag-name] [folder-path]",
+	Use:   "rag [model] [rag-name] [folder-path] | rag --resume [rag-name]",
 	Short: "Create a new RAG system",
 	Long: `Create a new RAG system by indexing all documents in the specified folder.
 Example: rlama rag llama3.2 rag1 ./documents
 
 The folder will be created if it doesn't exist yet.
-Supported formats include: .txt, .md, .html, .json, .csv, and various source code files.`,
-	Args: cobra.ExactArgs(3),
+Supported formats include: .txt, .md, .html, .json, .csv, and various source code files.
+
+Use --embedding-model to embed documents with a dedicated embedding model
+(e.g. nomic-embed-text) instead of the generation model.
+
+Documents are split into chunks before being embedded. Use --chunk-size and
+--chunk-overlap (in approximate tokens) and --chunk-strategy (fixed, sentence,
+paragraph or markdown) to control how.
+
+RAGs with many chunks are searched through an approximate HNSW index, tuned
+with --hnsw-m, --hnsw-ef-construction and --hnsw-ef-search.
+
+Use --prompt-template to answer with your own Go text/template prompt, for
+example to translate it or make it stricter. The template renders the system
+message and can use {{.Context}}, {{.Question}}, {{.History}} and {{.Sources}}.
+
+--top-k, --min-score and --context-tokens set how many passages are retrieved
+for each question and how much context is sent to the model. Passages are
+found by combining vector similarity and keyword (BM25) search, weighted by
+--vector-weight and --keyword-weight. All of these can be overridden for a
+session with the same flags on 'rlama run'.
+
+Use --reranker to rescore the --rerank-candidates best passages before keeping
+the top-k: "llm" asks an Ollama model (--rerank-model) to grade each passage,
+"cross-encoder" calls a reranking endpoint (--rerank-url), for example a local
+text-embeddings-inference server.
+
+Chunks are embedded by --concurrency parallel requests, in batches when the
+Ollama version supports it. Embeddings are saved as they are generated: if
+indexing stops (Ctrl-C, Ollama restart...), run 'rlama rag --resume rag1' to
+continue where it stopped, with the same settings. The RAG only appears in
+'rlama list' once it is complete.
+
+The defaults section of the configuration file replaces the defaults of
+--embedding-model, --chunk-size, --chunk-overlap, --chunk-strategy and
+--top-k, and with defaults.model set the model can be left out, as long as
+the folder exists:
+rlama rag rag1 ./documents`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if resumeIndexing {
+			return cobra.ExactArgs(1)(cmd, args)
+		}
+		return cobra.RangeArgs(2, 3)(cmd, args)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		modelName := args[0]
-		ragName := args[1]
-		folderPath := args[2]
+		var modelName, ragName, folderPath string
+		if resumeIndexing {
+			ragName = args[0]
+		} else {
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+			if len(args) == 3 {
+				modelName, ragName, folderPath = args[0], args[1], args[2]
+			} else if cfg.Defaults.Model != "" {
+				// Without a model, the folder must exist: 'rlama rag llama3 rag1'
+				// with a forgotten folder must not index a new folder 'rag1'
+				if info, err := os.Stat(args[1]); err != nil || !info.IsDir() {
+					return fmt.Errorf("folder '%s' does not exist; to create RAG '%s' with model '%s', give the folder: rlama rag %s %s <folder-path>",
+						args[1], args[1], args[0], args[0], args[1])
+				}
+				modelName, ragName, folderPath = cfg.Defaults.Model, args[0], args[1]
+			} else {
+				return fmt.Errorf("no model given and no defaults.model in %s", config.Path())
+			}
+			applyRagDefaults(cmd, cfg.Defaults)
 
-		// Check if Ollama is installed and running
-		ollamaClient := client.NewOllamaClient()
-		if err := ollamaClient.CheckOllamaAndModel(modelName); err != nil {
-			return err
+			// Check if Ollama is installed and running
+			ollamaClient := client.NewOllamaClient()
+			if err := ollamaClient.CheckOllamaAndModel(context.Background(), modelName); err != nil {
+				return err
+			}
+
+			// Display a message to indicate that the process has started
+			fmt.Printf("Creating RAG '%s' with model '%s' from folder '%s'...\n",
+				ragName, modelName, folderPath)
 		}
 
-		// Display a message to indicate that the process has started
-		fmt.Printf("Creating RAG '%s' with model '%s' from folder '%s'...\n",
-			ragName, modelName, folderPath)
+		// Ctrl-C cancels the indexing instead of killing the process
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
 
 		ragService := service.NewRagService()
-		err := ragService.CreateRag(modelName, ragName, folderPath)
+		ragService.SetEmbeddingOptions(service.EmbeddingOptions{
+			Concurrency: embedConcurrency,
+			Progress:    newProgressBar("Embedding").Update,
+		})
+		opts := service.CreateRagOptions{
+			EmbeddingModel: embeddingModel,
+			Chunking: domain.ChunkingConfig{
+				ChunkSize:    chunkSize,
+				ChunkOverlap: chunkOverlap,
+				Strategy:     chunkStrategy,
+			},
+			Index:          indexConfig,
+			PromptTemplate: promptTemplate,
+			Retrieval:      retrieval,
+			Resume:         resumeIndexing,
+		}
+		err := ragService.CreateRag(ctx, modelName, ragName, folderPath, opts)
+		var indexingErr *service.IndexingError
+		if errors.As(err, &indexingErr) {
+			if errors.Is(err, context.Canceled) {
+				err = fmt.Errorf("indexing interrupted")
+			}
+			return fmt.Errorf("%w\nThe embeddings generated so far are kept; run 'rlama rag --resume %s' to continue", err, ragName)
+		}
 		if err != nil {
 			// Improve error messages related to Ollama
 			if strings.Contains(err.Error(), "connection refused") {
@@ -49,6 +158,51 @@ Supported formats include: .txt, .md, .html, .json, .csv, and various source cod
 	},
 }
 
+// applyRagDefaults replaces the defaults of the flags that were not given by
+// those of the configuration file
+func applyRagDefaults(cmd *cobra.Command, defaults config.Defaults) {
+	flags := cmd.Flags()
+	if !flags.Changed("embedding-model") && defaults.EmbeddingModel != "" {
+		embeddingModel = defaults.EmbeddingModel
+	}
+	chunking := defaults.Chunking()
+	if !flags.Changed("chunk-size") {
+		chunkSize = chunking.ChunkSize
+	}
+	if !flags.Changed("chunk-overlap") {
+		chunkOverlap = chunking.ChunkOverlap
+	}
+	if !flags.Changed("chunk-strategy") {
+		chunkStrategy = chunking.Strategy
+	}
+	if !flags.Changed("top-k") {
+		retrieval.TopK = defaults.Retrieval().TopK
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(ragCmd)
+	ragCmd.Flags().StringVar(&embeddingModel, "embedding-model", "", "Model used to generate embeddings (defaults to the generation model)")
+	ragCmd.Flags().IntVar(&chunkSize, "chunk-size", domain.DefaultChunkSize, "Maximum chunk size in tokens")
+	ragCmd.Flags().IntVar(&chunkOverlap, "chunk-overlap", domain.DefaultChunkOverlap, "Number of tokens shared by consecutive chunks")
+	ragCmd.Flags().StringVar(&chunkStrategy, "chunk-strategy", domain.ChunkStrategyParagraph, "Chunking strategy: fixed, sentence, paragraph or markdown")
+
+	ragCmd.Flags().BoolVar(&resumeIndexing, "resume", false, "Resume an interrupted indexing with its original settings")
+	ragCmd.Flags().IntVar(&embedConcurrency, "concurrency", service.DefaultEmbeddingConcurrency, "Number of embedding requests sent to Ollama in parallel")
+
+	ragCmd.Flags().StringVar(&promptTemplate, "prompt-template", "", "Go text/template file used to build the prompt")
+	ragCmd.Flags().IntVar(&retrieval.TopK, "top-k", domain.DefaultTopK, "Maximum number of passages retrieved for each question")
+	ragCmd.Flags().Float64Var(&retrieval.MinScore, "min-score", domain.DefaultMinScore, "Minimum similarity score of a retrieved passage")
+	ragCmd.Flags().IntVar(&retrieval.ContextTokens, "context-tokens", domain.DefaultContextTokens, "Maximum number of tokens of retrieved context sent to the model")
+	ragCmd.Flags().Float64Var(&retrieval.VectorWeight, "vector-weight", domain.DefaultVectorWeight, "Weight of the vector similarity ranking (0 disables it)")
+	ragCmd.Flags().Float64Var(&retrieval.KeywordWeight, "keyword-weight", domain.DefaultKeywordWeight, "Weight of the keyword (BM25) ranking (0 disables it)")
+	ragCmd.Flags().StringVar(&retrieval.Rerank.Reranker, "reranker", domain.RerankerNone, "Reranker applied to the retrieved passages: none, llm or cross-encoder")
+	ragCmd.Flags().IntVar(&retrieval.Rerank.Candidates, "rerank-candidates", domain.DefaultRerankCandidates, "Number of passages retrieved and rescored by the reranker")
+	ragCmd.Flags().StringVar(&retrieval.Rerank.Model, "rerank-model", "", "Ollama model grading the passages with the llm reranker (defaults to the generation model)")
+	ragCmd.Flags().StringVar(&retrieval.Rerank.URL, "rerank-url", "", "Endpoint of the cross-encoder reranker (e.g. http://localhost:8080/rerank)")
+
+	defaultIndex := vector.DefaultHNSWConfig()
+	ragCmd.Flags().IntVar(&indexConfig.M, "hnsw-m", defaultIndex.M, "Number of neighbors per node of the HNSW index")
+	ragCmd.Flags().IntVar(&indexConfig.EfConstruction, "hnsw-ef-construction", defaultIndex.EfConstruction, "Candidate list size used while building the HNSW index")
+	ragCmd.Flags().IntVar(&indexConfig.EfSearch, "hnsw-ef-search", defaultIndex.EfSearch, "Candidate list size used while searching the HNSW index")
 }
@@ -6,6 +6,9 @@ import (
 	// "fmt"
 	// "os"
 
+	"github.com/golvellius32/rlama/internal/client"
+	"github.com/golvellius32/rlama/internal/config"
+	"github.com/golvellius32/rlama/internal/repository"
 	"github.com/spf13/cobra"
 )
 
@@ -23,14 +26,46 @@ based on Ollama models.
 Main commands:
   rag [model] [rag-name] [folder-path]    Create a new RAG system
   run [rag-name]                          Run an existing RAG system
+  update-rag [rag-name]                   Re-index the changed documents of a RAG system
   list                                    List all available RAG systems
   delete [rag-name]                       Delete a RAG system
-  update                                  Check and install RLAMA updates`,
+  doctor                                  Check the tools available for text extraction
+  update                                  Check and install RLAMA updates
+
+Ollama is reached at http://localhost:11434 unless --host, the OLLAMA_HOST
+environment variable or the ollama.host setting of ~/.config/rlama/config.yaml
+says otherwise. The configuration file also sets the timeout, retries and
+headers (e.g. a bearer token) of the requests sent to Ollama.
+
+RAG systems are stored in ~/.rlama unless --data-dir, the RLAMA_HOME
+environment variable or the data_dir setting says otherwise. Use
+'rlama config' to edit the configuration file.`,
+	// The data folder and the Ollama settings apply to every command
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := config.DataDir(dataDirFlag)
+		if err != nil {
+			return err
+		}
+		repository.SetDataDir(dir)
+
+		ollamaConfig, err := config.OllamaClientConfig(ollamaHost)
+		if err != nil {
+			return err
+		}
+		client.SetDefaultConfig(ollamaConfig)
+		return nil
+	},
 }
 
 // Variable to store the version flag
 var versionFlag bool
 
+// ollamaHost is the address of Ollama given with --host
+var ollamaHost string
+
+// dataDirFlag is the data folder given with --data-dir
+var dataDirFlag string
+
 // Execute executes the root command
 func Execute() error {
 	return rootCmd.Execute()
@@ -39,6 +74,8 @@ func Execute() error {
 func init() {
 	// Add --version flag
 	rootCmd.Flags().BoolVarP(&versionFlag, "version", "v", false, "Display RLAMA version")
+	rootCmd.PersistentFlags().StringVar(&ollamaHost, "host", "", "Address of Ollama (default $OLLAMA_HOST or http://localhost:11434)")
+	rootCmd.PersistentFlags().StringVar(&dataDirFlag, "data-dir", "", "Folder of the RAG systems (default $RLAMA_HOME or ~/.rlama)")
 	
 	// Override the Run function to handle the --version flag
 	rootCmd.Run = func(cmd *cobra.Command, args []string) {
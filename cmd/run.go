@@ -2,28 +2,56 @@ package cmd
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
 
 	"github.com/golvellius32/rlama/internal/client"
+	"github.com/golvellius32/rlama/internal/domain"
 	"github.com/golvellius32/rlama/internal/service"
 	"github.com/spf13/cobra"
 )
 
+var (
+	maxTurns         int
+	runTopK          int
+	runMinScore      float64
+	runContextTokens int
+	runVectorWeight  float64
+	runKeywordWeight float64
+	runFilter        string
+)
+
 var runCmd = &cobra.Command{
 	Use:   "run [rag-name]",
 	Short: "Run a RAG system",
 	Long: `Run a previously created RAG system. 
 Starts an interactive session to interact with the RAG system.
-Example: rlama run rag1`,
+Example: rlama run rag1
+
+The session remembers the last exchanges (see --history), so follow-up
+questions can refer to earlier answers. Type /reset to start over.
+
+--top-k, --min-score, --context-tokens, --vector-weight and --keyword-weight
+override the retrieval settings of the RAG for the session. They can also be
+changed during the session with the commands of the same name (e.g. /top-k 5);
+/settings shows the current values.
+
+--filter (or /filter during the session) restricts the search to passages whose
+metadata match an expression, e.g. 'path:docs/api/** AND type:pdf' or
+'page>=10 AND created<2024-07-01'. Available fields are path, name, type,
+content_type, created, modified, page, and the title, author, subject and
+keywords read from the documents. /filter alone removes the filter.`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ragName := args[0]
 
 		// Check if Ollama is installed and running
 		ollamaClient := client.NewOllamaClient()
-		if err := ollamaClient.CheckOllamaAndModel(""); err != nil {
+		if err := ollamaClient.CheckOllamaAndModel(context.Background(), ""); err != nil {
 			return err
 		}
 
@@ -32,9 +60,41 @@ Example: rlama run rag1`,
 		if err != nil {
 			return err
 		}
+		defer rag.Close()
+
+		printMigration(rag.Name, rag.Migration)
+		if rag.Migration != nil && len(rag.Migration.Collisions) > 0 {
+			fmt.Printf("These documents are not searchable. Run 'rlama verify %s --repair' to index them again.\n", rag.Name)
+		}
+
+		fmt.Printf("RAG '%s' loaded. Model: %s, embedding model: %s\n", rag.Name, rag.ModelName, rag.GetEmbeddingModel())
+		conversation := ragService.NewConversation(rag, maxTurns)
+
+		// Override the retrieval settings of the RAG for this session
+		retrieval := conversation.Retrieval()
+		if cmd.Flags().Changed("top-k") {
+			retrieval.TopK = runTopK
+		}
+		if cmd.Flags().Changed("min-score") {
+			retrieval.MinScore = runMinScore
+		}
+		if cmd.Flags().Changed("context-tokens") {
+			retrieval.ContextTokens = runContextTokens
+		}
+		if cmd.Flags().Changed("vector-weight") {
+			retrieval.VectorWeight = runVectorWeight
+		}
+		if cmd.Flags().Changed("keyword-weight") {
+			retrieval.KeywordWeight = runKeywordWeight
+		}
+		if cmd.Flags().Changed("filter") {
+			retrieval.Filter = runFilter
+		}
+		if err := conversation.SetRetrieval(retrieval); err != nil {
+			return err
+		}
 
-		fmt.Printf("RAG '%s' loaded. Model: %s\n", rag.Name, rag.ModelName)
-		fmt.Println("Type your question (or 'exit' to quit):")
+		fmt.Println("Type your question (or '/reset' to forget the conversation, 'exit' to quit):")
 
 		scanner := bufio.NewScanner(os.Stdin)
 		for {
@@ -52,19 +112,132 @@ Example: rlama run rag1`,
 				continue
 			}
 
-			answer, err := ragService.Query(rag, question)
+			if strings.HasPrefix(strings.TrimSpace(question), "/") {
+				runSessionCommand(conversation, strings.TrimSpace(question))
+				continue
+			}
+
+			// Print the answer as the model generates it. Ctrl-C stops the
+			// answer and goes back to the prompt.
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			result, err := conversation.Ask(ctx, question, func(token string) error {
+				fmt.Print(token)
+				return nil
+			})
+			stop()
+			fmt.Println()
 			if err != nil {
 				fmt.Printf("Error: %s\n", err)
 				continue
 			}
 
-			fmt.Println(answer)
+			printSources(result.Sources)
 		}
 
 		return nil
 	},
 }
 
+// runSessionCommand handles the /commands of an interactive session
+func runSessionCommand(conversation *service.Conversation, line string) {
+	retrieval := conversation.Retrieval()
+	fields := strings.Fields(line)
+
+	switch fields[0] {
+	case "/reset":
+		conversation.Reset()
+		fmt.Println("Conversation reset.")
+		return
+	case "/settings":
+		printRetrieval(retrieval)
+		return
+	case "/filter":
+		// The expression is the rest of the line, spaces included
+		retrieval.Filter = strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+		if err := conversation.SetRetrieval(retrieval); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		printRetrieval(retrieval)
+		return
+	case "/top-k", "/min-score", "/context-tokens", "/vector-weight", "/keyword-weight":
+		if len(fields) != 2 {
+			fmt.Printf("Usage: %s <value>\n", fields[0])
+			return
+		}
+	default:
+		fmt.Println("Unknown command. Available commands: /reset, /settings, /top-k, /min-score, /context-tokens, /vector-weight, /keyword-weight, /filter")
+		return
+	}
+
+	var err error
+	switch fields[0] {
+	case "/top-k":
+		retrieval.TopK, err = strconv.Atoi(fields[1])
+	case "/min-score":
+		retrieval.MinScore, err = strconv.ParseFloat(fields[1], 64)
+	case "/context-tokens":
+		retrieval.ContextTokens, err = strconv.Atoi(fields[1])
+	case "/vector-weight":
+		retrieval.VectorWeight, err = strconv.ParseFloat(fields[1], 64)
+	case "/keyword-weight":
+		retrieval.KeywordWeight, err = strconv.ParseFloat(fields[1], 64)
+	}
+	if err != nil {
+		fmt.Printf("Invalid value: %s\n", fields[1])
+		return
+	}
+	if err := conversation.SetRetrieval(retrieval); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+	printRetrieval(retrieval)
+}
+
+// printRetrieval prints the retrieval settings of a session
+func printRetrieval(retrieval domain.RetrievalConfig) {
+	fmt.Printf("top-k: %d, min-score: %g, context-tokens: %d, vector-weight: %g, keyword-weight: %g\n",
+		retrieval.TopK, retrieval.MinScore, retrieval.ContextTokens, retrieval.VectorWeight, retrieval.KeywordWeight)
+	if retrieval.Rerank.Enabled() {
+		fmt.Printf("reranker: %s (%d candidates)\n", retrieval.Rerank.Reranker, retrieval.Rerank.Candidates)
+	}
+	if retrieval.Filter != "" {
+		fmt.Printf("filter: %s\n", retrieval.Filter)
+	}
+}
+
+// printSources prints the documents an answer is based on
+func printSources(sources []service.Source) {
+	if len(sources) == 0 {
+		return
+	}
+
+	fmt.Println("\nSources:")
+	for i, source := range sources {
+		location := source.Path
+		if location == "" {
+			location = source.Name
+		}
+		if source.Page > 0 {
+			location += fmt.Sprintf(", page %d", source.Page)
+		}
+		scores := fmt.Sprintf("similarity %.3f, keywords %.2f", source.Similarity, source.KeywordScore)
+		if source.RerankScore != nil {
+			scores += fmt.Sprintf(", rerank %.3f", *source.RerankScore)
+		}
+		fmt.Printf("  [%d] %s (bytes %d-%d, %s)\n",
+			i+1, location, source.StartOffset, source.EndOffset, scores)
+	}
+	fmt.Println()
+}
+
 func init() {
 	rootCmd.AddCommand(runCmd)
+	runCmd.Flags().IntVar(&maxTurns, "history", service.DefaultMaxTurns, "Number of previous exchanges remembered in the conversation")
+	runCmd.Flags().IntVar(&runTopK, "top-k", domain.DefaultTopK, "Maximum number of passages retrieved for each question (overrides the RAG setting)")
+	runCmd.Flags().Float64Var(&runMinScore, "min-score", domain.DefaultMinScore, "Minimum similarity score of a retrieved passage (overrides the RAG setting)")
+	runCmd.Flags().IntVar(&runContextTokens, "context-tokens", domain.DefaultContextTokens, "Maximum number of tokens of retrieved context (overrides the RAG setting)")
+	runCmd.Flags().Float64Var(&runVectorWeight, "vector-weight", domain.DefaultVectorWeight, "Weight of the vector similarity ranking (overrides the RAG setting)")
+	runCmd.Flags().Float64Var(&runKeywordWeight, "keyword-weight", domain.DefaultKeywordWeight, "Weight of the keyword (BM25) ranking (overrides the RAG setting)")
+	runCmd.Flags().StringVar(&runFilter, "filter", "", "Only search passages whose metadata match this expression, e.g. 'path:docs/** AND type:pdf'")
 }
@@ -4,10 +4,27 @@ import (
 	"log"
 
 	"github.com/golvellius32/rlama/api" // Update with your module name
+	"github.com/golvellius32/rlama/internal/client"
+	"github.com/golvellius32/rlama/internal/config"
+	"github.com/golvellius32/rlama/internal/repository"
 )
 
 func main() {
+	// Store RAG systems where RLAMA_HOME or the configuration file says
+	dataDir, err := config.DataDir("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	repository.SetDataDir(dataDir)
+
+	// Reach Ollama as configured by OLLAMA_HOST or the configuration file
+	ollamaConfig, err := config.OllamaClientConfig("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	client.SetDefaultConfig(ollamaConfig)
+
 	router := api.SetupRouter()
-	log.Println("Starting RLAMA API server on http://localhost:3001")
+	log.Printf("Starting RLAMA API server on http://localhost:3001 (Ollama at %s)", ollamaConfig.Host)
 	router.Run(":3001")
 }
@@ -7,6 +7,8 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/golvellius32/rlama/internal/config"
+	"github.com/golvellius32/rlama/internal/repository"
 	"github.com/spf13/cobra"
 )
 
@@ -15,11 +17,16 @@ var forceUninstall bool
 var uninstallCmd = &cobra.Command{
 	Use:   "uninstall",
 	Short: "Uninstall RLAMA and all its files",
-	Long:  `Completely uninstall RLAMA by removing the executable and all associated data files.`,
+	Long: `Completely uninstall RLAMA by removing the executable, the RAG systems,
+the embedding cache and the configuration file.
+Other files in the data directory are kept, and so is the directory itself if
+it contains any.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// 1. Check if the user confirmed the deletion
+		dataDir := repository.DataDir()
+		configPath := config.Path()
 		if !forceUninstall {
-			fmt.Print("This action will remove RLAMA and all your data. Are you sure? (y/n): ")
+			fmt.Printf("This action will remove RLAMA, the RAG systems and embedding cache in %s, and the configuration file %s. Are you sure? (y/n): ", dataDir, configPath)
 			var response string
 			fmt.Scanln(&response)
 			
@@ -30,25 +37,32 @@ var uninstallCmd = &cobra.Command{
 			}
 		}
 
-		// 2. Delete the data directory
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			return fmt.Errorf("unable to determine user directory: %w", err)
-		}
-		
-		dataDir := filepath.Join(homeDir, ".rlama")
-		fmt.Printf("Removing data directory: %s\n", dataDir)
+		// 2. Delete the data of RLAMA. The data directory may be set to a
+		// shared folder, so only the files RLAMA created are removed.
+		fmt.Printf("Removing RAG systems and embedding cache from: %s\n", dataDir)
 		
 		if _, err := os.Stat(dataDir); err == nil {
-			err = os.RemoveAll(dataDir)
+			removed, err := repository.NewRagRepository().RemoveData()
 			if err != nil {
-				return fmt.Errorf("unable to remove data directory: %w", err)
+				return fmt.Errorf("unable to remove data: %w", err)
+			}
+			if removed {
+				fmt.Println("✓ Data directory removed")
+			} else {
+				fmt.Println("✓ Data removed; the data directory was kept because it contains other files")
 			}
-			fmt.Println("✓ Data directory removed")
 		} else {
 			fmt.Println("Data directory doesn't exist or has already been removed")
 		}
 
+		// Remove the configuration file, and its folder if it is left empty
+		if err := os.Remove(configPath); err == nil {
+			os.Remove(filepath.Dir(configPath))
+			fmt.Printf("✓ Configuration file removed: %s\n", configPath)
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove configuration file: %w", err)
+		}
+
 		// 3. Remove the executable
 		executablePath := "/usr/local/bin/rlama"
 		fmt.Printf("Removing executable: %s\n", executablePath)
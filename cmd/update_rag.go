@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/golvellius32/rlama/internal/service"
+	"github.com/spf13/cobra"
+)
+
+var updateFolder string
+
+var updateRagCmd = &cobra.Command{
+	Use:   "update-rag [rag-name]",
+	Short: "Re-index the new, modified and deleted documents of a RAG system",
+	Long: `Walk the source folder of a RAG system again and only re-index what changed.
+New and modified files are embedded, and deleted files are removed from the RAG.
+Example: rlama update-rag rag1
+
+RAGs created before the source folder was recorded need --folder once.
+Press Ctrl-C to stop: the RAG is left unchanged.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ragName := args[0]
+
+		// Ctrl-C cancels the update instead of killing the process
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		ragService := service.NewRagService()
+		ragService.SetEmbeddingOptions(service.EmbeddingOptions{
+			Concurrency: embedConcurrency,
+			Progress:    newProgressBar("Embedding").Update,
+		})
+		report, err := ragService.UpdateRag(ctx, ragName, updateFolder)
+		if errors.Is(err, context.Canceled) {
+			return fmt.Errorf("update interrupted, RAG '%s' was left unchanged", ragName)
+		}
+		if err != nil {
+			if strings.Contains(err.Error(), "connection refused") {
+				return fmt.Errorf("⚠️ Unable to connect to Ollama.\n" +
+					"Make sure Ollama is installed and running.\n")
+			}
+			return err
+		}
+
+		printMigration(ragName, report.Migration)
+		for _, path := range report.Added {
+			fmt.Printf("+ %s\n", path)
+		}
+		for _, path := range report.Updated {
+			fmt.Printf("~ %s\n", path)
+		}
+		for _, path := range report.Removed {
+			fmt.Printf("- %s\n", path)
+		}
+
+		if !report.HasChanges() {
+			fmt.Printf("RAG '%s' is already up to date (%d documents).\n", ragName, report.Unchanged)
+			return nil
+		}
+
+		fmt.Printf("RAG '%s' updated: %d added, %d modified, %d removed, %d unchanged.\n",
+			ragName, len(report.Added), len(report.Updated), len(report.Removed), report.Unchanged)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(updateRagCmd)
+	updateRagCmd.Flags().StringVar(&updateFolder, "folder", "", "Source folder to use (replaces the recorded one)")
+	updateRagCmd.Flags().IntVar(&embedConcurrency, "concurrency", service.DefaultEmbeddingConcurrency, "Number of embedding requests sent to Ollama in parallel")
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/golvellius32/rlama/internal/domain"
+	"github.com/golvellius32/rlama/internal/service"
+	"github.com/spf13/cobra"
+)
+
+var repairRag bool
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify [rag-name]",
+	Short: "Check a RAG system for inconsistencies",
+	Long: `Check that the documents, chunks, vectors and keyword index of a RAG system
+match, and that no save was interrupted by a crash.
+With --repair, orphan chunks and vectors and documents whose text is missing
+are removed, missing vectors are embedded again (which requires Ollama) and
+the RAG is saved again. Documents of RAGs created by previous versions that
+shared a file name, and lost their vectors, are indexed again from their text.
+Example: rlama verify rag1 --repair`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ragName := args[0]
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		report, err := service.NewRagService().VerifyRag(ctx, ragName, repairRag)
+		if err != nil {
+			return err
+		}
+
+		printMigration(ragName, report.Migration)
+		if report.Count() == 0 {
+			switch {
+			case report.Repaired:
+				fmt.Printf("RAG '%s' is consistent, its migrated document IDs were saved.\n", ragName)
+			case report.Migration != nil:
+				fmt.Printf("RAG '%s' is consistent. Run 'rlama verify %s --repair' to save its migrated document IDs.\n", ragName, ragName)
+			default:
+				fmt.Printf("RAG '%s' is consistent.\n", ragName)
+			}
+			return nil
+		}
+
+		if report.GenerationMismatch() {
+			fmt.Printf("- vectors written by save %d, text by save %d, information by save %d: a save was interrupted\n",
+				report.VectorGeneration, report.ContentGeneration, report.Generation)
+		}
+		printProblem("documents without their text", report.MissingContent)
+		printProblem("chunks or documents without a vector", report.MissingVectors)
+		printProblem("vectors without a chunk", report.OrphanVectors)
+		printProblem("vectors with an invalid dimension", report.InvalidVectors)
+		printProblem("chunks of removed documents", report.OrphanChunks)
+		if report.StaleKeywordIndex {
+			fmt.Println("- the keyword index doesn't match the chunks")
+		}
+		printProblem("temporary files left by interrupted saves", report.TempFiles)
+		if report.StaleStaging {
+			fmt.Println("- the staging folder of a completed indexing was not removed")
+		}
+
+		if !report.Repaired {
+			return fmt.Errorf("RAG '%s' has %d problems, run 'rlama verify %s --repair' to repair it", ragName, report.Count(), ragName)
+		}
+		if report.Reembedded > 0 {
+			fmt.Printf("%d chunks or documents embedded again.\n", report.Reembedded)
+		}
+		fmt.Printf("RAG '%s' repaired.\n", ragName)
+		return nil
+	},
+}
+
+// printProblem prints a kind of problem found by verify with a few examples
+func printProblem(description string, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	fmt.Printf("- %d %s", len(ids), description)
+	const examples = 3
+	for i, id := range ids {
+		if i == examples {
+			fmt.Print(", ...")
+			break
+		}
+		if i == 0 {
+			fmt.Print(": ")
+		} else {
+			fmt.Print(", ")
+		}
+		fmt.Print(id)
+	}
+	fmt.Println()
+}
+
+// printMigration tells the user about the document IDs migrated when a RAG
+// created by a previous version was loaded, and lists the documents that
+// shared an ID and lost their vectors
+func printMigration(ragName string, migration *domain.IDMigration) {
+	if migration == nil {
+		return
+	}
+	fmt.Printf("RAG '%s': document IDs of %d documents migrated to unique, path-based IDs.\n", ragName, migration.Renamed)
+	if len(migration.Collisions) == 0 {
+		return
+	}
+
+	ids := make([]string, 0, len(migration.Collisions))
+	for id := range migration.Collisions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	fmt.Printf("Warning: %d file names were shared by several documents, which overwrote each other in the index:\n", len(ids))
+	for _, id := range ids {
+		fmt.Printf("  %s: %s\n", id, strings.Join(migration.Collisions[id], ", "))
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().BoolVar(&repairRag, "repair", false, "Repair the problems found")
+}
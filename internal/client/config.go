@@ -0,0 +1,100 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTimeout est la durée maximale d'un appel à Ollama, nouvelles
+	// tentatives comprises. La génération sur CPU peut être lente.
+	DefaultTimeout = 5 * time.Minute
+	// DefaultRetries est le nombre de nouvelles tentatives après une erreur
+	// de connexion ou une erreur 5xx
+	DefaultRetries = 3
+	// defaultRetryDelay est l'attente avant la première nouvelle tentative ;
+	// elle double à chaque tentative
+	defaultRetryDelay = 500 * time.Millisecond
+	// maxRetryDelay borne l'attente entre deux tentatives
+	maxRetryDelay = 10 * time.Second
+)
+
+// Config décrit comment joindre Ollama
+type Config struct {
+	// Host est l'URL de base de l'API, par exemple http://localhost:11434
+	Host string
+	// Timeout borne la durée de chaque appel (0 pour aucune limite). Pour les
+	// réponses en streaming, il ne borne que l'attente du début de la réponse.
+	Timeout time.Duration
+	// Retries est le nombre de nouvelles tentatives après une erreur de
+	// connexion ou une erreur 5xx
+	Retries int
+	// Headers sont ajoutés à chaque requête, par exemple
+	// « Authorization: Bearer ... » pour un proxy authentifié
+	Headers map[string]string
+}
+
+// DefaultConfig retourne la configuration d'une instance Ollama locale
+func DefaultConfig() Config {
+	return Config{
+		Host:    DefaultOllamaURL,
+		Timeout: DefaultTimeout,
+		Retries: DefaultRetries,
+	}
+}
+
+var (
+	defaultConfigMu sync.Mutex
+	defaultConfig   = DefaultConfig()
+)
+
+// SetDefaultConfig change la configuration utilisée par NewOllamaClient
+func SetDefaultConfig(config Config) {
+	defaultConfigMu.Lock()
+	defer defaultConfigMu.Unlock()
+	defaultConfig = config
+}
+
+// currentDefaultConfig retourne la configuration utilisée par NewOllamaClient
+func currentDefaultConfig() Config {
+	defaultConfigMu.Lock()
+	defer defaultConfigMu.Unlock()
+	return defaultConfig
+}
+
+// ParseHost normalise l'adresse d'Ollama, sous les formes acceptées par
+// OLLAMA_HOST : « hôte », « hôte:port » ou une URL complète, éventuellement
+// avec un chemin quand Ollama est derrière un proxy. Sans schéma, http est
+// utilisé, et sans port, le port par défaut d'Ollama (11434).
+func ParseHost(host string) (string, error) {
+	host = strings.TrimSpace(host)
+	if host == "" {
+		return "", fmt.Errorf("empty Ollama host")
+	}
+
+	explicitScheme := strings.Contains(host, "://")
+	if !explicitScheme {
+		host = "http://" + host
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid Ollama host '%s': %w", host, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid Ollama host '%s': unsupported scheme '%s'", host, u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("invalid Ollama host '%s': missing host name", host)
+	}
+
+	// Une URL complète garde le port implicite de son schéma
+	if u.Port() == "" && !explicitScheme {
+		u.Host += ":11434"
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return strings.TrimRight(u.String(), "/"), nil
+}
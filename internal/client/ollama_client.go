@@ -1,11 +1,18 @@
 package client
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"strings"
+	"time"
 )
 
 const (
@@ -16,6 +23,13 @@ const (
 type OllamaClient struct {
 	BaseURL string
 	Client  *http.Client
+	// Timeout borne la durée de chaque appel (0 pour aucune limite)
+	Timeout time.Duration
+	// Retries est le nombre de nouvelles tentatives après une erreur de
+	// connexion ou une erreur 5xx
+	Retries int
+	// Headers sont ajoutés à chaque requête
+	Headers map[string]string
 }
 
 // EmbeddingRequest est la structure de la requête pour l'API /api/embeddings
@@ -57,31 +71,53 @@ type GenerationResponse struct {
 	Done      bool   `json:"done"`
 }
 
-// NewOllamaClient crée un nouveau client Ollama
+// NewOllamaClient crée un nouveau client Ollama avec la configuration par
+// défaut (voir SetDefaultConfig)
 func NewOllamaClient() *OllamaClient {
+	return NewOllamaClientWithConfig(currentDefaultConfig())
+}
+
+// NewOllamaClientWithConfig crée un client Ollama avec la configuration donnée
+func NewOllamaClientWithConfig(config Config) *OllamaClient {
+	if config.Host == "" {
+		config.Host = DefaultOllamaURL
+	}
 	return &OllamaClient{
-		BaseURL: DefaultOllamaURL,
+		BaseURL: strings.TrimRight(config.Host, "/"),
 		Client:  &http.Client{},
+		Timeout: config.Timeout,
+		Retries: config.Retries,
+		Headers: config.Headers,
 	}
 }
 
-// GenerateEmbedding génère un embedding pour le texte donné
-func (c *OllamaClient) GenerateEmbedding(model, text string) ([]float32, error) {
+// BatchEmbeddingRequest est la structure de la requête pour l'API /api/embed
+type BatchEmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// BatchEmbeddingResponse est la structure de la réponse de l'API /api/embed
+type BatchEmbeddingResponse struct {
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+// ErrBatchEmbeddingUnsupported est retournée par GenerateEmbeddings quand la
+// version d'Ollama ne fournit pas l'API /api/embed
+var ErrBatchEmbeddingUnsupported = errors.New("batch embeddings are not supported by this Ollama version")
+
+// GenerateEmbedding génère un embedding pour le texte donné.
+// La requête est interrompue quand ctx est annulé.
+func (c *OllamaClient) GenerateEmbedding(ctx context.Context, model, text string) ([]float32, error) {
 	reqBody := EmbeddingRequest{
 		Model:  model,
 		Prompt: text,
 	}
 
-	reqJSON, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, err
-	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
 
-	resp, err := c.Client.Post(
-		fmt.Sprintf("%s/api/embeddings", c.BaseURL),
-		"application/json",
-		bytes.NewBuffer(reqJSON),
-	)
+	resp, err := c.postJSON(ctx, "/api/embeddings", reqBody)
 	if err != nil {
 		return nil, err
 	}
@@ -100,8 +136,118 @@ func (c *OllamaClient) GenerateEmbedding(model, text string) ([]float32, error)
 	return embeddingResp.Embedding, nil
 }
 
+// GenerateEmbeddings génère en une seule requête les embeddings de plusieurs
+// textes, dans le même ordre. Retourne ErrBatchEmbeddingUnsupported si
+// Ollama ne connaît pas l'API /api/embed (versions antérieures à 0.3).
+func (c *OllamaClient) GenerateEmbeddings(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	reqBody := BatchEmbeddingRequest{
+		Model: model,
+		Input: texts,
+	}
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	resp, err := c.postJSON(ctx, "/api/embed", reqBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		bodyBytes, _ := ioutil.ReadAll(resp.Body)
+		// Un modèle inconnu donne aussi une 404, mais avec un message qui le nomme
+		if !strings.Contains(string(bodyBytes), "model") {
+			return nil, ErrBatchEmbeddingUnsupported
+		}
+		return nil, fmt.Errorf("failed to generate embeddings: %s (status: %d)", string(bodyBytes), resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to generate embeddings: %s (status: %d)", string(bodyBytes), resp.StatusCode)
+	}
+
+	var embeddingResp BatchEmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embeddingResp); err != nil {
+		return nil, err
+	}
+	if len(embeddingResp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(embeddingResp.Embeddings))
+	}
+
+	return embeddingResp.Embeddings, nil
+}
+
+// withTimeout borne la durée d'un appel par le délai configuré
+func (c *OllamaClient) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.Timeout)
+}
+
+// postJSON envoie une requête POST avec un corps JSON à l'API Ollama
+func (c *OllamaClient) postJSON(ctx context.Context, path string, reqBody interface{}) (*http.Response, error) {
+	reqJSON, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(ctx, http.MethodPost, path, reqJSON)
+}
+
+// do envoie une requête à l'API Ollama avec les en-têtes configurés. Les
+// erreurs de connexion et les réponses 5xx sont retentées jusqu'à Retries
+// fois, avec une attente qui double à chaque tentative ; la dernière réponse
+// en erreur est retournée telle quelle à l'appelant.
+func (c *OllamaClient) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	delay := defaultRetryDelay
+	for attempt := 0; ; attempt++ {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		for name, value := range c.Headers {
+			req.Header.Set(name, value)
+		}
+
+		resp, err := c.Client.Do(req)
+		var retry bool
+		if err != nil {
+			// Une annulation ou un délai dépassé n'est pas retenté
+			retry = ctx.Err() == nil
+		} else {
+			retry = resp.StatusCode >= 500
+		}
+		if !retry || attempt >= c.Retries {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		// Une part aléatoire évite que plusieurs clients retentent ensemble
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
 // GenerateCompletion génère une réponse pour le prompt donné
-func (c *OllamaClient) GenerateCompletion(model, prompt string) (string, error) {
+func (c *OllamaClient) GenerateCompletion(ctx context.Context, model, prompt string) (string, error) {
 	reqBody := GenerationRequest{
 		Model:  model,
 		Prompt: prompt,
@@ -113,16 +259,10 @@ func (c *OllamaClient) GenerateCompletion(model, prompt string) (string, error)
 		},
 	}
 
-	reqJSON, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", err
-	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
 
-	resp, err := c.Client.Post(
-		fmt.Sprintf("%s/api/generate", c.BaseURL),
-		"application/json",
-		bytes.NewBuffer(reqJSON),
-	)
+	resp, err := c.postJSON(ctx, "/api/generate", reqBody)
 	if err != nil {
 		return "", err
 	}
@@ -141,39 +281,267 @@ func (c *OllamaClient) GenerateCompletion(model, prompt string) (string, error)
 	return genResp.Response, nil
 }
 
+// TokenCallback est appelée pour chaque fragment de réponse reçu en streaming
+type TokenCallback func(token string) error
+
+// GenerateCompletionStream génère une réponse en streaming et appelle onToken
+// pour chaque fragment reçu. La réponse complète est retournée à la fin.
+func (c *OllamaClient) GenerateCompletionStream(ctx context.Context, model, prompt string, onToken TokenCallback) (string, error) {
+	reqBody := GenerationRequest{
+		Model:  model,
+		Prompt: prompt,
+		Stream: true,
+		Options: Options{
+			Temperature: 0.7,
+			TopP:        0.9,
+			NumPredict:  1024,
+		},
+	}
+
+	return c.postStream(ctx, "/api/generate", reqBody, func(line []byte) (string, bool, error) {
+		var genResp streamResponse
+		if err := json.Unmarshal(line, &genResp); err != nil {
+			return "", false, fmt.Errorf("invalid stream response: %w", err)
+		}
+		if genResp.Error != "" {
+			return "", false, fmt.Errorf("failed to generate completion: %s", genResp.Error)
+		}
+		return genResp.Response, genResp.Done, nil
+	}, onToken)
+}
+
+// ChatMessage est un message de conversation pour l'API /api/chat
+type ChatMessage struct {
+	Role    string `json:"role"` // "system", "user" ou "assistant"
+	Content string `json:"content"`
+}
+
+// ChatRequest est la structure de la requête pour l'API /api/chat
+type ChatRequest struct {
+	Model    string        `json:"model"`
+	Messages []ChatMessage `json:"messages"`
+	Options  Options       `json:"options,omitempty"`
+	Stream   bool          `json:"stream"`
+}
+
+// ChatResponse est la structure de la réponse de l'API /api/chat
+type ChatResponse struct {
+	Model     string      `json:"model"`
+	Message   ChatMessage `json:"message"`
+	CreatedAt string      `json:"created_at"`
+	Done      bool        `json:"done"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// Chat génère la réponse de l'assistant à une conversation
+func (c *OllamaClient) Chat(ctx context.Context, model string, messages []ChatMessage) (string, error) {
+	reqBody := ChatRequest{
+		Model:    model,
+		Messages: messages,
+		Stream:   false,
+		Options: Options{
+			Temperature: 0.7,
+			TopP:        0.9,
+			NumPredict:  1024,
+		},
+	}
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	resp, err := c.postJSON(ctx, "/api/chat", reqBody)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed to generate chat response: %s (status: %d)", string(bodyBytes), resp.StatusCode)
+	}
+
+	var chatResp ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return "", err
+	}
+	if chatResp.Error != "" {
+		return "", fmt.Errorf("failed to generate chat response: %s", chatResp.Error)
+	}
+
+	return chatResp.Message.Content, nil
+}
+
+// ChatStream génère la réponse de l'assistant en streaming et appelle onToken
+// pour chaque fragment reçu. La réponse complète est retournée à la fin.
+func (c *OllamaClient) ChatStream(ctx context.Context, model string, messages []ChatMessage, onToken TokenCallback) (string, error) {
+	reqBody := ChatRequest{
+		Model:    model,
+		Messages: messages,
+		Stream:   true,
+		Options: Options{
+			Temperature: 0.7,
+			TopP:        0.9,
+			NumPredict:  1024,
+		},
+	}
+
+	return c.postStream(ctx, "/api/chat", reqBody, func(line []byte) (string, bool, error) {
+		var chatResp ChatResponse
+		if err := json.Unmarshal(line, &chatResp); err != nil {
+			return "", false, fmt.Errorf("invalid stream response: %w", err)
+		}
+		if chatResp.Error != "" {
+			return "", false, fmt.Errorf("failed to generate chat response: %s", chatResp.Error)
+		}
+		return chatResp.Message.Content, chatResp.Done, nil
+	}, onToken)
+}
+
+// postStream envoie une requête dont la réponse est un flux NDJSON (un objet
+// JSON par ligne). parse extrait de chaque ligne le fragment de texte et
+// indique si la génération est terminée. Le délai configuré ne borne que
+// l'attente du début de la réponse, pas la durée de la génération ; les
+// nouvelles tentatives n'ont donc lieu qu'avant le premier fragment.
+func (c *OllamaClient) postStream(ctx context.Context, path string, reqBody interface{}, parse func(line []byte) (string, bool, error), onToken TokenCallback) (string, error) {
+	reqJSON, err := json.Marshal(reqBody)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var timer *time.Timer
+	if c.Timeout > 0 {
+		timer = time.AfterFunc(c.Timeout, cancel)
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, path, reqJSON)
+	if timer != nil && !timer.Stop() {
+		// Le délai a expiré : la requête est annulée, même si la réponse arrivait
+		if err == nil {
+			resp.Body.Close()
+		}
+		return "", fmt.Errorf("Ollama did not start answering within %s", c.Timeout)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed to generate completion: %s (status: %d)", string(bodyBytes), resp.StatusCode)
+	}
+
+	var full bytes.Buffer
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		token, done, err := parse(line)
+		if err != nil {
+			return full.String(), err
+		}
+
+		if token != "" {
+			full.WriteString(token)
+			if onToken != nil {
+				if err := onToken(token); err != nil {
+					return full.String(), err
+				}
+			}
+		}
+
+		if done {
+			return full.String(), nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return full.String(), fmt.Errorf("error reading completion stream: %w", err)
+	}
+
+	return full.String(), fmt.Errorf("completion stream ended unexpectedly")
+}
+
+// StreamEvent est un élément du flux retourné par StreamCompletion
+type StreamEvent struct {
+	Token string
+	Err   error
+}
+
+// StreamCompletion expose le flux de GenerateCompletionStream sous forme de canal.
+// Le canal est fermé à la fin de la génération ; une erreur éventuelle est
+// transmise comme dernier événement. Si ctx est annulé, la génération s'arrête
+// sans attendre que le lecteur vide le canal.
+func (c *OllamaClient) StreamCompletion(ctx context.Context, model, prompt string) <-chan StreamEvent {
+	events := make(chan StreamEvent)
+	send := func(ev StreamEvent) error {
+		select {
+		case events <- ev:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	go func() {
+		defer close(events)
+		_, err := c.GenerateCompletionStream(ctx, model, prompt, func(token string) error {
+			return send(StreamEvent{Token: token})
+		})
+		if err != nil {
+			send(StreamEvent{Err: err})
+		}
+	}()
+	return events
+}
+
+// streamResponse est un élément du flux NDJSON de l'API /api/generate
+type streamResponse struct {
+	GenerationResponse
+	Error string `json:"error,omitempty"`
+}
+
 // IsOllamaRunning checks if Ollama is installed and running
-func (c *OllamaClient) IsOllamaRunning() (bool, error) {
-	resp, err := c.Client.Get(fmt.Sprintf("%s/api/version", c.BaseURL))
+func (c *OllamaClient) IsOllamaRunning(ctx context.Context) (bool, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	resp, err := c.do(ctx, http.MethodGet, "/api/version", nil)
 	if err != nil {
 		return false, fmt.Errorf("Ollama is not accessible: %w", err)
 	}
 	defer resp.Body.Close()
-	
+
 	if resp.StatusCode != http.StatusOK {
 		return false, fmt.Errorf("Ollama responded with error code: %d", resp.StatusCode)
 	}
-	
+
 	return true, nil
 }
 
 // CheckOllamaAndModel verifies if Ollama is running and if the specified model is available
-func (c *OllamaClient) CheckOllamaAndModel(modelName string) error {
+func (c *OllamaClient) CheckOllamaAndModel(ctx context.Context, modelName string) error {
 	// Check if Ollama is running
-	running, err := c.IsOllamaRunning()
+	running, err := c.IsOllamaRunning(ctx)
 	if err != nil {
-		return fmt.Errorf("⚠️ Ollama is not installed or not running.\n"+
+		return fmt.Errorf("⚠️ Ollama is not installed or not running at %s.\n"+
 			"RLAMA requires Ollama to function.\n"+
 			"Please install Ollama with: curl -fsSL https://ollama.com/install.sh | sh\n"+
-			"Then start it before using RLAMA.")
+			"Then start it before using RLAMA, or point RLAMA to it with --host or OLLAMA_HOST.", c.BaseURL)
 	}
-	
+
 	if !running {
-		return fmt.Errorf("⚠️ Ollama is not running.\n"+
+		return fmt.Errorf("⚠️ Ollama is not running.\n" +
 			"Please start Ollama before using RLAMA.")
 	}
-	
+
 	// Check if model is available (optional)
 	// This check could be added here
-	
+
 	return nil
-} 
\ No newline at end of file
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golvellius32/rlama/internal/client"
+	"github.com/golvellius32/rlama/internal/domain"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the user settings read from the configuration file
+type Config struct {
+	// DataDir is the folder where RAG systems and the embedding cache are
+	// stored, ~/.rlama by default. Environment variables are expanded.
+	DataDir string `yaml:"data_dir,omitempty"`
+	// Storage is the storage of new RAG systems: "json" (default) or "sqlite"
+	Storage  string       `yaml:"storage,omitempty"`
+	Ollama   OllamaConfig `yaml:"ollama,omitempty"`
+	Defaults Defaults     `yaml:"defaults,omitempty"`
+	API      APIConfig    `yaml:"api,omitempty"`
+}
+
+// OllamaConfig describes how to reach Ollama
+type OllamaConfig struct {
+	// Host is the address of Ollama, in any form accepted by OLLAMA_HOST
+	Host string `yaml:"host,omitempty"`
+	// Timeout bounds each call to Ollama, e.g. "2m"; "0" disables it
+	Timeout string `yaml:"timeout,omitempty"`
+	// Retries is the number of retries after a connection error or a 5xx
+	// response
+	Retries *int `yaml:"retries,omitempty"`
+	// Headers are added to every request. Values may reference environment
+	// variables, e.g. "Bearer ${OLLAMA_TOKEN}", to keep secrets out of the file.
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+// APIConfig holds the settings of the API server
+type APIConfig struct {
+	// Root is the folder of the server whose files requests may index, besides
+	// uploaded files. Environment variables are expanded.
+	Root string `yaml:"root,omitempty"`
+}
+
+// Defaults are the settings of new RAG systems used when the command line
+// or the API request doesn't give them
+type Defaults struct {
+	// Model is the generation model, which 'rlama rag' then doesn't need
+	Model          string `yaml:"model,omitempty"`
+	EmbeddingModel string `yaml:"embedding_model,omitempty"`
+	ChunkSize      int    `yaml:"chunk_size,omitempty"`
+	ChunkOverlap   *int   `yaml:"chunk_overlap,omitempty"`
+	ChunkStrategy  string `yaml:"chunk_strategy,omitempty"`
+	TopK           int    `yaml:"top_k,omitempty"`
+}
+
+// Chunking returns the chunking of new RAG systems
+func (d Defaults) Chunking() domain.ChunkingConfig {
+	chunking := domain.DefaultChunkingConfig()
+	if d.ChunkSize != 0 {
+		chunking.ChunkSize = d.ChunkSize
+	}
+	if d.ChunkOverlap != nil {
+		chunking.ChunkOverlap = *d.ChunkOverlap
+	}
+	if d.ChunkStrategy != "" {
+		chunking.Strategy = d.ChunkStrategy
+	}
+	return chunking
+}
+
+// Retrieval returns the retrieval settings of new RAG systems
+func (d Defaults) Retrieval() domain.RetrievalConfig {
+	retrieval := domain.DefaultRetrievalConfig()
+	if d.TopK != 0 {
+		retrieval.TopK = d.TopK
+	}
+	return retrieval
+}
+
+// Path returns the path of the configuration file:
+// $XDG_CONFIG_HOME/rlama/config.yaml, or ~/.config/rlama/config.yaml
+func Path() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			homeDir = "."
+		}
+		dir = filepath.Join(homeDir, ".config")
+	}
+	return filepath.Join(dir, "rlama", "config.yaml")
+}
+
+// Load reads the configuration file. A missing file gives an empty configuration.
+func Load() (*Config, error) {
+	config := &Config{}
+	data, err := os.ReadFile(Path())
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read configuration file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %w", Path(), err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %w", Path(), err)
+	}
+	return config, nil
+}
+
+// validate checks the values of the settings
+func (c *Config) validate() error {
+	switch c.Storage {
+	case "", "json", "sqlite":
+	default:
+		return fmt.Errorf("unknown storage '%s' (supported: json, sqlite)", c.Storage)
+	}
+	if c.Ollama.Timeout != "" {
+		if timeout, err := time.ParseDuration(c.Ollama.Timeout); err != nil || timeout < 0 {
+			return fmt.Errorf("invalid Ollama timeout '%s'", c.Ollama.Timeout)
+		}
+	}
+	if c.Ollama.Retries != nil && *c.Ollama.Retries < 0 {
+		return fmt.Errorf("invalid Ollama retries %d", *c.Ollama.Retries)
+	}
+	if err := c.Defaults.Chunking().Validate(); err != nil {
+		return fmt.Errorf("invalid defaults: %w", err)
+	}
+	if err := c.Defaults.Retrieval().Validate(); err != nil {
+		return fmt.Errorf("invalid defaults: %w", err)
+	}
+	return nil
+}
+
+// DataDir resolves the folder where RAG systems are stored: dir if not
+// empty, then the RLAMA_HOME environment variable, then the data_dir setting
+// of the configuration file. It returns "" to use the default folder.
+func DataDir(dir string) (string, error) {
+	if dir == "" {
+		dir = os.Getenv("RLAMA_HOME")
+	}
+	if dir == "" {
+		config, err := Load()
+		if err != nil {
+			return "", err
+		}
+		dir = os.ExpandEnv(config.DataDir)
+	}
+	if dir == "" {
+		return "", nil
+	}
+	return filepath.Abs(dir)
+}
+
+// OllamaClientConfig resolves the settings of the Ollama client. The host is
+// taken from host if not empty, then from the OLLAMA_HOST environment
+// variable, then from the configuration file, and defaults to a local Ollama.
+func OllamaClientConfig(host string) (client.Config, error) {
+	resolved := client.DefaultConfig()
+
+	config, err := Load()
+	if err != nil {
+		return resolved, err
+	}
+
+	if host == "" {
+		host = os.Getenv("OLLAMA_HOST")
+	}
+	if host == "" {
+		host = config.Ollama.Host
+	}
+	if host != "" {
+		if resolved.Host, err = client.ParseHost(host); err != nil {
+			return resolved, err
+		}
+	}
+
+	// Load has checked the timeout and the retries
+	if config.Ollama.Timeout != "" {
+		resolved.Timeout, _ = time.ParseDuration(config.Ollama.Timeout)
+	}
+	if config.Ollama.Retries != nil {
+		resolved.Retries = *config.Ollama.Retries
+	}
+	if len(config.Ollama.Headers) > 0 {
+		resolved.Headers = make(map[string]string, len(config.Ollama.Headers))
+		for name, value := range config.Ollama.Headers {
+			resolved.Headers[name] = os.ExpandEnv(value)
+		}
+	}
+
+	return resolved, nil
+}
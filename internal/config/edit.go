@@ -0,0 +1,260 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Setting is a setting of the configuration file and its value
+type Setting struct {
+	Key   string
+	Value string
+}
+
+// settingKind is the type of the value of a setting
+type settingKind int
+
+const (
+	kindString settingKind = iota
+	kindInt
+)
+
+// keys lists the settings that can be edited, in the order of List
+var keys = []struct {
+	key  string
+	kind settingKind
+}{
+	{"data_dir", kindString},
+	{"storage", kindString},
+	{"ollama.host", kindString},
+	{"ollama.timeout", kindString},
+	{"ollama.retries", kindInt},
+	{"defaults.model", kindString},
+	{"defaults.embedding_model", kindString},
+	{"defaults.chunk_size", kindInt},
+	{"defaults.chunk_overlap", kindInt},
+	{"defaults.chunk_strategy", kindString},
+	{"defaults.top_k", kindInt},
+	{"api.root", kindString},
+}
+
+// headersKey prefixes the headers sent to Ollama, e.g. ollama.headers.Authorization
+const headersKey = "ollama.headers."
+
+// Keys returns the settings that can be edited
+func Keys() []string {
+	names := make([]string, 0, len(keys)+1)
+	for _, k := range keys {
+		names = append(names, k.key)
+	}
+	return append(names, headersKey+"<name>")
+}
+
+// lookupKey returns the path of a setting in the YAML document and its type
+func lookupKey(key string) ([]string, settingKind, error) {
+	if name := strings.TrimPrefix(key, headersKey); name != key && name != "" {
+		return []string{"ollama", "headers", name}, kindString, nil
+	}
+	for _, k := range keys {
+		if k.key == key {
+			return strings.Split(key, "."), k.kind, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("unknown setting '%s' (supported: %s)", key, strings.Join(Keys(), ", "))
+}
+
+// readDocument reads the configuration file as a YAML document, which keeps
+// its comments when it's written back. A missing file gives an empty document.
+func readDocument() (*yaml.Node, error) {
+	doc := &yaml.Node{Kind: yaml.DocumentNode}
+	data, err := os.ReadFile(Path())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read configuration file: %w", err)
+	}
+	if err == nil {
+		if err := yaml.Unmarshal(data, doc); err != nil {
+			return nil, fmt.Errorf("invalid configuration file %s: %w", Path(), err)
+		}
+	}
+	if len(doc.Content) == 0 {
+		doc.Kind = yaml.DocumentNode
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("invalid configuration file %s: not a mapping", Path())
+	}
+	return doc, nil
+}
+
+// child returns the value of name in the mapping node, or nil
+func child(node *yaml.Node, name string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == name {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// find returns the node at path, or nil
+func find(doc *yaml.Node, path []string) *yaml.Node {
+	node := doc.Content[0]
+	for _, name := range path {
+		node = child(node, name)
+	}
+	return node
+}
+
+// Get returns the value of a setting in the configuration file, and false if
+// it is not set
+func Get(key string) (string, bool, error) {
+	path, _, err := lookupKey(key)
+	if err != nil {
+		return "", false, err
+	}
+	doc, err := readDocument()
+	if err != nil {
+		return "", false, err
+	}
+	node := find(doc, path)
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return "", false, nil
+	}
+	return node.Value, true, nil
+}
+
+// List returns the settings set in the configuration file
+func List() ([]Setting, error) {
+	doc, err := readDocument()
+	if err != nil {
+		return nil, err
+	}
+
+	var settings []Setting
+	for _, k := range keys {
+		if node := find(doc, strings.Split(k.key, ".")); node != nil && node.Kind == yaml.ScalarNode {
+			settings = append(settings, Setting{Key: k.key, Value: node.Value})
+		}
+	}
+
+	var headers []Setting
+	if node := find(doc, []string{"ollama", "headers"}); node != nil && node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			headers = append(headers, Setting{Key: headersKey + node.Content[i].Value, Value: node.Content[i+1].Value})
+		}
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].Key < headers[j].Key })
+	return append(settings, headers...), nil
+}
+
+// Set writes the value of a setting into the configuration file, creating it
+// if needed. An empty value removes the setting. The file is only written if
+// the resulting configuration is valid.
+func Set(key, value string) error {
+	path, kind, err := lookupKey(key)
+	if err != nil {
+		return err
+	}
+	if kind == kindInt && value != "" {
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("invalid value '%s' for %s: an integer is expected", value, key)
+		}
+	}
+
+	doc, err := readDocument()
+	if err != nil {
+		return err
+	}
+	if value == "" {
+		unset(doc.Content[0], path)
+	} else {
+		tag := "!!str"
+		if kind == kindInt {
+			tag = "!!int"
+		}
+		set(doc.Content[0], path, &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value})
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	encoder.Close()
+
+	// Check the file as Load will read it
+	config := &Config{}
+	if err := yaml.Unmarshal(buf.Bytes(), config); err != nil {
+		return fmt.Errorf("invalid value '%s' for %s: %w", value, key, err)
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(Path()), 0755); err != nil {
+		return fmt.Errorf("unable to create configuration folder: %w", err)
+	}
+	if err := os.WriteFile(Path(), buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("unable to write configuration file: %w", err)
+	}
+	return nil
+}
+
+// set replaces the value at path, creating the missing mappings
+func set(node *yaml.Node, path []string, value *yaml.Node) {
+	for i, name := range path {
+		next := child(node, name)
+		if i == len(path)-1 {
+			if next != nil {
+				// Keep the comments of the previous value
+				value.HeadComment, value.LineComment, value.FootComment = next.HeadComment, next.LineComment, next.FootComment
+				*next = *value
+			} else {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}, value)
+			}
+			return
+		}
+		if next == nil || next.Kind != yaml.MappingNode {
+			mapping := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			if next != nil {
+				*next = *mapping
+				mapping = next
+			} else {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}, mapping)
+			}
+			next = mapping
+		}
+		node = next
+	}
+}
+
+// unset removes the value at path, and the mappings it leaves empty
+func unset(node *yaml.Node, path []string) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != path[0] {
+			continue
+		}
+		if len(path) > 1 {
+			unset(node.Content[i+1], path[1:])
+			if value := node.Content[i+1]; value.Kind != yaml.MappingNode || len(value.Content) > 0 {
+				return
+			}
+		}
+		node.Content = append(node.Content[:i], node.Content[i+2:]...)
+		return
+	}
+}
@@ -0,0 +1,74 @@
+package domain
+
+import (
+	"fmt"
+)
+
+// Stratégies de découpage disponibles
+const (
+	ChunkStrategyFixed     = "fixed"
+	ChunkStrategySentence  = "sentence"
+	ChunkStrategyParagraph = "paragraph"
+	ChunkStrategyMarkdown  = "markdown"
+)
+
+// Valeurs par défaut du découpage (exprimées en tokens approximatifs)
+const (
+	DefaultChunkSize    = 256
+	DefaultChunkOverlap = 32
+)
+
+// ChunkingConfig décrit la façon dont les documents sont découpés avant l'embedding
+type ChunkingConfig struct {
+	ChunkSize    int    `json:"chunk_size"`
+	ChunkOverlap int    `json:"chunk_overlap"`
+	Strategy     string `json:"strategy"`
+}
+
+// DefaultChunkingConfig retourne la configuration de découpage par défaut
+func DefaultChunkingConfig() ChunkingConfig {
+	return ChunkingConfig{
+		ChunkSize:    DefaultChunkSize,
+		ChunkOverlap: DefaultChunkOverlap,
+		Strategy:     ChunkStrategyParagraph,
+	}
+}
+
+// Validate vérifie la cohérence de la configuration de découpage
+func (c ChunkingConfig) Validate() error {
+	if c.ChunkSize <= 0 {
+		return fmt.Errorf("chunk size must be positive (got %d)", c.ChunkSize)
+	}
+	if c.ChunkOverlap < 0 || c.ChunkOverlap >= c.ChunkSize {
+		return fmt.Errorf("chunk overlap must be between 0 and chunk size - 1 (got %d)", c.ChunkOverlap)
+	}
+	switch c.Strategy {
+	case ChunkStrategyFixed, ChunkStrategySentence, ChunkStrategyParagraph, ChunkStrategyMarkdown:
+		return nil
+	default:
+		return fmt.Errorf("unknown chunking strategy '%s' (expected fixed, sentence, paragraph or markdown)", c.Strategy)
+	}
+}
+
+// DocumentChunk représente un morceau de document indexé séparément
+type DocumentChunk struct {
+	ID          string    `json:"id"`
+	DocumentID  string    `json:"document_id"`
+	Content     string    `json:"-"` // Lu à la demande, voir RagSystem.ChunkText
+	ChunkIndex  int       `json:"chunk_index"`
+	StartOffset int       `json:"start_offset"`
+	EndOffset   int       `json:"end_offset"`
+	Embedding   []float32 `json:"-"` // Ne pas sérialiser en JSON
+}
+
+// NewDocumentChunk crée un morceau couvrant content[start:end] du document parent
+func NewDocumentChunk(doc *Document, index, start, end int) *DocumentChunk {
+	return &DocumentChunk{
+		ID:          fmt.Sprintf("%s#%d", doc.ID, index),
+		DocumentID:  doc.ID,
+		Content:     doc.Content[start:end],
+		ChunkIndex:  index,
+		StartOffset: start,
+		EndOffset:   end,
+	}
+}
@@ -0,0 +1,35 @@
+package domain
+
+import "testing"
+
+func TestChunkingConfigValidate(t *testing.T) {
+	tests := []struct {
+		config ChunkingConfig
+		valid  bool
+	}{
+		{DefaultChunkingConfig(), true},
+		{ChunkingConfig{ChunkSize: 10, ChunkOverlap: 0, Strategy: ChunkStrategyFixed}, true},
+		{ChunkingConfig{ChunkSize: 10, ChunkOverlap: 9, Strategy: ChunkStrategyMarkdown}, true},
+		{ChunkingConfig{ChunkSize: 0, Strategy: ChunkStrategyFixed}, false},
+		{ChunkingConfig{ChunkSize: 10, ChunkOverlap: 10, Strategy: ChunkStrategySentence}, false},
+		{ChunkingConfig{ChunkSize: 10, ChunkOverlap: -1, Strategy: ChunkStrategyParagraph}, false},
+		{ChunkingConfig{ChunkSize: 10, Strategy: "words"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.config.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%+v) = %v, want valid = %v", tt.config, err, tt.valid)
+		}
+	}
+}
+
+func TestNewDocumentChunk(t *testing.T) {
+	doc := &Document{ID: "notes.md@abc", Content: "Un été à Paris."}
+	// Les offsets sont des octets : « été » en occupe 5
+	chunk := NewDocumentChunk(doc, 2, 3, 8)
+	if chunk.ID != "notes.md@abc#2" || chunk.DocumentID != doc.ID || chunk.ChunkIndex != 2 {
+		t.Errorf("unexpected chunk %+v", chunk)
+	}
+	if chunk.Content != "été" || chunk.StartOffset != 3 || chunk.EndOffset != 8 {
+		t.Errorf("chunk covers %q at %d-%d, want \"été\" at 3-8", chunk.Content, chunk.StartOffset, chunk.EndOffset)
+	}
+}
@@ -0,0 +1,64 @@
+package domain
+
+// ContentStore donne accès au texte des documents d'un RAG, enregistré à
+// part de ses informations pour n'être lu qu'au besoin
+type ContentStore interface {
+	// HasContent indique si le texte d'un document est enregistré
+	HasContent(id string) bool
+	// ReadContent retourne les octets start à end du texte d'un document,
+	// jusqu'à la fin du texte si end est négatif
+	ReadContent(id string, start, end int) (string, error)
+	// Generation identifie la sauvegarde qui a écrit le texte
+	Generation() uint64
+	// Close libère le fichier du texte
+	Close() error
+}
+
+// DocumentText retourne le texte d'un document, lu dans le magasin de
+// contenu s'il n'est pas en mémoire
+func (r *RagSystem) DocumentText(doc *Document) (string, error) {
+	if doc.Content != "" || r.ContentStore == nil || !r.ContentStore.HasContent(doc.ID) {
+		return doc.Content, nil
+	}
+	return r.ContentStore.ReadContent(doc.ID, 0, -1)
+}
+
+// ChunkText retourne le texte d'un morceau, lu dans le magasin de contenu
+// s'il n'est pas en mémoire
+func (r *RagSystem) ChunkText(chunk *DocumentChunk) (string, error) {
+	if chunk.Content != "" || r.ContentStore == nil || !r.ContentStore.HasContent(chunk.DocumentID) {
+		return chunk.Content, nil
+	}
+	return r.ContentStore.ReadContent(chunk.DocumentID, chunk.StartOffset, chunk.EndOffset)
+}
+
+// LoadContent lit en mémoire le texte de tous les documents et morceaux
+func (r *RagSystem) LoadContent() error {
+	for _, doc := range r.Documents {
+		text, err := r.DocumentText(doc)
+		if err != nil {
+			return err
+		}
+		doc.Content = text
+	}
+	for _, chunk := range r.Chunks {
+		text, err := r.ChunkText(chunk)
+		if err != nil {
+			return err
+		}
+		chunk.Content = text
+	}
+	return nil
+}
+
+// Close libère les fichiers ouverts au chargement du RAG. Les vecteurs et
+// le texte qui n'est pas en mémoire ne doivent plus être utilisés ensuite.
+func (r *RagSystem) Close() error {
+	err := r.VectorStore.Close()
+	if r.ContentStore != nil {
+		if cerr := r.ContentStore.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
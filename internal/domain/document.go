@@ -3,6 +3,7 @@ package domain
 import (
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
@@ -12,17 +13,29 @@ type Document struct {
 	ID          string    `json:"id"`
 	Path        string    `json:"path"`
 	Name        string    `json:"name"`
-	Content     string    `json:"content"`
+	Content     string    `json:"-"` // Lu à la demande, voir RagSystem.DocumentText
 	Embedding   []float32 `json:"-"` // Ne pas sérialiser en JSON
 	CreatedAt   time.Time `json:"created_at"`
 	ContentType string    `json:"content_type"`
 	Size        int64     `json:"size"`
+	// Informations sur le fichier source, utilisées pour détecter les modifications
+	SourceModTime time.Time `json:"source_mod_time"`
+	SourceSize    int64     `json:"source_size"`
+	SourceHash    string    `json:"source_hash,omitempty"`
+	// Metadata contient les propriétés lues par l'extracteur (titre, auteur...)
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// PageOffsets contient la position du début de chaque page dans Content,
+	// pour les documents dont l'extraction sépare les pages
+	PageOffsets []int `json:"page_offsets,omitempty"`
 }
 
 // NewDocument crée une nouvelle instance de Document
+// Les sauts de page (\f) du texte extrait délimitent les pages du document.
+// L'identifiant définitif est attribué par RagSystem.DocumentID, qui connaît
+// le dossier source.
 func NewDocument(path string, content string) *Document {
-	// Nettoyer le contenu extrait
-	cleanedContent := cleanExtractedText(content)
+	// Nettoyer le contenu extrait, page par page
+	cleanedContent, pageOffsets := cleanPages(content)
 	
 	return &Document{
 		ID:          filepath.Base(path),
@@ -33,9 +46,52 @@ func NewDocument(path string, content string) *Document {
 		CreatedAt:   time.Now(),
 		ContentType: guessContentType(path),
 		Size:        int64(len(cleanedContent)),
+		PageOffsets: pageOffsets,
 	}
 }
 
+// cleanPages nettoie chaque page du texte extrait et les assemble, en
+// retournant la position du début de chaque page. Les pages vides commencent
+// à la position de la page suivante.
+func cleanPages(content string) (string, []int) {
+	pages := strings.Split(content, "\f")
+	if len(pages) == 1 {
+		return cleanExtractedText(content), nil
+	}
+
+	// pdftotext termine la dernière page par un saut de page
+	if strings.TrimSpace(pages[len(pages)-1]) == "" {
+		pages = pages[:len(pages)-1]
+	}
+
+	var b strings.Builder
+	offsets := make([]int, len(pages))
+	for i, page := range pages {
+		cleaned := cleanExtractedText(page)
+		if cleaned != "" && b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		offsets[i] = b.Len()
+		b.WriteString(cleaned)
+	}
+	return b.String(), offsets
+}
+
+// PageAt retourne le numéro (à partir de 1) de la page contenant la position
+// offset du contenu, ou 0 si les pages du document ne sont pas connues
+func (d *Document) PageAt(offset int) int {
+	if len(d.PageOffsets) == 0 {
+		return 0
+	}
+	page := sort.Search(len(d.PageOffsets), func(i int) bool {
+		return d.PageOffsets[i] > offset
+	})
+	if page == 0 {
+		page = 1
+	}
+	return page
+}
+
 // cleanExtractedText nettoie le texte extrait pour améliorer sa qualité
 func cleanExtractedText(text string) string {
 	// Remplacer les séquences de caractères non imprimables par des espaces
@@ -55,7 +111,12 @@ func cleanExtractedText(text string) string {
 	var cleanedLines []string
 	for _, line := range lines {
 		trimmed := strings.TrimSpace(line)
-		if len(trimmed) > 0 {
+		if len(trimmed) == 0 {
+			// Conserver une seule ligne vide pour séparer les paragraphes
+			if len(cleanedLines) > 0 && cleanedLines[len(cleanedLines)-1] != "" {
+				cleanedLines = append(cleanedLines, "")
+			}
+		} else {
 			// Vérifier si la ligne contient au moins quelques lettres
 			re = regexp.MustCompile(`[a-zA-Z]{2,}`)
 			if re.MatchString(trimmed) || len(trimmed) > 20 {
@@ -64,7 +125,7 @@ func cleanExtractedText(text string) string {
 		}
 	}
 	
-	return strings.Join(cleanedLines, "\n")
+	return strings.TrimSpace(strings.Join(cleanedLines, "\n"))
 }
 
 // guessContentType essaie de déterminer le type de contenu basé sur l'extension du fichier
@@ -99,6 +160,10 @@ func guessContentType(path string) string {
 		return "application/rtf"
 	case ".odt":
 		return "application/vnd.oasis.opendocument.text"
+	case ".ods":
+		return "application/vnd.oasis.opendocument.spreadsheet"
+	case ".odp":
+		return "application/vnd.oasis.opendocument.presentation"
 	default:
 		return "application/octet-stream"
 	}
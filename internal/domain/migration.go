@@ -0,0 +1,99 @@
+package domain
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// IDMigration décrit la réécriture des identifiants d'un RAG créé quand un
+// document était identifié par le seul nom de son fichier
+type IDMigration struct {
+	// Renamed est le nombre de documents dont l'identifiant a changé
+	Renamed int
+	// Collisions associe chaque ancien identifiant partagé par plusieurs
+	// documents aux chemins relatifs de ces documents
+	Collisions map[string][]string
+}
+
+// isLegacyDocumentID indique si un document porte un ancien identifiant
+func isLegacyDocumentID(doc *Document) bool {
+	return doc.ID == filepath.Base(doc.Path)
+}
+
+// MigrateDocumentIDs remplace les anciens identifiants par ceux de
+// DocumentID, dans les documents, les morceaux, les vecteurs et l'index des
+// mots-clés. Les documents qui partageaient un identifiant se sont écrasés
+// les uns les autres dans l'index : leurs morceaux et leurs vecteurs sont
+// retirés, si bien que Verify les signale comme sans vecteur et que Repair
+// les fait ré-indexer à partir de leur texte. Leurs informations de fichier
+// sont effacées pour que update-rag les ré-indexe aussi. Retourne nil si le
+// RAG n'a pas d'ancien identifiant.
+func (r *RagSystem) MigrateDocumentIDs() (*IDMigration, error) {
+	shared := make(map[string]int)
+	for _, doc := range r.Documents {
+		if isLegacyDocumentID(doc) {
+			shared[doc.ID]++
+		}
+	}
+	if len(shared) == 0 {
+		return nil, nil
+	}
+
+	// Le hash du contenu remplace celui du fichier des anciens documents
+	if err := r.LoadContent(); err != nil {
+		return nil, err
+	}
+
+	migration := &IDMigration{Collisions: make(map[string][]string)}
+	newIDs := make(map[string]string)
+	for _, doc := range r.Documents {
+		if !isLegacyDocumentID(doc) {
+			continue
+		}
+		oldID := doc.ID
+		doc.ID = r.DocumentID(doc)
+		migration.Renamed++
+
+		if shared[oldID] > 1 {
+			migration.Collisions[oldID] = append(migration.Collisions[oldID], r.RelativePath(doc.Path))
+			doc.SourceModTime = time.Time{}
+			doc.SourceSize = -1
+			doc.SourceHash = ""
+			continue
+		}
+		newIDs[oldID] = doc.ID
+	}
+
+	chunks := r.Chunks[:0]
+	for _, chunk := range r.Chunks {
+		if _, collided := migration.Collisions[chunk.DocumentID]; collided {
+			// Impossible de savoir à quel document appartient ce vecteur
+			r.VectorStore.Remove(chunk.ID)
+			continue
+		}
+		if newID, ok := newIDs[chunk.DocumentID]; ok {
+			chunkID := newID + strings.TrimPrefix(chunk.ID, chunk.DocumentID)
+			r.VectorStore.Rename(chunk.ID, chunkID)
+			chunk.ID = chunkID
+			chunk.DocumentID = newID
+		}
+		chunks = append(chunks, chunk)
+	}
+	r.Chunks = chunks
+
+	// Les anciens systèmes indexent directement le document
+	for oldID, newID := range newIDs {
+		r.VectorStore.Rename(oldID, newID)
+	}
+	for oldID := range migration.Collisions {
+		r.VectorStore.Remove(oldID)
+	}
+
+	if err := r.BuildKeywordIndex(); err != nil {
+		return nil, err
+	}
+	r.RefreshMetadata()
+	r.UpdatedAt = time.Now()
+	return migration, nil
+}
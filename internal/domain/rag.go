@@ -1,44 +1,133 @@
 package domain
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/golvellius32/rlama/pkg/bm25"
 	"github.com/golvellius32/rlama/pkg/vector"
 )
 
 // RagSystem représente un système RAG complet
 type RagSystem struct {
-	Name        string    `json:"name"`
-	ModelName   string    `json:"model_name"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	Description string    `json:"description"`
-	VectorStore *vector.Store
-	Documents   []*Document `json:"documents"`
+	Name           string            `json:"name"`
+	ModelName      string            `json:"model_name"`
+	EmbeddingModel string            `json:"embedding_model,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+	Description    string            `json:"description"`
+	SourceFolder   string            `json:"source_folder,omitempty"`
+	VectorStore    *vector.Store     `json:"-"`
+	KeywordIndex   *bm25.Index       `json:"-"`
+	Documents      []*Document       `json:"documents"`
+	Chunks         []*DocumentChunk  `json:"chunks"`
+	Chunking       ChunkingConfig    `json:"chunking"`
+	Index          vector.HNSWConfig `json:"index"`
+	Retrieval      RetrievalConfig   `json:"retrieval"`
+	// PromptTemplate est le fichier du modèle de prompt (text/template),
+	// relatif au dossier du RAG. Vide pour utiliser le modèle par défaut.
+	PromptTemplate string `json:"prompt_template,omitempty"`
+	// Generation est incrémentée à chaque sauvegarde et écrite dans chaque
+	// fichier du RAG, pour détecter une sauvegarde interrompue
+	Generation uint64 `json:"generation"`
+	// ContentStore contient le texte des documents enregistrés, nil pour un
+	// RAG qui n'a pas encore été sauvegardé
+	ContentStore ContentStore `json:"-"`
+	// Migration décrit la migration des identifiants appliquée au chargement,
+	// nil s'il n'y en a pas eu. Elle est enregistrée par la sauvegarde suivante.
+	Migration *IDMigration `json:"-"`
 }
 
-// NewRagSystem crée une nouvelle instance de RagSystem
-func NewRagSystem(name, modelName string) *RagSystem {
+// NewRagSystem crée une nouvelle instance de RagSystem.
+// Si embeddingModel est vide, le modèle de génération sert aussi aux embeddings.
+func NewRagSystem(name, modelName, embeddingModel string) *RagSystem {
+	if embeddingModel == "" {
+		embeddingModel = modelName
+	}
 	now := time.Now()
 	return &RagSystem{
-		Name:        name,
-		ModelName:   modelName,
-		CreatedAt:   now,
-		UpdatedAt:   now,
-		VectorStore: vector.NewStore(),
-		Documents:   []*Document{},
+		Name:           name,
+		ModelName:      modelName,
+		EmbeddingModel: embeddingModel,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		VectorStore:    vector.NewStore(),
+		KeywordIndex:   bm25.NewIndex(),
+		Documents:      []*Document{},
+		Chunks:         []*DocumentChunk{},
+		Chunking:       DefaultChunkingConfig(),
+		Index:          vector.DefaultHNSWConfig(),
+		Retrieval:      DefaultRetrievalConfig(),
 	}
 }
 
-// AddDocument ajoute un document au système RAG
+// GetRetrievalConfig retourne la configuration de recherche du RAG,
+// complétée par les valeurs par défaut
+func (r *RagSystem) GetRetrievalConfig() RetrievalConfig {
+	return r.Retrieval.WithDefaults()
+}
+
+// GetEmbeddingModel retourne le modèle utilisé pour calculer les embeddings du système.
+// EmbeddingModel est vide pour les systèmes créés avant sa séparation du modèle de génération.
+func (r *RagSystem) GetEmbeddingModel() string {
+	if r.EmbeddingModel != "" {
+		return r.EmbeddingModel
+	}
+	return r.ModelName
+}
+
+// AddDocument ajoute un document au système RAG.
+// Les anciens systèmes sans découpage indexent directement l'embedding du document.
 func (r *RagSystem) AddDocument(doc *Document) {
 	r.Documents = append(r.Documents, doc)
 	if doc.Embedding != nil {
-		r.VectorStore.Add(doc.ID, doc.Embedding)
+		r.VectorStore.AddWithMetadata(doc.ID, doc.Embedding, r.DocumentMetadata(doc))
 	}
 	r.UpdatedAt = time.Now()
 }
 
+// RemoveDocument retire un document, ses morceaux et leurs vecteurs du système RAG
+func (r *RagSystem) RemoveDocument(id string) bool {
+	found := false
+	for i, doc := range r.Documents {
+		if doc.ID == id {
+			r.Documents = append(r.Documents[:i], r.Documents[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+
+	// Les anciens systèmes indexent directement le document
+	r.VectorStore.Remove(id)
+	if r.KeywordIndex != nil {
+		r.KeywordIndex.Remove(id)
+	}
+
+	chunks := r.Chunks[:0]
+	for _, chunk := range r.Chunks {
+		if chunk.DocumentID == id {
+			r.VectorStore.Remove(chunk.ID)
+			if r.KeywordIndex != nil {
+				r.KeywordIndex.Remove(chunk.ID)
+			}
+			continue
+		}
+		chunks = append(chunks, chunk)
+	}
+	r.Chunks = chunks
+
+	r.UpdatedAt = time.Now()
+	return true
+}
+
 // GetDocumentByID récupère un document par son ID
 func (r *RagSystem) GetDocumentByID(id string) *Document {
 	for _, doc := range r.Documents {
@@ -48,3 +137,160 @@ func (r *RagSystem) GetDocumentByID(id string) *Document {
 	}
 	return nil
 }
+
+// AddChunk ajoute un morceau de document et indexe son embedding et ses mots-clés
+func (r *RagSystem) AddChunk(chunk *DocumentChunk) {
+	r.Chunks = append(r.Chunks, chunk)
+	if chunk.Embedding != nil {
+		r.VectorStore.AddWithMetadata(chunk.ID, chunk.Embedding, r.ChunkMetadata(chunk, r.GetDocumentByID(chunk.DocumentID)))
+	}
+	if r.KeywordIndex != nil {
+		r.KeywordIndex.Add(chunk.ID, chunk.Content)
+	}
+	r.UpdatedAt = time.Now()
+}
+
+// InSourceFolder indique si un fichier se trouve dans le dossier source
+func (r *RagSystem) InSourceFolder(path string) bool {
+	if r.SourceFolder == "" {
+		return false
+	}
+	rel, err := filepath.Rel(r.SourceFolder, path)
+	return err == nil && !strings.HasPrefix(rel, "..")
+}
+
+// RelativePath retourne le chemin d'un fichier relatif au dossier source,
+// avec des « / », ou son chemin complet s'il est hors du dossier source
+func (r *RagSystem) RelativePath(path string) string {
+	if r.InSourceFolder(path) {
+		path, _ = filepath.Rel(r.SourceFolder, path)
+	}
+	return filepath.ToSlash(path)
+}
+
+// FindDocuments retourne les documents désignés par pattern : un
+// identifiant, ou un motif glob appliqué à leur chemin relatif au dossier
+// source (à leur chemin complet si pattern est absolu). « * » ne traverse
+// pas les dossiers, contrairement à « ** ».
+func (r *RagSystem) FindDocuments(pattern string) ([]*Document, error) {
+	for _, doc := range r.Documents {
+		if doc.ID == pattern {
+			return []*Document{doc}, nil
+		}
+	}
+
+	re, err := vector.GlobPattern(filepath.ToSlash(pattern))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+	}
+	var docs []*Document
+	for _, doc := range r.Documents {
+		path := r.RelativePath(doc.Path)
+		if filepath.IsAbs(pattern) {
+			path = filepath.ToSlash(doc.Path)
+		}
+		if re.MatchString(path) {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// documentIDHashLength est le nombre de caractères du hash dans les identifiants
+const documentIDHashLength = 12
+
+// DocumentID retourne l'identifiant d'un document : son chemin relatif au
+// dossier source suivi du début du hash de son contenu, par exemple
+// « v1/README.md@3f2a9c1b7d4e ». Deux fichiers de même nom dans des dossiers
+// différents ont ainsi des identifiants distincts.
+func (r *RagSystem) DocumentID(doc *Document) string {
+	hash := doc.SourceHash
+	if len(hash) < documentIDHashLength {
+		// Les documents indexés avant le suivi des fichiers n'ont pas de hash
+		sum := sha256.Sum256([]byte(doc.Content))
+		hash = hex.EncodeToString(sum[:])
+	}
+	return r.RelativePath(doc.Path) + "@" + hash[:documentIDHashLength]
+}
+
+// DocumentMetadata retourne les métadonnées filtrables d'un document : son
+// chemin relatif au dossier source, son nom, son type (extension), son type
+// de contenu, ses dates et les propriétés lues par l'extracteur
+func (r *RagSystem) DocumentMetadata(doc *Document) map[string]string {
+	metadata := make(map[string]string, len(doc.Metadata)+6)
+	for key, value := range doc.Metadata {
+		metadata[key] = value
+	}
+
+	metadata["path"] = r.RelativePath(doc.Path)
+	metadata["name"] = doc.Name
+	metadata["type"] = strings.TrimPrefix(strings.ToLower(filepath.Ext(doc.Path)), ".")
+	metadata["content_type"] = doc.ContentType
+	metadata["created"] = doc.CreatedAt.Format("2006-01-02")
+	if !doc.SourceModTime.IsZero() {
+		metadata["modified"] = doc.SourceModTime.Format("2006-01-02")
+	}
+	return metadata
+}
+
+// ChunkMetadata retourne les métadonnées filtrables d'un morceau : celles de
+// son document, plus la page où il commence quand elle est connue
+func (r *RagSystem) ChunkMetadata(chunk *DocumentChunk, doc *Document) map[string]string {
+	if doc == nil {
+		return nil
+	}
+	metadata := r.DocumentMetadata(doc)
+	if page := doc.PageAt(chunk.StartOffset); page > 0 {
+		metadata["page"] = strconv.Itoa(page)
+	}
+	return metadata
+}
+
+// RefreshMetadata recalcule les métadonnées de tous les vecteurs, pour les
+// RAG indexés avant qu'elles soient enregistrées
+func (r *RagSystem) RefreshMetadata() {
+	docs := make(map[string]*Document, len(r.Documents))
+	for _, doc := range r.Documents {
+		docs[doc.ID] = doc
+		r.VectorStore.SetMetadata(doc.ID, r.DocumentMetadata(doc))
+	}
+	for _, chunk := range r.Chunks {
+		r.VectorStore.SetMetadata(chunk.ID, r.ChunkMetadata(chunk, docs[chunk.DocumentID]))
+	}
+}
+
+// BuildKeywordIndex reconstruit l'index des mots-clés à partir des morceaux,
+// ou des documents entiers pour les anciens systèmes sans découpage
+func (r *RagSystem) BuildKeywordIndex() error {
+	index := bm25.NewIndex()
+	index.Generation = r.Generation
+	if len(r.Chunks) > 0 {
+		for _, chunk := range r.Chunks {
+			text, err := r.ChunkText(chunk)
+			if err != nil {
+				return err
+			}
+			index.Add(chunk.ID, text)
+		}
+	} else {
+		for _, doc := range r.Documents {
+			text, err := r.DocumentText(doc)
+			if err != nil {
+				return err
+			}
+			index.Add(doc.ID, text)
+		}
+	}
+	r.KeywordIndex = index
+	return nil
+}
+
+// GetChunkByID récupère un morceau de document par son ID
+func (r *RagSystem) GetChunkByID(id string) *DocumentChunk {
+	for _, chunk := range r.Chunks {
+		if chunk.ID == id {
+			return chunk
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package domain
+
+import (
+	"fmt"
+
+	"github.com/golvellius32/rlama/pkg/vector"
+)
+
+// Valeurs par défaut de la recherche
+const (
+	DefaultTopK          = 3
+	DefaultMinScore      = 0.0
+	DefaultContextTokens = 1024
+	DefaultVectorWeight  = 1.0
+	DefaultKeywordWeight = 1.0
+)
+
+// Rerankers disponibles
+const (
+	RerankerNone         = "none"
+	RerankerLLM          = "llm"
+	RerankerCrossEncoder = "cross-encoder"
+)
+
+// DefaultRerankCandidates est le nombre de passages évalués par le reranker
+const DefaultRerankCandidates = 20
+
+// RerankConfig décrit l'étape optionnelle de reclassement des passages trouvés
+type RerankConfig struct {
+	// Reranker est le reranker utilisé : none, llm ou cross-encoder
+	Reranker string `json:"reranker,omitempty"`
+	// Candidates est le nombre de passages recherchés puis reclassés
+	Candidates int `json:"candidates,omitempty"`
+	// Model est le modèle Ollama qui juge la pertinence (reranker llm).
+	// Vide pour utiliser le modèle de génération du RAG.
+	Model string `json:"model,omitempty"`
+	// URL est l'adresse du cross-encoder (reranker cross-encoder)
+	URL string `json:"url,omitempty"`
+}
+
+// Enabled indique si les passages doivent être reclassés
+func (c RerankConfig) Enabled() bool {
+	return c.Reranker != "" && c.Reranker != RerankerNone
+}
+
+// Validate vérifie la cohérence de la configuration du reranker
+func (c RerankConfig) Validate() error {
+	switch c.Reranker {
+	case "", RerankerNone, RerankerLLM:
+	case RerankerCrossEncoder:
+		if c.URL == "" {
+			return fmt.Errorf("the cross-encoder reranker needs the URL of its endpoint")
+		}
+	default:
+		return fmt.Errorf("unknown reranker '%s' (expected none, llm or cross-encoder)", c.Reranker)
+	}
+	if c.Candidates < 0 {
+		return fmt.Errorf("number of rerank candidates must not be negative (got %d)", c.Candidates)
+	}
+	return nil
+}
+
+// RetrievalConfig décrit les passages retenus pour répondre à une question
+type RetrievalConfig struct {
+	// TopK est le nombre maximal de passages recherchés
+	TopK int `json:"top_k"`
+	// MinScore est la similarité minimale d'un passage retenu
+	MinScore float64 `json:"min_score"`
+	// ContextTokens est le budget total du contexte, en tokens approximatifs
+	ContextTokens int `json:"context_tokens"`
+	// VectorWeight et KeywordWeight pondèrent les classements par similarité
+	// et par mots-clés (BM25) lors de leur fusion. Un poids nul désactive
+	// la recherche correspondante.
+	VectorWeight  float64 `json:"vector_weight"`
+	KeywordWeight float64 `json:"keyword_weight"`
+	// Rerank décrit le reclassement optionnel des passages trouvés
+	Rerank RerankConfig `json:"rerank"`
+	// Filter restreint la recherche aux passages dont les métadonnées
+	// correspondent, par exemple "path:docs/api/** AND type:pdf"
+	Filter string `json:"filter,omitempty"`
+}
+
+// DefaultRetrievalConfig retourne la configuration de recherche par défaut
+func DefaultRetrievalConfig() RetrievalConfig {
+	return RetrievalConfig{
+		TopK:          DefaultTopK,
+		MinScore:      DefaultMinScore,
+		ContextTokens: DefaultContextTokens,
+		VectorWeight:  DefaultVectorWeight,
+		KeywordWeight: DefaultKeywordWeight,
+	}
+}
+
+// WithDefaults remplace les valeurs non renseignées par les valeurs par défaut,
+// pour les RAG créés avant que la recherche soit configurable
+func (c RetrievalConfig) WithDefaults() RetrievalConfig {
+	if c.TopK <= 0 {
+		c.TopK = DefaultTopK
+	}
+	if c.ContextTokens <= 0 {
+		c.ContextTokens = DefaultContextTokens
+	}
+	if c.VectorWeight == 0 && c.KeywordWeight == 0 {
+		c.VectorWeight = DefaultVectorWeight
+		c.KeywordWeight = DefaultKeywordWeight
+	}
+	if c.Rerank.Enabled() && c.Rerank.Candidates == 0 {
+		c.Rerank.Candidates = DefaultRerankCandidates
+	}
+	return c
+}
+
+// Validate vérifie la cohérence de la configuration de recherche
+func (c RetrievalConfig) Validate() error {
+	if c.TopK <= 0 {
+		return fmt.Errorf("top-k must be positive (got %d)", c.TopK)
+	}
+	if c.MinScore < -1 || c.MinScore > 1 {
+		return fmt.Errorf("minimum score must be between -1 and 1 (got %g)", c.MinScore)
+	}
+	if c.ContextTokens <= 0 {
+		return fmt.Errorf("context token budget must be positive (got %d)", c.ContextTokens)
+	}
+	if c.VectorWeight < 0 || c.KeywordWeight < 0 {
+		return fmt.Errorf("search weights must not be negative (got %g and %g)", c.VectorWeight, c.KeywordWeight)
+	}
+	if c.VectorWeight == 0 && c.KeywordWeight == 0 {
+		return fmt.Errorf("at least one of the vector and keyword weights must be positive")
+	}
+	if _, err := vector.ParseFilter(c.Filter); err != nil {
+		return err
+	}
+	return c.Rerank.Validate()
+}
@@ -0,0 +1,203 @@
+package domain
+
+import "time"
+
+// Inconsistencies décrit les écarts entre les documents, les morceaux, les
+// vecteurs et l'index des mots-clés d'un RAG
+type Inconsistencies struct {
+	// Generation est la génération des informations du RAG,
+	// VectorGeneration celle du fichier des vecteurs et ContentGeneration
+	// celle du texte. Elles diffèrent quand une sauvegarde a été interrompue.
+	Generation        uint64
+	VectorGeneration  uint64
+	ContentGeneration uint64
+	// MissingContent liste les documents dont le texte n'est pas enregistré
+	MissingContent []string
+	// MissingVectors liste les morceaux qui n'ont pas de vecteur et les
+	// documents qui n'ont aucun morceau (les documents sans vecteur pour les
+	// anciens systèmes sans découpage)
+	MissingVectors []string
+	// OrphanVectors liste les vecteurs qui n'appartiennent à aucun morceau
+	OrphanVectors []string
+	// InvalidVectors liste les vecteurs dont la dimension diffère des autres
+	InvalidVectors []string
+	// OrphanChunks liste les morceaux dont le document n'existe plus
+	OrphanChunks []string
+	// StaleKeywordIndex indique que l'index des mots-clés ne correspond pas
+	// aux morceaux
+	StaleKeywordIndex bool
+}
+
+// GenerationMismatch indique que les vecteurs et les informations du RAG
+// n'ont pas été écrits par la même sauvegarde
+func (i *Inconsistencies) GenerationMismatch() bool {
+	return i.VectorGeneration != i.Generation || i.ContentGeneration != i.Generation
+}
+
+// Count retourne le nombre de problèmes trouvés
+func (i *Inconsistencies) Count() int {
+	count := len(i.MissingContent) + len(i.MissingVectors) + len(i.OrphanVectors) + len(i.InvalidVectors) + len(i.OrphanChunks)
+	if i.GenerationMismatch() {
+		count++
+	}
+	if i.StaleKeywordIndex {
+		count++
+	}
+	return count
+}
+
+// IsChunked indique si les documents du RAG sont découpés en morceaux, ce
+// que ne font pas les anciens systèmes
+func (r *RagSystem) IsChunked() bool {
+	return len(r.Chunks) > 0 || r.Chunking.ChunkSize > 0
+}
+
+// indexedIDs retourne les identifiants qui doivent avoir un vecteur et une
+// entrée dans l'index des mots-clés : les morceaux, ou les documents pour
+// les anciens systèmes sans découpage
+func (r *RagSystem) indexedIDs() map[string]bool {
+	ids := make(map[string]bool)
+	if !r.IsChunked() {
+		for _, doc := range r.Documents {
+			ids[doc.ID] = true
+		}
+		return ids
+	}
+
+	docs := make(map[string]bool, len(r.Documents))
+	for _, doc := range r.Documents {
+		docs[doc.ID] = true
+	}
+	for _, chunk := range r.Chunks {
+		if docs[chunk.DocumentID] {
+			ids[chunk.ID] = true
+		}
+	}
+	return ids
+}
+
+// Verify compare les documents, les morceaux, les vecteurs et l'index des
+// mots-clés du RAG sans rien modifier
+func (r *RagSystem) Verify() *Inconsistencies {
+	inc := &Inconsistencies{
+		Generation:        r.Generation,
+		VectorGeneration:  r.VectorStore.Generation,
+		ContentGeneration: r.Generation,
+	}
+
+	docs := make(map[string]bool, len(r.Documents))
+	for _, doc := range r.Documents {
+		docs[doc.ID] = true
+	}
+	if r.ContentStore != nil {
+		inc.ContentGeneration = r.ContentStore.Generation()
+		for _, doc := range r.Documents {
+			if doc.Content == "" && !r.ContentStore.HasContent(doc.ID) {
+				inc.MissingContent = append(inc.MissingContent, doc.ID)
+			}
+		}
+	}
+	for _, chunk := range r.Chunks {
+		if !docs[chunk.DocumentID] {
+			inc.OrphanChunks = append(inc.OrphanChunks, chunk.ID)
+		}
+	}
+
+	expected := r.indexedIDs()
+	found := make(map[string]bool, len(r.VectorStore.Items))
+	dim := r.VectorStore.Dimension()
+	for _, item := range r.VectorStore.Items {
+		found[item.ID] = true
+		switch {
+		case !expected[item.ID]:
+			inc.OrphanVectors = append(inc.OrphanVectors, item.ID)
+		case len(item.Vector) != dim || len(item.Vector) == 0:
+			inc.InvalidVectors = append(inc.InvalidVectors, item.ID)
+		}
+	}
+	for _, chunk := range r.Chunks {
+		if expected[chunk.ID] && !found[chunk.ID] {
+			inc.MissingVectors = append(inc.MissingVectors, chunk.ID)
+		}
+	}
+	if r.IsChunked() {
+		// Un document a toujours du texte, donc au moins un morceau
+		chunked := make(map[string]bool, len(r.Documents))
+		for _, chunk := range r.Chunks {
+			chunked[chunk.DocumentID] = true
+		}
+		for _, doc := range r.Documents {
+			if !chunked[doc.ID] {
+				inc.MissingVectors = append(inc.MissingVectors, doc.ID)
+			}
+		}
+	} else {
+		for _, doc := range r.Documents {
+			if !found[doc.ID] {
+				inc.MissingVectors = append(inc.MissingVectors, doc.ID)
+			}
+		}
+	}
+
+	inc.StaleKeywordIndex = r.KeywordIndex == nil ||
+		r.KeywordIndex.Generation != r.Generation ||
+		r.KeywordIndex.Len() != len(expected)
+
+	return inc
+}
+
+// Repair retire les documents sans texte, que update-rag ré-indexera, les
+// morceaux orphelins et les vecteurs orphelins ou invalides, puis
+// reconstruit l'index des mots-clés et les métadonnées. Retourne les
+// identifiants des morceaux (ou des documents) dont l'embedding doit être
+// recalculé ; les documents sans morceau d'un RAG découpé doivent être
+// découpés à nouveau.
+func (r *RagSystem) Repair(inc *Inconsistencies) ([]string, error) {
+	removed := make(map[string]bool)
+	for _, id := range inc.MissingContent {
+		for _, chunk := range r.Chunks {
+			if chunk.DocumentID == id {
+				removed[chunk.ID] = true
+			}
+		}
+		removed[id] = true
+		r.RemoveDocument(id)
+	}
+
+	if len(inc.OrphanChunks) > 0 {
+		orphans := make(map[string]bool, len(inc.OrphanChunks))
+		for _, id := range inc.OrphanChunks {
+			orphans[id] = true
+		}
+		chunks := r.Chunks[:0]
+		for _, chunk := range r.Chunks {
+			if !orphans[chunk.ID] {
+				chunks = append(chunks, chunk)
+			}
+		}
+		r.Chunks = chunks
+	}
+
+	for _, id := range inc.OrphanVectors {
+		r.VectorStore.Remove(id)
+	}
+	for _, id := range inc.InvalidVectors {
+		r.VectorStore.Remove(id)
+	}
+
+	if err := r.BuildKeywordIndex(); err != nil {
+		return nil, err
+	}
+	r.RefreshMetadata()
+	r.UpdatedAt = time.Now()
+
+	var missing []string
+	for _, ids := range [][]string{inc.MissingVectors, inc.InvalidVectors} {
+		for _, id := range ids {
+			if !removed[id] {
+				missing = append(missing, id)
+			}
+		}
+	}
+	return missing, nil
+}
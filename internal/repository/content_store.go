@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/golvellius32/rlama/internal/domain"
+)
+
+// Content storage layout (all integers little-endian):
+//
+//	magic       [4]byte  "RLCS"
+//	version     uint32
+//	generation  uint64
+//	tableOffset uint64
+//	texts       the texts of the documents, one after the other
+//	table       count uint32; [count]{id string; length uint64}
+//
+// where strings are stored as {length uint32; bytes [length]byte}. The
+// table is written after the texts so that they can be streamed from the
+// previous file without knowing their length in advance.
+const (
+	contentMagic      = "RLCS"
+	contentVersion    = 1
+	contentHeaderSize = 24
+)
+
+// contentEntry locates the text of a document in the content file
+type contentEntry struct {
+	offset int64
+	length int64
+}
+
+// contentStore reads the texts of documents from a content file. The file
+// stays open so that texts can still be read after a save replaces it.
+type contentStore struct {
+	file       *os.File
+	generation uint64
+	entries    map[string]contentEntry
+}
+
+// openContentStore opens a content file and reads its table
+func openContentStore(path string) (*contentStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	store, err := readContentTable(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("invalid content file %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// readContentTable reads the header and the table of a content file
+func readContentTable(f *os.File) (*contentStore, error) {
+	var header [contentHeaderSize]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil || string(header[:4]) != contentMagic {
+		return nil, fmt.Errorf("not a content file")
+	}
+	if version := binary.LittleEndian.Uint32(header[4:8]); version != contentVersion {
+		return nil, fmt.Errorf("unsupported content file version %d", version)
+	}
+	tableOffset := int64(binary.LittleEndian.Uint64(header[16:24]))
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if tableOffset < contentHeaderSize || tableOffset > info.Size() {
+		return nil, fmt.Errorf("truncated content file")
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(f, tableOffset, info.Size()-tableOffset))
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, fmt.Errorf("truncated content table")
+	}
+
+	store := &contentStore{
+		file:       f,
+		generation: binary.LittleEndian.Uint64(header[8:16]),
+		entries:    make(map[string]contentEntry, count),
+	}
+	offset := int64(contentHeaderSize)
+	for i := uint32(0); i < count; i++ {
+		var length uint32
+		if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+			return nil, fmt.Errorf("truncated content table")
+		}
+		id := make([]byte, length)
+		if _, err := io.ReadFull(r, id); err != nil {
+			return nil, fmt.Errorf("truncated content table")
+		}
+		var textLength uint64
+		if err := binary.Read(r, binary.LittleEndian, &textLength); err != nil {
+			return nil, fmt.Errorf("truncated content table")
+		}
+		if textLength > uint64(tableOffset-offset) {
+			return nil, fmt.Errorf("content of '%s' exceeds the file", id)
+		}
+		store.entries[string(id)] = contentEntry{offset: offset, length: int64(textLength)}
+		offset += int64(textLength)
+	}
+	return store, nil
+}
+
+// HasContent reports whether the file contains the text of a document
+func (s *contentStore) HasContent(id string) bool {
+	_, ok := s.entries[id]
+	return ok
+}
+
+// ReadContent returns the bytes start to end of the text of a document, up
+// to the end of the text if end is negative
+func (s *contentStore) ReadContent(id string, start, end int) (string, error) {
+	entry, ok := s.entries[id]
+	if !ok {
+		return "", fmt.Errorf("no content for document '%s'", id)
+	}
+	if end < 0 || int64(end) > entry.length {
+		end = int(entry.length)
+	}
+	if start < 0 || start > end {
+		return "", fmt.Errorf("invalid range %d-%d in the content of document '%s'", start, end, id)
+	}
+
+	buf := make([]byte, end-start)
+	if _, err := s.file.ReadAt(buf, entry.offset+int64(start)); err != nil {
+		return "", fmt.Errorf("unable to read the content of document '%s': %w", id, err)
+	}
+	return string(buf), nil
+}
+
+// Generation returns the generation of the save that wrote the file
+func (s *contentStore) Generation() uint64 {
+	return s.generation
+}
+
+// Close closes the content file
+func (s *contentStore) Close() error {
+	return s.file.Close()
+}
+
+// writeContentStore writes the texts of the documents of a RAG to path. The
+// texts that are not in memory are copied from the current content file. The
+// file is written next to path and renamed over it.
+func writeContentStore(path string, rag *domain.RagSystem, generation uint64) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	var header [contentHeaderSize]byte
+	copy(header[:4], contentMagic)
+	binary.LittleEndian.PutUint32(header[4:8], contentVersion)
+	binary.LittleEndian.PutUint64(header[8:16], generation)
+	w.Write(header[:])
+
+	lengths := make([]uint64, len(rag.Documents))
+	offset := uint64(contentHeaderSize)
+	for i, doc := range rag.Documents {
+		text, err := rag.DocumentText(doc)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.WriteString(text)
+		lengths[i] = uint64(len(text))
+		offset += lengths[i]
+	}
+
+	var buf [8]byte
+	binary.LittleEndian.PutUint32(buf[:4], uint32(len(rag.Documents)))
+	w.Write(buf[:4])
+	for i, doc := range rag.Documents {
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(doc.ID)))
+		w.Write(buf[:4])
+		w.WriteString(doc.ID)
+		binary.LittleEndian.PutUint64(buf[:], lengths[i])
+		w.Write(buf[:])
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	binary.LittleEndian.PutUint64(buf[:], offset)
+	if _, err := tmp.WriteAt(buf[:], 16); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
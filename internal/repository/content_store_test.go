@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golvellius32/rlama/internal/domain"
+)
+
+// writeTestContent writes the texts of a RAG to a content file and opens it
+func writeTestContent(t *testing.T, rag *domain.RagSystem, generation uint64) *contentStore {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "content.bin")
+	if err := writeContentStore(path, rag, generation); err != nil {
+		t.Fatal(err)
+	}
+	store, err := openContentStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestContentStoreReadContent(t *testing.T) {
+	rag := newTestRag("docs", "Première ligne.\nDeuxième ligne.", "", "Third text")
+	store := writeTestContent(t, rag, 7)
+	first, empty, third := rag.Documents[0], rag.Documents[1], rag.Documents[2]
+
+	if store.Generation() != 7 {
+		t.Errorf("Generation() = %d, want 7", store.Generation())
+	}
+	if !store.HasContent(first.ID) || !store.HasContent(empty.ID) || store.HasContent("unknown") {
+		t.Error("HasContent doesn't match the documents written")
+	}
+
+	tests := []struct {
+		id         string
+		start, end int
+		want       string
+	}{
+		{first.ID, 0, -1, first.Content},
+		{first.ID, 0, len("Première"), "Première"},
+		{first.ID, len("Première ligne.\n"), -1, "Deuxième ligne."},
+		{first.ID, 3, 3, ""},
+		// The end is clamped to the length of the text
+		{third.ID, 6, 1000, "text"},
+		{third.ID, 0, -1, "Third text"},
+		{empty.ID, 0, -1, ""},
+	}
+	for _, tt := range tests {
+		got, err := store.ReadContent(tt.id, tt.start, tt.end)
+		if err != nil {
+			t.Errorf("ReadContent(%s, %d, %d): %v", tt.id, tt.start, tt.end, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ReadContent(%s, %d, %d) = %q, want %q", tt.id, tt.start, tt.end, got, tt.want)
+		}
+	}
+
+	for _, bad := range []struct {
+		id         string
+		start, end int
+	}{
+		{"unknown", 0, -1},
+		{first.ID, -1, 5},
+		{first.ID, 5, 2},
+		{third.ID, 20, -1},
+	} {
+		if _, err := store.ReadContent(bad.id, bad.start, bad.end); err == nil {
+			t.Errorf("ReadContent(%s, %d, %d) succeeded, want an error", bad.id, bad.start, bad.end)
+		}
+	}
+}
+
+func TestOpenContentStoreInvalid(t *testing.T) {
+	rag := newTestRag("docs", "Some text.")
+	path := filepath.Join(t.TempDir(), "content.bin")
+	if err := writeContentStore(path, rag, 1); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string][]byte{
+		"empty":           nil,
+		"not a store":     []byte("definitely not a content store file"),
+		"truncated table": data[:len(data)-4],
+		"truncated texts": append(append([]byte(nil), data[:contentHeaderSize]...), data[len(data)-8:]...),
+		"unknown version": append(append([]byte(nil), data[:4]...), append([]byte{9, 0, 0, 0}, data[8:]...)...),
+	} {
+		bad := filepath.Join(t.TempDir(), "content.bin")
+		if err := os.WriteFile(bad, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if store, err := openContentStore(bad); err == nil {
+			store.Close()
+			t.Errorf("%s: openContentStore succeeded, want an error", name)
+		}
+	}
+}
+
+func TestContentLoadedLazily(t *testing.T) {
+	repo := newTestRepository(t)
+	rag := newTestRag("docs", "Error E1234 means the disk is full.")
+	if err := repo.Save(rag); err != nil {
+		t.Fatal(err)
+	}
+
+	// The text is not in info.json
+	info, err := os.ReadFile(filepath.Join(repo.getRagPath("docs"), "info.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(info), "disk is full") {
+		t.Error("info.json holds the text of the documents")
+	}
+
+	loaded, err := repo.Load("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+	doc, chunk := loaded.Documents[0], loaded.Chunks[0]
+	if doc.Content != "" || chunk.Content != "" {
+		t.Fatal("the text was read when loading the RAG")
+	}
+	text, err := loaded.ChunkText(chunk)
+	if err != nil || text != "Error E1234 means the disk is full." {
+		t.Errorf("ChunkText = %q, %v", text, err)
+	}
+}
+
+func TestContentStoreAfterNewerSave(t *testing.T) {
+	repo := newTestRepository(t)
+	if err := repo.Save(newTestRag("docs", "Original text of the document.")); err != nil {
+		t.Fatal(err)
+	}
+	old, err := repo.Load("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+
+	// Another process replaces the document and saves a newer generation
+	other := NewRagRepository()
+	newer, err := other.Load("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer.RemoveDocument(newer.Documents[0].ID)
+	addTestDocument(newer, "/src/doc0.txt", "Completely different text, and longer than the first one.")
+	if err := other.Save(newer); err != nil {
+		t.Fatal(err)
+	}
+	newer.Close()
+
+	// The RAG loaded before keeps reading the texts of its own save
+	if old.ContentStore.Generation() != 1 {
+		t.Errorf("generation of the open store = %d, want 1", old.ContentStore.Generation())
+	}
+	text, err := old.ChunkText(old.Chunks[0])
+	if err != nil || text != "Original text of the document." {
+		t.Errorf("ChunkText after a newer save = %q, %v", text, err)
+	}
+
+	reloaded, err := repo.Load("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+	if reloaded.Generation != 2 || reloaded.ContentStore.Generation() != 2 {
+		t.Errorf("reloaded generation %d, content generation %d, want 2", reloaded.Generation, reloaded.ContentStore.Generation())
+	}
+}
@@ -0,0 +1,304 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultCacheMaxBytes is the default size limit of the embedding cache
+const DefaultCacheMaxBytes = 1 << 30
+
+// cacheMagic starts every entry of the embedding cache
+const cacheMagic = "RLEC"
+
+// EmbeddingCache stores embeddings on disk, addressed by the SHA-256 of the
+// embedding model and the embedded text, so identical text is only embedded
+// once per model across RAGs. Each entry is a file holding the model name and
+// the vector; its modification time records the last use, and the least
+// recently used entries are evicted once the cache exceeds MaxBytes.
+type EmbeddingCache struct {
+	dir string
+	// MaxBytes is the size the cache is kept under
+	MaxBytes int64
+
+	mu sync.Mutex
+	// size is the total size of the entries, -1 until it is measured
+	size int64
+}
+
+// CacheStats describes the content of the embedding cache
+type CacheStats struct {
+	Entries  int
+	Bytes    int64
+	MaxBytes int64
+	// Models counts the entries of each embedding model
+	Models map[string]int
+	Oldest time.Time
+	Newest time.Time
+}
+
+// NewEmbeddingCache opens the embedding cache of the data folder
+func NewEmbeddingCache() *EmbeddingCache {
+	return &EmbeddingCache{
+		dir:      filepath.Join(DataDir(), ".cache"),
+		MaxBytes: DefaultCacheMaxBytes,
+		size:     -1,
+	}
+}
+
+// Dir returns the folder of the cache
+func (c *EmbeddingCache) Dir() string {
+	return c.dir
+}
+
+// entryPath returns the file of the entry for a model and a text
+func (c *EmbeddingCache) entryPath(model, text string) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	h.Write([]byte{0})
+	h.Write([]byte(text))
+	key := hex.EncodeToString(h.Sum(nil))
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// Get returns the cached embedding of a text, and marks it as recently used
+func (c *EmbeddingCache) Get(model, text string) ([]float32, bool) {
+	path := c.entryPath(model, text)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	entryModel, embedding, err := decodeCacheEntry(data)
+	if err != nil || entryModel != model {
+		return nil, false
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return embedding, true
+}
+
+// Put stores the embedding of a text, evicting the least recently used
+// entries if the cache grows past its limit
+func (c *EmbeddingCache) Put(model, text string, embedding []float32) error {
+	path := c.entryPath(model, text)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("unable to create cache folder: %w", err)
+	}
+
+	// Write next to the entry and rename, so readers never see a partial entry
+	data := encodeCacheEntry(model, embedding)
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("unable to write cache entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write cache entry: %w", err)
+	}
+
+	var previous int64
+	if info, err := os.Stat(path); err == nil {
+		previous = info.Size()
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("unable to write cache entry: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size < 0 {
+		// Measure the cache once; the entry just written is included
+		c.size = c.scanSize()
+	} else {
+		c.size += int64(len(data)) - previous
+	}
+	if c.MaxBytes > 0 && c.size > c.MaxBytes {
+		return c.evict()
+	}
+	return nil
+}
+
+// cacheEntry is an entry found while walking the cache
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// entries lists the entries of the cache
+func (c *EmbeddingCache) entries() ([]cacheEntry, error) {
+	var entries []cacheEntry
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || filepath.Base(path)[0] == '.' {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		entries = append(entries, cacheEntry{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	return entries, err
+}
+
+// scanSize returns the total size of the entries
+func (c *EmbeddingCache) scanSize() int64 {
+	entries, _ := c.entries()
+	var size int64
+	for _, e := range entries {
+		size += e.size
+	}
+	return size
+}
+
+// evict removes the least recently used entries until the cache is back to
+// 90% of its limit, so that eviction doesn't run on every write
+func (c *EmbeddingCache) evict() error {
+	entries, err := c.entries()
+	if err != nil {
+		return fmt.Errorf("unable to read cache: %w", err)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	var size int64
+	for _, e := range entries {
+		size += e.size
+	}
+	target := c.MaxBytes / 10 * 9
+	for _, e := range entries {
+		if size <= target {
+			break
+		}
+		if err := os.Remove(e.path); err == nil || os.IsNotExist(err) {
+			size -= e.size
+		}
+	}
+	c.size = size
+	return nil
+}
+
+// Stats describes the content of the cache
+func (c *EmbeddingCache) Stats() (*CacheStats, error) {
+	entries, err := c.entries()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cache: %w", err)
+	}
+
+	stats := &CacheStats{MaxBytes: c.MaxBytes, Models: make(map[string]int)}
+	for _, e := range entries {
+		data, err := os.ReadFile(e.path)
+		if err != nil {
+			continue
+		}
+		model, _, err := decodeCacheEntry(data)
+		if err != nil {
+			continue
+		}
+		stats.Entries++
+		stats.Bytes += e.size
+		stats.Models[model]++
+		if stats.Oldest.IsZero() || e.modTime.Before(stats.Oldest) {
+			stats.Oldest = e.modTime
+		}
+		if e.modTime.After(stats.Newest) {
+			stats.Newest = e.modTime
+		}
+	}
+	return stats, nil
+}
+
+// Clear removes the entries of a model, or all entries if model is empty,
+// and returns the number of entries removed
+func (c *EmbeddingCache) Clear(model string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = -1
+
+	if model == "" {
+		entries, err := c.entries()
+		if err != nil {
+			return 0, fmt.Errorf("unable to read cache: %w", err)
+		}
+		if err := os.RemoveAll(c.dir); err != nil {
+			return 0, fmt.Errorf("unable to clear cache: %w", err)
+		}
+		return len(entries), nil
+	}
+
+	entries, err := c.entries()
+	if err != nil {
+		return 0, fmt.Errorf("unable to read cache: %w", err)
+	}
+	removed := 0
+	for _, e := range entries {
+		data, err := os.ReadFile(e.path)
+		if err != nil {
+			continue
+		}
+		if entryModel, _, err := decodeCacheEntry(data); err == nil && entryModel == model {
+			if err := os.Remove(e.path); err == nil {
+				removed++
+			}
+		}
+	}
+	return removed, nil
+}
+
+// encodeCacheEntry serializes an entry: the magic, the length of the model
+// name, the model name and the little-endian float32 vector
+func encodeCacheEntry(model string, embedding []float32) []byte {
+	header := len(cacheMagic) + 4
+	data := make([]byte, header+len(model)+4*len(embedding))
+	copy(data, cacheMagic)
+	binary.LittleEndian.PutUint32(data[len(cacheMagic):], uint32(len(model)))
+	copy(data[header:], model)
+	vector := data[header+len(model):]
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint32(vector[i*4:], math.Float32bits(v))
+	}
+	return data
+}
+
+// decodeCacheEntry reads an entry written by encodeCacheEntry
+func decodeCacheEntry(data []byte) (string, []float32, error) {
+	header := len(cacheMagic) + 4
+	if len(data) < header || string(data[:len(cacheMagic)]) != cacheMagic {
+		return "", nil, fmt.Errorf("invalid cache entry")
+	}
+	modelLength := int(binary.LittleEndian.Uint32(data[len(cacheMagic):header]))
+	if modelLength > len(data)-header || (len(data)-header-modelLength)%4 != 0 {
+		return "", nil, fmt.Errorf("invalid cache entry")
+	}
+
+	model := string(data[header : header+modelLength])
+	vector := data[header+modelLength:]
+	embedding := make([]float32, len(vector)/4)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(vector[i*4:]))
+	}
+	return model, embedding, nil
+}
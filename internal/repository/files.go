@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it over path, so that a crash leaves either the previous or the new content
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// syncDir flushes the entries of a folder, so that renames into it survive
+// a crash. Errors are ignored: not all platforms can sync a folder.
+func syncDir(path string) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	dir.Sync()
+	dir.Close()
+}
+
+// TempFiles returns the temporary files left in the folder of a RAG by
+// saves that were interrupted. Their names end with ".tmp-" or ".tmp"
+// followed by random digits, which keeps prompt.tmpl out.
+func (r *RagRepository) TempFiles(ragName string) ([]string, error) {
+	var files []string
+	for _, pattern := range []string{"*.tmp-*", "*.tmp[0-9]*"} {
+		matches, err := filepath.Glob(filepath.Join(r.getRagPath(ragName), pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// RemoveTempFiles deletes the temporary files left in the folder of a RAG
+func (r *RagRepository) RemoveTempFiles(ragName string) error {
+	files, err := r.TempFiles(ragName)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "info.json")
+
+	if err := writeFileAtomic(path, []byte("first"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("second"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "second" {
+		t.Fatalf("content %q, %v, want \"second\"", data, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("mode %v, %v, want 0644", info.Mode().Perm(), err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("temporary files left: %d entries", len(entries))
+	}
+
+	// A failed write leaves nothing behind
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "info.json"), []byte("x"), 0644); err == nil {
+		t.Error("writing into a missing folder succeeded")
+	}
+}
+
+func TestTempFiles(t *testing.T) {
+	repo := newTestRepository(t)
+	ragPath := repo.getRagPath("docs")
+	if err := os.MkdirAll(ragPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"info.json", "prompt.tmpl", "info.json.tmp-123", "keywords.json.tmp456", "vectors.bin.tmp-789"} {
+		if err := os.WriteFile(filepath.Join(ragPath, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := repo.TempFiles("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, file := range files {
+		files[i] = filepath.Base(file)
+	}
+	sort.Strings(files)
+	if want := []string{"info.json.tmp-123", "keywords.json.tmp456", "vectors.bin.tmp-789"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("TempFiles = %q, want %q", files, want)
+	}
+
+	if err := repo.RemoveTempFiles("docs"); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(ragPath)
+	var left []string
+	for _, entry := range entries {
+		left = append(left, entry.Name())
+	}
+	if want := []string{"info.json", "prompt.tmpl"}; !reflect.DeepEqual(left, want) {
+		t.Errorf("left %q, want %q", left, want)
+	}
+}
@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/golvellius32/rlama/internal/domain"
+	"github.com/golvellius32/rlama/pkg/bm25"
+	"github.com/golvellius32/rlama/pkg/vector"
+)
+
+// jsonStorage keeps a RAG in several files: its information in info.json,
+// its vectors in vectors.bin, its keyword index in keywords.json and the
+// text of its documents in content.bin
+type jsonStorage struct{}
+
+// Name returns the name of the storage
+func (jsonStorage) Name() string {
+	return StorageJSON
+}
+
+// infoPath returns the path of the RAG information file
+func (jsonStorage) infoPath(ragPath string) string {
+	return filepath.Join(ragPath, "info.json")
+}
+
+// vectorStorePath returns the path of the vector storage file
+func (jsonStorage) vectorStorePath(ragPath string) string {
+	return filepath.Join(ragPath, "vectors.bin")
+}
+
+// keywordIndexPath returns the path of the keyword (BM25) index file
+func (jsonStorage) keywordIndexPath(ragPath string) string {
+	return filepath.Join(ragPath, "keywords.json")
+}
+
+// contentPath returns the path of the file holding the text of the documents
+func (jsonStorage) contentPath(ragPath string) string {
+	return filepath.Join(ragPath, "content.bin")
+}
+
+// legacyVectorStorePath returns the path of the JSON vector storage file
+// written by previous versions
+func (jsonStorage) legacyVectorStorePath(ragPath string) string {
+	return filepath.Join(ragPath, "vectors.json")
+}
+
+// Exists checks if the folder contains a RAG information file
+func (s jsonStorage) Exists(ragPath string) bool {
+	_, err := os.Stat(s.infoPath(ragPath))
+	return err == nil
+}
+
+// Save writes each file to a temporary file and renames it. The information
+// file is written last: a RAG being created only becomes visible once its
+// vectors are saved.
+func (s jsonStorage) Save(ragPath string, rag *domain.RagSystem, generation uint64) error {
+	// Save the Vector Store
+	rag.VectorStore.SetIndexConfig(rag.Index)
+	rag.VectorStore.Generation = generation
+	err := rag.VectorStore.Save(s.vectorStorePath(ragPath))
+	if err != nil {
+		return fmt.Errorf("unable to save Vector Store: %w", err)
+	}
+
+	// Save the keyword index
+	if rag.KeywordIndex != nil {
+		rag.KeywordIndex.Generation = generation
+		if err := rag.KeywordIndex.Save(s.keywordIndexPath(ragPath)); err != nil {
+			return fmt.Errorf("unable to save keyword index: %w", err)
+		}
+	}
+
+	// Save the text of the documents
+	contentPath := s.contentPath(ragPath)
+	if err := writeContentStore(contentPath, rag, generation); err != nil {
+		return fmt.Errorf("unable to save document content: %w", err)
+	}
+
+	// Save RAG information: settings, documents and chunks, without their text
+	ragInfo := *rag // Copy to avoid modifying the original
+	ragInfo.Generation = generation
+
+	// Serialize and save the info.json file
+	infoJSON, err := json.MarshalIndent(ragInfo, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to serialize RAG information: %w", err)
+	}
+
+	err = writeFileAtomic(s.infoPath(ragPath), infoJSON, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to save RAG information: %w", err)
+	}
+	syncDir(ragPath)
+
+	// The vectors of previous versions are now in the binary file
+	if err := os.Remove(s.legacyVectorStorePath(ragPath)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove legacy Vector Store: %w", err)
+	}
+
+	// Read the text that isn't in memory from the new file from now on
+	if store, err := openContentStore(contentPath); err == nil {
+		if rag.ContentStore != nil {
+			rag.ContentStore.Close()
+		}
+		rag.ContentStore = store
+	}
+	return nil
+}
+
+// Load reads the files of a RAG system
+func (s jsonStorage) Load(ragPath string) (*domain.RagSystem, error) {
+	// Load RAG information
+	infoBytes, err := os.ReadFile(s.infoPath(ragPath))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read RAG information: %w", err)
+	}
+
+	var ragInfo domain.RagSystem
+	err = json.Unmarshal(infoBytes, &ragInfo)
+	if err != nil {
+		return nil, fmt.Errorf("unable to deserialize RAG information: %w", err)
+	}
+
+	// Create a new Vector Store and load it from the file. RAGs created by
+	// previous versions have a JSON file, converted by their next save.
+	vectorPath := s.vectorStorePath(ragPath)
+	if _, err := os.Stat(vectorPath); os.IsNotExist(err) {
+		vectorPath = s.legacyVectorStorePath(ragPath)
+	}
+	ragInfo.VectorStore = vector.NewStore()
+	err = ragInfo.VectorStore.Load(vectorPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load Vector Store: %w", err)
+	}
+	ragInfo.VectorStore.SetIndexConfig(ragInfo.Index)
+
+	// Open the text of the documents, kept in info.json by previous versions
+	store, err := openContentStore(s.contentPath(ragPath))
+	if os.IsNotExist(err) {
+		err = readLegacyContent(infoBytes, &ragInfo)
+	} else if err == nil {
+		ragInfo.ContentStore = store
+	}
+	if err != nil {
+		ragInfo.Close()
+		return nil, fmt.Errorf("unable to load document content: %w", err)
+	}
+
+	// Load the keyword index, built from the chunks for RAGs created before it existed
+	ragInfo.KeywordIndex, err = bm25.Load(s.keywordIndexPath(ragPath))
+	if os.IsNotExist(err) {
+		err = ragInfo.BuildKeywordIndex()
+	}
+	if err != nil {
+		ragInfo.Close()
+		return nil, fmt.Errorf("unable to load keyword index: %w", err)
+	}
+
+	// RAGs saved before vectors carried metadata get it from their documents
+	if len(ragInfo.VectorStore.Items) > 0 && !ragInfo.VectorStore.HasMetadata() {
+		ragInfo.RefreshMetadata()
+	}
+
+	return &ragInfo, nil
+}
+
+// legacyInfo is the text of documents and chunks stored in info.json by
+// previous versions
+type legacyInfo struct {
+	Documents []struct {
+		ID      string `json:"id"`
+		Content string `json:"content"`
+	} `json:"documents"`
+	Chunks []struct {
+		ID      string `json:"id"`
+		Content string `json:"content"`
+	} `json:"chunks"`
+}
+
+// readLegacyContent fills the text of the documents and chunks of a RAG
+// from an info.json file written by a previous version
+func readLegacyContent(infoBytes []byte, rag *domain.RagSystem) error {
+	var legacy legacyInfo
+	if err := json.Unmarshal(infoBytes, &legacy); err != nil {
+		return err
+	}
+	for i, doc := range legacy.Documents {
+		if i < len(rag.Documents) && rag.Documents[i].ID == doc.ID {
+			rag.Documents[i].Content = doc.Content
+		}
+	}
+	for i, chunk := range legacy.Chunks {
+		if i < len(rag.Chunks) && rag.Chunks[i].ID == chunk.ID {
+			rag.Chunks[i].Content = chunk.Content
+		}
+	}
+	return nil
+}
+
+// Summarize reads the summary of a RAG system from its information file
+func (s jsonStorage) Summarize(ragPath string) (RagSummary, error) {
+	infoBytes, err := os.ReadFile(s.infoPath(ragPath))
+	if err != nil {
+		return RagSummary{}, fmt.Errorf("unable to read RAG information: %w", err)
+	}
+	var info summaryInfo
+	if err := json.Unmarshal(infoBytes, &info); err != nil {
+		return RagSummary{}, fmt.Errorf("unable to deserialize RAG information: %w", err)
+	}
+	return info.summary(len(info.Chunks)), nil
+}
+
+// Remove deletes the files of the RAG. The information file goes first, so
+// that an interrupted removal doesn't leave a RAG without vectors behind.
+func (s jsonStorage) Remove(ragPath string) error {
+	vectorPath := s.vectorStorePath(ragPath)
+	paths := []string{
+		s.infoPath(ragPath),
+		vectorPath,
+		vector.IndexPath(vectorPath),
+		s.legacyVectorStorePath(ragPath),
+		s.keywordIndexPath(ragPath),
+		s.contentPath(ragPath),
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrLocked is returned when a RAG is being modified by another process, or
+// by another request of the API server
+var ErrLocked = errors.New("locked by another rlama process")
+
+// heldLocks holds the lock files of the RAGs locked by this process, since
+// platforms without flock can't tell two requests of the same process apart
+var (
+	heldLocksMu sync.Mutex
+	heldLocks   = make(map[string]bool)
+)
+
+// getRagLockPath returns the path of the lock file of a RAG
+func (r *RagRepository) getRagLockPath(ragName string) string {
+	return filepath.Join(r.getRagPath(ragName), ".lock")
+}
+
+// Lock takes the lock of a RAG for a sequence of loads and saves, so that
+// no other process modifies it in between. It fails with ErrLocked instead
+// of waiting when the RAG is already locked. Load and Save called on the
+// same repository while the lock is held don't take it again, so a
+// repository holding a lock must not be shared between goroutines.
+func (r *RagRepository) Lock(ragName string) (func(), error) {
+	path := r.getRagLockPath(ragName)
+
+	heldLocksMu.Lock()
+	defer heldLocksMu.Unlock()
+	if heldLocks[path] {
+		return nil, fmt.Errorf("RAG '%s' is %w", ragName, ErrLocked)
+	}
+
+	f, err := r.openLockFile(ragName)
+	if err != nil {
+		return nil, err
+	}
+	heldLocks[path] = true
+	r.setHeld(ragName, true)
+
+	unlock := func() {
+		r.setHeld(ragName, false)
+		// Don't leave an empty folder behind when creating a RAG failed early
+		if !r.Exists(ragName) && !r.StagingExists(ragName) {
+			os.Remove(path)
+			os.Remove(r.getRagPath(ragName))
+		}
+		unlockFile(f)
+		f.Close()
+
+		heldLocksMu.Lock()
+		delete(heldLocks, path)
+		heldLocksMu.Unlock()
+	}
+	return unlock, nil
+}
+
+// openLockFile opens and locks the lock file of a RAG
+func (r *RagRepository) openLockFile(ragName string) (*os.File, error) {
+	if err := os.MkdirAll(r.getRagPath(ragName), 0755); err != nil {
+		return nil, fmt.Errorf("unable to create folder for RAG: %w", err)
+	}
+
+	path := r.getRagLockPath(ragName)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open lock file of RAG '%s': %w", ragName, err)
+		}
+		if err := lockFile(f); err != nil {
+			f.Close()
+			if errors.Is(err, ErrLocked) {
+				return nil, fmt.Errorf("RAG '%s' is %w", ragName, ErrLocked)
+			}
+			return nil, fmt.Errorf("unable to lock RAG '%s': %w", ragName, err)
+		}
+
+		// The RAG may have been deleted, along with the file we locked,
+		// while we were opening it: lock the new file instead
+		locked, err1 := f.Stat()
+		current, err2 := os.Stat(path)
+		if err1 == nil && err2 == nil && os.SameFile(locked, current) {
+			return f, nil
+		}
+		unlockFile(f)
+		f.Close()
+		if err := os.MkdirAll(r.getRagPath(ragName), 0755); err != nil {
+			return nil, fmt.Errorf("unable to create folder for RAG: %w", err)
+		}
+	}
+}
+
+// setHeld records whether this repository holds the lock of a RAG
+func (r *RagRepository) setHeld(ragName string, held bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if held {
+		r.held[ragName] = true
+	} else {
+		delete(r.held, ragName)
+	}
+}
+
+// holds reports whether this repository holds the lock of a RAG
+func (r *RagRepository) holds(ragName string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.held[ragName]
+}
+
+// lockUnlessHeld takes the lock of a RAG for a single operation, unless
+// this repository already holds it
+func (r *RagRepository) lockUnlessHeld(ragName string) (func(), error) {
+	if r.holds(ragName) {
+		return func() {}, nil
+	}
+	return r.Lock(ragName)
+}
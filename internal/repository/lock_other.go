@@ -0,0 +1,18 @@
+//go:build !unix
+
+package repository
+
+import (
+	"os"
+)
+
+// lockFile is a no-op on platforms without flock: RAGs are then only
+// protected against concurrent changes from the same process
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile is a no-op since lockFile doesn't lock anything
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestLockContention(t *testing.T) {
+	repo := newTestRepository(t)
+	rag := newTestRag("docs", "Some text.")
+	if err := repo.Save(rag); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := repo.Lock("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second lock, from this repository or another request, fails at once
+	if _, err := repo.Lock("docs"); !errors.Is(err, ErrLocked) {
+		t.Errorf("second Lock = %v, want ErrLocked", err)
+	}
+	other := NewRagRepository()
+	if err := other.Save(rag); !errors.Is(err, ErrLocked) {
+		t.Errorf("Save from another repository = %v, want ErrLocked", err)
+	}
+	if err := other.Delete("docs"); !errors.Is(err, ErrLocked) {
+		t.Errorf("Delete from another repository = %v, want ErrLocked", err)
+	}
+
+	// The repository holding the lock saves without taking it again
+	if err := repo.Save(rag); err != nil {
+		t.Errorf("Save under the lock: %v", err)
+	}
+	// Other RAGs are not locked
+	if unlockOther, err := other.Lock("other"); err != nil {
+		t.Errorf("Lock of another RAG: %v", err)
+	} else {
+		unlockOther()
+	}
+
+	unlock()
+	if err := other.Save(rag); err != nil {
+		t.Errorf("Save after unlock: %v", err)
+	}
+}
+
+func TestLockCleansUpFailedCreation(t *testing.T) {
+	repo := newTestRepository(t)
+	unlock, err := repo.Lock("new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(repo.getRagLockPath("new")); err != nil {
+		t.Fatalf("lock file not created: %v", err)
+	}
+
+	// Nothing was saved: the folder created for the lock is removed
+	unlock()
+	if _, err := os.Stat(repo.getRagPath("new")); !os.IsNotExist(err) {
+		t.Errorf("the folder of a RAG never saved was left behind: %v", err)
+	}
+}
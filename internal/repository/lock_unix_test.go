@@ -0,0 +1,74 @@
+//go:build unix
+
+package repository
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// TestLockHelperProcess holds the lock of RAG "docs" for TestLockOtherProcess
+// until its standard input is closed
+func TestLockHelperProcess(t *testing.T) {
+	dir := os.Getenv("RLAMA_TEST_LOCK_DATA_DIR")
+	if dir == "" {
+		t.Skip("helper process of TestLockOtherProcess")
+	}
+	SetDataDir(dir)
+	unlock, err := NewRagRepository().Lock("docs")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("locked")
+	io.Copy(io.Discard, os.Stdin)
+	unlock()
+	os.Exit(0)
+}
+
+func TestLockOtherProcess(t *testing.T) {
+	repo := newTestRepository(t)
+	if err := repo.Save(newTestRag("docs", "Some text.")); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockHelperProcess$")
+	cmd.Env = append(os.Environ(), "RLAMA_TEST_LOCK_DATA_DIR="+repo.basePath)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || line != "locked\n" {
+		t.Fatalf("helper process: %q, %v", line, err)
+	}
+
+	if _, err := repo.Lock("docs"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Lock while another process holds it = %v, want ErrLocked", err)
+	}
+
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("helper process: %v", err)
+	}
+	unlock, err := repo.Lock("docs")
+	if err != nil {
+		t.Fatalf("Lock after the other process exited: %v", err)
+	}
+	unlock()
+}
@@ -1,36 +1,73 @@
 package repository
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
+	"github.com/golvellius32/rlama/internal/config"
 	"github.com/golvellius32/rlama/internal/domain"
-	"github.com/golvellius32/rlama/pkg/vector"
 )
 
 // RagRepository manages the persistence of RAG systems
 type RagRepository struct {
 	basePath string
+	// storage is used for new RAGs
+	storage Storage
+
+	mu sync.Mutex
+	// held records the RAGs whose lock is held through this repository
+	held map[string]bool
 }
 
-// NewRagRepository creates a new instance of RagRepository
-func NewRagRepository() *RagRepository {
+// dataDir replaces ~/.rlama when set with SetDataDir
+var dataDir string
+
+// SetDataDir sets the folder where RAG systems and the embedding cache are
+// stored. An empty dir restores the default ~/.rlama.
+func SetDataDir(dir string) {
+	dataDir = dir
+}
+
+// DataDir returns the folder where RAG systems and the embedding cache are stored
+func DataDir() string {
+	if dataDir != "" {
+		return dataDir
+	}
+
 	// Use ~/.rlama as the default data folder
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		homeDir = "."
 	}
 
-	basePath := filepath.Join(homeDir, ".rlama")
+	return filepath.Join(homeDir, ".rlama")
+}
+
+// NewRagRepository creates a new instance of RagRepository
+func NewRagRepository() *RagRepository {
+	basePath := DataDir()
 
 	// Create the folder if it doesn't exist
 	os.MkdirAll(basePath, 0755)
 
-	return &RagRepository{
+	repo := &RagRepository{
 		basePath: basePath,
+		storage:  jsonStorage{},
+		held:     make(map[string]bool),
+	}
+
+	// New RAGs use the storage chosen in the configuration file. An invalid
+	// configuration is reported by the commands when they read it.
+	if cfg, err := config.Load(); err == nil {
+		if storage, err := StorageByName(cfg.Storage); err == nil {
+			repo.storage = storage
+		}
 	}
+	return repo
 }
 
 // getRagPath returns the complete path for a given RAG
@@ -38,90 +75,269 @@ func (r *RagRepository) getRagPath(ragName string) string {
 	return filepath.Join(r.basePath, ragName)
 }
 
-// getRagInfoPath returns the path of the RAG information file
-func (r *RagRepository) getRagInfoPath(ragName string) string {
-	return filepath.Join(r.getRagPath(ragName), "info.json")
+// promptTemplateFile is the name of the prompt template copied into a RAG folder
+const promptTemplateFile = "prompt.tmpl"
+
+// PromptTemplatePath returns the path of the prompt template of a RAG.
+// Relative paths are resolved from the RAG folder.
+func (r *RagRepository) PromptTemplatePath(rag *domain.RagSystem) string {
+	if rag.PromptTemplate == "" || filepath.IsAbs(rag.PromptTemplate) {
+		return rag.PromptTemplate
+	}
+	return filepath.Join(r.getRagPath(rag.Name), rag.PromptTemplate)
 }
 
-// getRagVectorStorePath returns the path of the vector storage file
-func (r *RagRepository) getRagVectorStorePath(ragName string) string {
-	return filepath.Join(r.getRagPath(ragName), "vectors.json")
+// SavePromptTemplate stores a prompt template in the RAG folder and returns
+// the value to record in the RAG information
+func (r *RagRepository) SavePromptTemplate(ragName, text string) (string, error) {
+	if err := os.MkdirAll(r.getRagPath(ragName), 0755); err != nil {
+		return "", fmt.Errorf("unable to create folder for RAG: %w", err)
+	}
+	path := filepath.Join(r.getRagPath(ragName), promptTemplateFile)
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		return "", fmt.Errorf("unable to save prompt template: %w", err)
+	}
+	return promptTemplateFile, nil
 }
 
 // Exists checks if a RAG exists
 func (r *RagRepository) Exists(ragName string) bool {
-	_, err := os.Stat(r.getRagInfoPath(ragName))
-	return err == nil
+	return r.StorageOf(ragName) != nil
+}
+
+// StorageOf returns the storage holding a RAG, or nil if it doesn't exist
+func (r *RagRepository) StorageOf(ragName string) Storage {
+	ragPath := r.getRagPath(ragName)
+	for _, storage := range storages {
+		if storage.Exists(ragPath) {
+			return storage
+		}
+	}
+	return nil
+}
+
+// InconsistentError is returned by Load when the vectors and the information
+// of a RAG were written by different saves, e.g. after a crash during a save
+type InconsistentError struct {
+	RagName           string
+	Generation        uint64
+	VectorGeneration  uint64
+	ContentGeneration uint64
+}
+
+func (e *InconsistentError) Error() string {
+	return fmt.Sprintf("RAG '%s' is inconsistent: its files were written by different saves (information %d, vectors %d, content %d). Run 'rlama verify %s --repair' to repair it",
+		e.RagName, e.Generation, e.VectorGeneration, e.ContentGeneration, e.RagName)
 }
 
-// Save saves a RAG system
+// Save saves a RAG system in its storage, or in the configured storage for a
+// new RAG. Each save increments the generation written into the files of the
+// RAG, so that Load can detect files left by an interrupted save.
 func (r *RagRepository) Save(rag *domain.RagSystem) error {
+	unlock, err := r.lockUnlessHeld(rag.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	storage := r.StorageOf(rag.Name)
+	if storage == nil {
+		storage = r.storage
+	}
+
 	ragPath := r.getRagPath(rag.Name)
 
 	// Create the folder for this RAG
-	err := os.MkdirAll(ragPath, 0755)
+	err = os.MkdirAll(ragPath, 0755)
 	if err != nil {
 		return fmt.Errorf("unable to create folder for RAG: %w", err)
 	}
 
-	// Save RAG information
-	ragInfo := *rag // Copy to avoid modifying the original
-
-	// Serialize and save the info.json file
-	infoJSON, err := json.MarshalIndent(ragInfo, "", "  ")
-	if err != nil {
-		return fmt.Errorf("unable to serialize RAG information: %w", err)
+	generation := rag.Generation + 1
+	if err := storage.Save(ragPath, rag, generation); err != nil {
+		return err
 	}
+	rag.Generation = generation
+	return nil
+}
 
-	err = os.WriteFile(r.getRagInfoPath(rag.Name), infoJSON, 0644)
-	if err != nil {
-		return fmt.Errorf("unable to save RAG information: %w", err)
+// loadAttempts is the number of times Load reads a RAG whose files don't
+// match before reporting it as inconsistent: a save in progress in another
+// process replaces them within a few milliseconds
+const loadAttempts = 5
+
+// Load loads a RAG system. It fails with an InconsistentError if its files
+// were not written by the same save. A RAG created by a previous version,
+// or whose keyword index is out of date, is rewritten and saved once under
+// its lock; the ID migration is reported in rag.Migration for the caller to
+// tell the user.
+func (r *RagRepository) Load(ragName string) (*domain.RagSystem, error) {
+	rag, rewritten, err := r.loadMigrated(ragName)
+	if err != nil || !rewritten {
+		return rag, err
 	}
 
-	// Save the Vector Store
-	err = rag.VectorStore.Save(r.getRagVectorStorePath(rag.Name))
-	if err != nil {
-		return fmt.Errorf("unable to save Vector Store: %w", err)
+	// Another process may have saved the RAG since it was read: it is read
+	// again under the lock, unless this repository already holds it
+	if !r.holds(ragName) {
+		unlock, err := r.Lock(ragName)
+		if errors.Is(err, ErrLocked) {
+			// The process modifying the RAG will save it
+			return rag, nil
+		}
+		rag.Close()
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+		if rag, rewritten, err = r.loadMigrated(ragName); err != nil || !rewritten {
+			return rag, err
+		}
 	}
 
-	return nil
+	if err := r.Save(rag); err != nil {
+		rag.Close()
+		return nil, fmt.Errorf("unable to save the migrated RAG '%s': %w", ragName, err)
+	}
+	return rag, nil
 }
 
-// Load loads a RAG system
-func (r *RagRepository) Load(ragName string) (*domain.RagSystem, error) {
-	// Check if the RAG exists
-	if !r.Exists(ragName) {
-		return nil, fmt.Errorf("RAG '%s' does not exist", ragName)
+// loadMigrated loads a RAG system and rewrites it in memory if needed, see
+// migrate. It reports whether the RAG was rewritten.
+func (r *RagRepository) loadMigrated(ragName string) (*domain.RagSystem, bool, error) {
+	var rag *domain.RagSystem
+	var err error
+	for attempt := 1; ; attempt++ {
+		rag, err = r.load(ragName)
+		if err != nil {
+			return nil, false, err
+		}
+		contentGeneration := rag.Generation
+		if rag.ContentStore != nil {
+			contentGeneration = rag.ContentStore.Generation()
+		}
+		if rag.VectorStore.Generation == rag.Generation && contentGeneration == rag.Generation {
+			break
+		}
+		rag.Close()
+		if attempt == loadAttempts {
+			return nil, false, &InconsistentError{
+				RagName:           ragName,
+				Generation:        rag.Generation,
+				VectorGeneration:  rag.VectorStore.Generation,
+				ContentGeneration: contentGeneration,
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
 
-	// Load RAG information
-	infoBytes, err := os.ReadFile(r.getRagInfoPath(ragName))
-	if err != nil {
-		return nil, fmt.Errorf("unable to read RAG information: %w", err)
+	// The keyword index can be rebuilt from the chunks
+	rebuilt := false
+	if rag.KeywordIndex.Generation != rag.Generation {
+		if err := rag.BuildKeywordIndex(); err != nil {
+			rag.Close()
+			return nil, false, fmt.Errorf("unable to rebuild keyword index: %w", err)
+		}
+		rebuilt = true
+	}
+
+	if err := r.migrate(rag); err != nil {
+		rag.Close()
+		return nil, false, err
 	}
+	return rag, rebuilt || rag.Migration != nil, nil
+}
 
-	var ragInfo domain.RagSystem
-	err = json.Unmarshal(infoBytes, &ragInfo)
+// LoadForRepair loads a RAG system even if its files don't match, for
+// 'rlama verify' to find and repair the differences. It is not saved: the
+// repair saves it.
+func (r *RagRepository) LoadForRepair(ragName string) (*domain.RagSystem, error) {
+	rag, err := r.load(ragName)
 	if err != nil {
-		return nil, fmt.Errorf("unable to deserialize RAG information: %w", err)
+		return nil, err
+	}
+	if err := r.migrate(rag); err != nil {
+		rag.Close()
+		return nil, err
+	}
+	return rag, nil
+}
+
+// load reads a RAG system from its storage
+func (r *RagRepository) load(ragName string) (*domain.RagSystem, error) {
+	// Check if the RAG exists
+	storage := r.StorageOf(ragName)
+	if storage == nil {
+		return nil, fmt.Errorf("RAG '%s' does not exist", ragName)
 	}
+	return storage.Load(r.getRagPath(ragName))
+}
 
-	// Create a new Vector Store and load it from the file
-	ragInfo.VectorStore = vector.NewStore()
-	err = ragInfo.VectorStore.Load(r.getRagVectorStorePath(ragName))
+// migrate rewrites in memory the RAGs that identified documents by their
+// file name, and records the rewrite in rag.Migration
+func (r *RagRepository) migrate(rag *domain.RagSystem) error {
+	migration, err := rag.MigrateDocumentIDs()
 	if err != nil {
-		return nil, fmt.Errorf("unable to load Vector Store: %w", err)
+		return fmt.Errorf("unable to migrate document IDs: %w", err)
 	}
+	rag.Migration = migration
+	return nil
+}
+
+// RagSummary describes a RAG system from its information, without loading
+// its vectors or the text of its documents
+type RagSummary struct {
+	Name           string
+	ModelName      string
+	EmbeddingModel string
+	Description    string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Retrieval      domain.RetrievalConfig
+	// Documents are listed without their text
+	Documents []*domain.Document
+	Chunks    int
+	// Err is set when the information of the RAG can't be read
+	Err error
+}
 
-	return &ragInfo, nil
+// summaryInfo is the part of the RAG information read by ListAll
+type summaryInfo struct {
+	Name           string                 `json:"name"`
+	ModelName      string                 `json:"model_name"`
+	EmbeddingModel string                 `json:"embedding_model"`
+	Description    string                 `json:"description"`
+	CreatedAt      time.Time              `json:"created_at"`
+	UpdatedAt      time.Time              `json:"updated_at"`
+	Retrieval      domain.RetrievalConfig `json:"retrieval"`
+	Documents      []*domain.Document     `json:"documents"`
+	Chunks         []struct{}             `json:"chunks"`
 }
 
-// ListAll returns the list of all available RAG systems
-func (r *RagRepository) ListAll() ([]string, error) {
+// summary returns the summary of a RAG with the given number of chunks
+func (info *summaryInfo) summary(chunks int) RagSummary {
+	summary := RagSummary{
+		ModelName:      info.ModelName,
+		EmbeddingModel: info.EmbeddingModel,
+		Description:    info.Description,
+		CreatedAt:      info.CreatedAt,
+		UpdatedAt:      info.UpdatedAt,
+		Retrieval:      info.Retrieval.WithDefaults(),
+		Documents:      info.Documents,
+		Chunks:         chunks,
+	}
+	if summary.EmbeddingModel == "" {
+		summary.EmbeddingModel = info.ModelName
+	}
+	return summary
+}
+
+// ListAll returns a summary of all available RAG systems, sorted by name
+func (r *RagRepository) ListAll() ([]RagSummary, error) {
 	// Check if the base folder exists
 	_, err := os.Stat(r.basePath)
 	if os.IsNotExist(err) {
-		return []string{}, nil // No RAGs available
+		return []RagSummary{}, nil // No RAGs available
 	}
 
 	// Read the folder contents
@@ -130,33 +346,114 @@ func (r *RagRepository) ListAll() ([]string, error) {
 		return nil, fmt.Errorf("unable to read RAGs folder: %w", err)
 	}
 
-	var ragNames []string
+	var summaries []RagSummary
 	for _, entry := range entries {
-		if entry.IsDir() {
-			// Check if it's a valid RAG folder (contains info.json)
-			infoPath := filepath.Join(r.basePath, entry.Name(), "info.json")
-			if _, err := os.Stat(infoPath); err == nil {
-				ragNames = append(ragNames, entry.Name())
-			}
+		if !entry.IsDir() {
+			continue
+		}
+		// Check if it's a valid RAG folder
+		storage := r.StorageOf(entry.Name())
+		if storage == nil {
+			continue
 		}
+		summary, err := storage.Summarize(r.getRagPath(entry.Name()))
+		summary.Name = entry.Name()
+		summary.Err = err
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, nil
+}
+
+// Convert rewrites a RAG system in another storage, then removes its data
+// from the previous one
+func (r *RagRepository) Convert(ragName string, to Storage) error {
+	unlock, err := r.lockUnlessHeld(ragName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	from := r.StorageOf(ragName)
+	if from == nil {
+		return fmt.Errorf("RAG '%s' does not exist", ragName)
 	}
+	if from.Name() == to.Name() {
+		return nil
+	}
+
+	rag, err := r.Load(ragName)
+	if err != nil {
+		return err
+	}
+	defer rag.Close()
+
+	ragPath := r.getRagPath(ragName)
+	generation := rag.Generation + 1
+	if err := to.Save(ragPath, rag, generation); err != nil {
+		return fmt.Errorf("unable to convert RAG '%s' to %s: %w", ragName, to.Name(), err)
+	}
+	rag.Generation = generation
 
-	return ragNames, nil
+	if err := from.Remove(ragPath); err != nil {
+		return fmt.Errorf("RAG '%s' was converted to %s but its %s files could not be removed: %w", ragName, to.Name(), from.Name(), err)
+	}
+	return nil
 }
 
-// Delete deletes a RAG system
+// Delete deletes a RAG system, or the staging folder of an interrupted indexing
 func (r *RagRepository) Delete(ragName string) error {
 	// Check if the RAG exists
-	if !r.Exists(ragName) {
+	if !r.Exists(ragName) && !r.StagingExists(ragName) {
 		return fmt.Errorf("RAG system '%s' does not exist", ragName)
 	}
 
+	unlock, err := r.lockUnlessHeld(ragName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// Delete the complete RAG folder
 	ragPath := r.getRagPath(ragName)
-	err := os.RemoveAll(ragPath)
+	err = os.RemoveAll(ragPath)
 	if err != nil {
 		return fmt.Errorf("error while deleting RAG system '%s': %w", ragName, err)
 	}
 
 	return nil
 }
+
+// RemoveData removes the RAG systems, the embedding cache and the pending
+// uploads from the data folder, then the folder itself if nothing else is
+// left in it: it may be shared with other files, which are kept. It reports
+// whether the folder was removed.
+func (r *RagRepository) RemoveData() (bool, error) {
+	entries, err := os.ReadDir(r.basePath)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to read data folder: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if r.Exists(name) || r.StagingExists(name) {
+			if err := r.Delete(name); err != nil {
+				return false, err
+			}
+		}
+	}
+	for _, dir := range []string{NewEmbeddingCache().Dir(), r.PendingUploadsPath()} {
+		if err := os.RemoveAll(dir); err != nil {
+			return false, fmt.Errorf("unable to remove %s: %w", dir, err)
+		}
+	}
+
+	// Fails if other files are left
+	return os.Remove(r.basePath) == nil, nil
+}
@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golvellius32/rlama/internal/domain"
+)
+
+// newTestRepository returns a repository over an empty temporary data folder
+func newTestRepository(t *testing.T) *RagRepository {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	SetDataDir(filepath.Join(home, "data"))
+	t.Cleanup(func() { SetDataDir("") })
+	return NewRagRepository()
+}
+
+// newTestRag builds a RAG with one chunk and one small embedding per text
+func newTestRag(name string, texts ...string) *domain.RagSystem {
+	rag := domain.NewRagSystem(name, "llama3", "")
+	rag.SourceFolder = "/src"
+	rag.Chunking = domain.DefaultChunkingConfig()
+	for i, text := range texts {
+		addTestDocument(rag, fmt.Sprintf("/src/doc%d.txt", i), text)
+	}
+	return rag
+}
+
+// addTestDocument adds a document made of a single chunk to rag
+func addTestDocument(rag *domain.RagSystem, path, text string) *domain.Document {
+	doc := domain.NewDocument(path, text)
+	doc.ID = rag.DocumentID(doc)
+	rag.AddDocument(doc)
+	chunk := domain.NewDocumentChunk(doc, 0, 0, len(doc.Content))
+	chunk.Embedding = []float32{float32(len(rag.Chunks) + 1), 1, 0}
+	rag.AddChunk(chunk)
+	return doc
+}
+
+// copyFiles copies the files of a folder into another with writeFileAtomic
+func copyFiles(from, to string, names ...string) error {
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(from, name))
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(to, name), data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSaveLoad(t *testing.T) {
+	repo := newTestRepository(t)
+	rag := newTestRag("docs", "Error E1234 means the disk is full.", "Run make install to install the tool.")
+	if err := repo.Save(rag); err != nil {
+		t.Fatal(err)
+	}
+	if !repo.Exists("docs") || rag.Generation != 1 {
+		t.Fatalf("after the first save: exists %v, generation %d", repo.Exists("docs"), rag.Generation)
+	}
+
+	loaded, err := repo.Load("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+
+	// Every file was written by the same save
+	if loaded.Generation != 1 || loaded.VectorStore.Generation != 1 || loaded.KeywordIndex.Generation != 1 ||
+		loaded.ContentStore == nil || loaded.ContentStore.Generation() != 1 {
+		t.Fatalf("generations: information %d, vectors %d, keywords %d", loaded.Generation, loaded.VectorStore.Generation, loaded.KeywordIndex.Generation)
+	}
+	if loaded.Migration != nil {
+		t.Errorf("a RAG with current IDs was migrated: %+v", loaded.Migration)
+	}
+	if len(loaded.Documents) != 2 || len(loaded.Chunks) != 2 || len(loaded.VectorStore.Items) != 2 {
+		t.Fatalf("loaded %d documents, %d chunks and %d vectors", len(loaded.Documents), len(loaded.Chunks), len(loaded.VectorStore.Items))
+	}
+	for i, chunk := range loaded.Chunks {
+		text, err := loaded.ChunkText(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := rag.Chunks[i].Content; text != want {
+			t.Errorf("chunk %s: text %q, want %q", chunk.ID, text, want)
+		}
+	}
+	if results := loaded.KeywordIndex.Search("E1234", 0); len(results) != 1 || results[0].ID != rag.Chunks[0].ID {
+		t.Errorf("keyword search = %+v, want %s", results, rag.Chunks[0].ID)
+	}
+
+	if err := repo.Save(loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Generation != 2 {
+		t.Errorf("generation after the second save = %d, want 2", loaded.Generation)
+	}
+}
+
+// tornSave leaves the folder of RAG "docs" as a save interrupted after
+// writing the vectors: they come from the second save, the other files from
+// the first one. It returns the folder holding the files of the second save.
+func tornSave(t *testing.T, repo *RagRepository) string {
+	t.Helper()
+	rag := newTestRag("docs", "First document.")
+	if err := repo.Save(rag); err != nil {
+		t.Fatal(err)
+	}
+	ragPath := repo.getRagPath("docs")
+	first := t.TempDir()
+	if err := copyFiles(ragPath, first, "info.json", "vectors.bin", "keywords.json", "content.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	addTestDocument(rag, "/src/other.txt", "Second document.")
+	if err := repo.Save(rag); err != nil {
+		t.Fatal(err)
+	}
+	second := t.TempDir()
+	if err := copyFiles(ragPath, second, "info.json", "vectors.bin", "keywords.json", "content.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyFiles(first, ragPath, "info.json", "keywords.json", "content.bin"); err != nil {
+		t.Fatal(err)
+	}
+	return second
+}
+
+func TestLoadRetriesWhileSaving(t *testing.T) {
+	repo := newTestRepository(t)
+	second := tornSave(t, repo)
+
+	// The save completes while Load waits
+	done := make(chan error)
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		done <- copyFiles(second, repo.getRagPath("docs"), "content.bin", "keywords.json", "info.json")
+	}()
+
+	rag, err := repo.Load("docs")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rag.Close()
+	if rag.Generation != 2 || len(rag.Documents) != 2 {
+		t.Errorf("loaded generation %d with %d documents, want 2 and 2", rag.Generation, len(rag.Documents))
+	}
+}
+
+func TestLoadInconsistent(t *testing.T) {
+	repo := newTestRepository(t)
+	tornSave(t, repo)
+
+	_, err := repo.Load("docs")
+	var inconsistent *InconsistentError
+	if !errors.As(err, &inconsistent) {
+		t.Fatalf("Load = %v, want an InconsistentError", err)
+	}
+	if inconsistent.Generation != 1 || inconsistent.VectorGeneration != 2 || inconsistent.ContentGeneration != 1 {
+		t.Errorf("unexpected generations: %+v", inconsistent)
+	}
+
+	// verify --repair can still read it
+	rag, err := repo.LoadForRepair("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rag.Close()
+	if inc := rag.Verify(); !inc.GenerationMismatch() {
+		t.Error("Verify doesn't report the interrupted save")
+	}
+}
+
+func TestLoadSavesMigration(t *testing.T) {
+	repo := newTestRepository(t)
+	rag := newTestRag("docs")
+	// Documents of previous versions were identified by their file name,
+	// which two of them share
+	for _, path := range []string{"/src/v1/README.md", "/src/v2/README.md", "/src/notes.txt"} {
+		addTestDocument(rag, path, "Text of "+path)
+	}
+	for _, doc := range rag.Documents {
+		for _, chunk := range rag.Chunks {
+			if chunk.DocumentID == doc.ID {
+				newID := filepath.Base(doc.Path) + "#0"
+				rag.VectorStore.Rename(chunk.ID, newID)
+				chunk.ID, chunk.DocumentID = newID, filepath.Base(doc.Path)
+			}
+		}
+		doc.ID = filepath.Base(doc.Path)
+	}
+	if err := rag.BuildKeywordIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Save(rag); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := repo.Load("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.Close()
+	if loaded.Migration == nil || loaded.Migration.Renamed != 3 || len(loaded.Migration.Collisions["README.md"]) != 2 {
+		t.Fatalf("unexpected migration: %+v", loaded.Migration)
+	}
+	if loaded.Generation != 2 {
+		t.Errorf("the migration was not saved: generation %d", loaded.Generation)
+	}
+
+	// The next load finds nothing to migrate
+	again, err := repo.Load("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	if again.Migration != nil || again.Generation != 2 {
+		t.Errorf("second load: migration %+v, generation %d", again.Migration, again.Generation)
+	}
+	if doc := again.GetDocumentByID("notes.txt"); doc != nil {
+		t.Error("the legacy ID was kept")
+	}
+}
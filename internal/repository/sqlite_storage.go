@@ -0,0 +1,699 @@
+package repository
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/golvellius32/rlama/internal/domain"
+	"github.com/golvellius32/rlama/pkg/bm25"
+	"github.com/golvellius32/rlama/pkg/vector"
+
+	// Pure Go SQLite driver, registered as "sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchemaVersion is the version of the tables below, kept in the meta table
+const sqliteSchemaVersion = 1
+
+// sqliteSchema creates the tables of a RAG database. The meta table holds
+// the schema version, the generation, the RAG information without its
+// documents and chunks, the keyword index and the HNSW graph. Each row
+// carries a checksum of its columns so that a save only rewrites the rows
+// that changed; the text of a document is only rewritten when it is in memory.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS meta (
+	key   TEXT PRIMARY KEY,
+	value BLOB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS documents (
+	id       TEXT PRIMARY KEY,
+	position INTEGER NOT NULL,
+	info     TEXT NOT NULL,
+	content  BLOB NOT NULL,
+	checksum INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS chunks (
+	id          TEXT PRIMARY KEY,
+	document_id TEXT NOT NULL,
+	position    INTEGER NOT NULL,
+	info        TEXT NOT NULL,
+	checksum    INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS vectors (
+	id       TEXT PRIMARY KEY,
+	vector   BLOB NOT NULL,
+	metadata TEXT NOT NULL,
+	checksum INTEGER NOT NULL
+);
+`
+
+// sqliteStorage keeps a RAG in a SQLite database, rag.db. A RAG loaded from
+// its database is updated in place in a single transaction; other RAGs are
+// written to a new database renamed over the previous one.
+type sqliteStorage struct{}
+
+// Name returns the name of the storage
+func (sqliteStorage) Name() string {
+	return StorageSQLite
+}
+
+// dbPath returns the path of the RAG database
+func (sqliteStorage) dbPath(ragPath string) string {
+	return filepath.Join(ragPath, "rag.db")
+}
+
+// Exists checks if the folder contains a RAG database
+func (s sqliteStorage) Exists(ragPath string) bool {
+	_, err := os.Stat(s.dbPath(ragPath))
+	return err == nil
+}
+
+// openSQLite opens a SQLite database, waiting for the saves of other
+// processes instead of failing
+func openSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(10000)&_pragma=synchronous(FULL)")
+	if err != nil {
+		return nil, fmt.Errorf("unable to open database %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// Save writes the RAG to its database
+func (s sqliteStorage) Save(ragPath string, rag *domain.RagSystem, generation uint64) error {
+	path := s.dbPath(ragPath)
+	store, ok := rag.ContentStore.(*sqliteContentStore)
+	if !ok || store.path != path {
+		return s.create(path, rag, generation)
+	}
+
+	if err := writeSQLite(store.db, rag, generation); err != nil {
+		return fmt.Errorf("unable to save RAG database: %w", err)
+	}
+	store.update(rag, generation)
+	return nil
+}
+
+// create writes the RAG to a new database and renames it over path
+func (s sqliteStorage) create(path string, rag *domain.RagSystem, generation uint64) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	db, err := openSQLite(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if err := writeSQLite(db, rag, generation); err != nil {
+		db.Close()
+		return fmt.Errorf("unable to save RAG database: %w", err)
+	}
+	// Queries read the database while a save writes it
+	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		db.Close()
+		return fmt.Errorf("unable to save RAG database: %w", err)
+	}
+	if err := db.Close(); err != nil {
+		return fmt.Errorf("unable to save RAG database: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	// The log of a previous database would be applied to the new one
+	os.Remove(path + "-wal")
+	os.Remove(path + "-shm")
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(path))
+
+	// Read the text that isn't in memory from the new database from now on
+	if db, err := openSQLite(path); err == nil {
+		store := &sqliteContentStore{path: path, db: db}
+		store.update(rag, generation)
+		if rag.ContentStore != nil {
+			rag.ContentStore.Close()
+		}
+		rag.ContentStore = store
+	}
+	return nil
+}
+
+// writeSQLite writes the RAG to a database in a single transaction
+func writeSQLite(db *sql.DB, rag *domain.RagSystem, generation uint64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(sqliteSchema); err != nil {
+		return err
+	}
+	if err := writeSQLiteDocuments(tx, rag); err != nil {
+		return err
+	}
+	if err := writeSQLiteChunks(tx, rag); err != nil {
+		return err
+	}
+
+	rag.VectorStore.SetIndexConfig(rag.Index)
+	rag.VectorStore.Generation = generation
+	if err := writeSQLiteVectors(tx, rag.VectorStore); err != nil {
+		return err
+	}
+
+	// Save RAG information: settings, without documents and chunks
+	ragInfo := *rag // Copy to avoid modifying the original
+	ragInfo.Documents = nil
+	ragInfo.Chunks = nil
+	ragInfo.Generation = generation
+	info, err := json.Marshal(ragInfo)
+	if err != nil {
+		return fmt.Errorf("unable to serialize RAG information: %w", err)
+	}
+
+	meta := map[string][]byte{
+		"version":    []byte(strconv.Itoa(sqliteSchemaVersion)),
+		"generation": []byte(strconv.FormatUint(generation, 10)),
+		"info":       info,
+	}
+	if rag.KeywordIndex != nil {
+		rag.KeywordIndex.Generation = generation
+		if meta["keywords"], err = rag.KeywordIndex.Marshal(); err != nil {
+			return err
+		}
+	}
+	if index := rag.VectorStore.UpdateIndex(); index != nil {
+		var graph bytes.Buffer
+		if err := index.Write(&graph); err != nil {
+			return fmt.Errorf("unable to save vector index: %w", err)
+		}
+		meta["hnsw"] = graph.Bytes()
+	}
+
+	if _, err := tx.Exec("DELETE FROM meta"); err != nil {
+		return err
+	}
+	for key, value := range meta {
+		if _, err := tx.Exec("INSERT INTO meta (key, value) VALUES (?, ?)", key, value); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// readChecksums returns the checksum of each row of a table, by ID
+func readChecksums(tx *sql.Tx, table string) (map[string]int64, error) {
+	rows, err := tx.Query("SELECT id, checksum FROM " + table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checksums := make(map[string]int64)
+	for rows.Next() {
+		var id string
+		var sum int64
+		if err := rows.Scan(&id, &sum); err != nil {
+			return nil, err
+		}
+		checksums[id] = sum
+	}
+	return checksums, rows.Err()
+}
+
+// deleteRows deletes the rows of a table whose ID is a key of ids
+func deleteRows(tx *sql.Tx, table string, ids map[string]int64) error {
+	stmt, err := tx.Prepare("DELETE FROM " + table + " WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for id := range ids {
+		if _, err := stmt.Exec(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checksum returns a checksum of the columns of a row
+func checksum(columns ...[]byte) int64 {
+	h := fnv.New64a()
+	for _, column := range columns {
+		var length [8]byte
+		binary.LittleEndian.PutUint64(length[:], uint64(len(column)))
+		h.Write(length[:])
+		h.Write(column)
+	}
+	return int64(h.Sum64())
+}
+
+// writeSQLiteDocuments writes the documents that changed and deletes the removed ones
+func writeSQLiteDocuments(tx *sql.Tx, rag *domain.RagSystem) error {
+	existing, err := readChecksums(tx, "documents")
+	if err != nil {
+		return err
+	}
+
+	insert, err := tx.Prepare(`INSERT INTO documents (id, position, info, content, checksum) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET position = excluded.position, info = excluded.info, content = excluded.content, checksum = excluded.checksum`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	update, err := tx.Prepare("UPDATE documents SET position = ?, info = ?, checksum = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer update.Close()
+
+	for i, doc := range rag.Documents {
+		info, err := json.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("unable to serialize document '%s': %w", doc.ID, err)
+		}
+		sum := checksum(strconv.AppendInt(nil, int64(i), 10), info)
+		previous, exists := existing[doc.ID]
+		delete(existing, doc.ID)
+
+		// The text stored with the document is still valid unless a new
+		// text was read
+		if exists && doc.Content == "" {
+			if previous != sum {
+				if _, err := update.Exec(i, info, sum, doc.ID); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		text, err := rag.DocumentText(doc)
+		if err != nil {
+			return err
+		}
+		if _, err := insert.Exec(doc.ID, i, info, []byte(text), sum); err != nil {
+			return err
+		}
+	}
+
+	return deleteRows(tx, "documents", existing)
+}
+
+// writeSQLiteChunks writes the chunks that changed and deletes the removed ones
+func writeSQLiteChunks(tx *sql.Tx, rag *domain.RagSystem) error {
+	existing, err := readChecksums(tx, "chunks")
+	if err != nil {
+		return err
+	}
+
+	insert, err := tx.Prepare(`INSERT INTO chunks (id, document_id, position, info, checksum) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET document_id = excluded.document_id, position = excluded.position, info = excluded.info, checksum = excluded.checksum`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	for i, chunk := range rag.Chunks {
+		info, err := json.Marshal(chunk)
+		if err != nil {
+			return fmt.Errorf("unable to serialize chunk '%s': %w", chunk.ID, err)
+		}
+		sum := checksum(strconv.AppendInt(nil, int64(i), 10), info)
+		previous, exists := existing[chunk.ID]
+		delete(existing, chunk.ID)
+		if exists && previous == sum {
+			continue
+		}
+		if _, err := insert.Exec(chunk.ID, chunk.DocumentID, i, info, sum); err != nil {
+			return err
+		}
+	}
+
+	return deleteRows(tx, "chunks", existing)
+}
+
+// writeSQLiteVectors writes the vectors that changed and deletes the removed ones
+func writeSQLiteVectors(tx *sql.Tx, store *vector.Store) error {
+	existing, err := readChecksums(tx, "vectors")
+	if err != nil {
+		return err
+	}
+
+	insert, err := tx.Prepare(`INSERT INTO vectors (id, vector, metadata, checksum) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET vector = excluded.vector, metadata = excluded.metadata, checksum = excluded.checksum`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	for _, item := range store.Items {
+		data := encodeVector(item.Vector)
+		metadata, err := json.Marshal(item.Metadata)
+		if err != nil {
+			return fmt.Errorf("unable to serialize metadata of vector '%s': %w", item.ID, err)
+		}
+		sum := checksum(data, metadata)
+		previous, exists := existing[item.ID]
+		delete(existing, item.ID)
+		if exists && previous == sum {
+			continue
+		}
+		if _, err := insert.Exec(item.ID, data, string(metadata), sum); err != nil {
+			return err
+		}
+	}
+
+	return deleteRows(tx, "vectors", existing)
+}
+
+// encodeVector returns the little-endian bytes of a vector
+func encodeVector(v []float32) []byte {
+	data := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(x))
+	}
+	return data
+}
+
+// decodeVector reads a vector encoded by encodeVector
+func decodeVector(data []byte) ([]float32, error) {
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("invalid vector of %d bytes", len(data))
+	}
+	v := make([]float32, len(data)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return v, nil
+}
+
+// readMeta reads the meta table and checks the schema version
+func readMeta(tx *sql.Tx) (map[string][]byte, uint64, error) {
+	rows, err := tx.Query("SELECT key, value FROM meta")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	meta := make(map[string][]byte)
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, 0, err
+		}
+		meta[key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	version, err := strconv.Atoi(string(meta["version"]))
+	if err != nil || version > sqliteSchemaVersion {
+		return nil, 0, fmt.Errorf("unsupported database version '%s'", meta["version"])
+	}
+	generation, err := strconv.ParseUint(string(meta["generation"]), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid generation '%s'", meta["generation"])
+	}
+	return meta, generation, nil
+}
+
+// readDocuments reads the documents of a RAG, without their text
+func readDocuments(tx *sql.Tx) ([]*domain.Document, error) {
+	rows, err := tx.Query("SELECT info FROM documents ORDER BY position")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []*domain.Document
+	for rows.Next() {
+		var info []byte
+		if err := rows.Scan(&info); err != nil {
+			return nil, err
+		}
+		doc := &domain.Document{}
+		if err := json.Unmarshal(info, doc); err != nil {
+			return nil, fmt.Errorf("unable to deserialize document: %w", err)
+		}
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// readChunks reads the chunks of a RAG, without their text
+func readChunks(tx *sql.Tx) ([]*domain.DocumentChunk, error) {
+	rows, err := tx.Query("SELECT info FROM chunks ORDER BY position")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []*domain.DocumentChunk
+	for rows.Next() {
+		var info []byte
+		if err := rows.Scan(&info); err != nil {
+			return nil, err
+		}
+		chunk := &domain.DocumentChunk{}
+		if err := json.Unmarshal(info, chunk); err != nil {
+			return nil, fmt.Errorf("unable to deserialize chunk: %w", err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, rows.Err()
+}
+
+// readVectors reads the vectors of a RAG
+func readVectors(tx *sql.Tx) ([]vector.VectorItem, error) {
+	rows, err := tx.Query("SELECT id, vector, metadata FROM vectors ORDER BY rowid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []vector.VectorItem{}
+	for rows.Next() {
+		var id string
+		var data, metadata []byte
+		if err := rows.Scan(&id, &data, &metadata); err != nil {
+			return nil, err
+		}
+		item := vector.VectorItem{ID: id}
+		if item.Vector, err = decodeVector(data); err != nil {
+			return nil, fmt.Errorf("vector '%s': %w", id, err)
+		}
+		if err := json.Unmarshal(metadata, &item.Metadata); err != nil {
+			return nil, fmt.Errorf("unable to deserialize metadata of vector '%s': %w", id, err)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// Load reads the RAG from its database. Everything is read in one
+// transaction, so a save of another process is either seen entirely or not at all.
+func (s sqliteStorage) Load(ragPath string) (*domain.RagSystem, error) {
+	path := s.dbPath(ragPath)
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rag, err := readSQLite(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to load RAG database: %w", err)
+	}
+
+	store := &sqliteContentStore{path: path, db: db}
+	store.update(rag, rag.Generation)
+	rag.ContentStore = store
+
+	// The keyword index can be rebuilt from the chunks
+	if rag.KeywordIndex == nil {
+		if err := rag.BuildKeywordIndex(); err != nil {
+			rag.Close()
+			return nil, fmt.Errorf("unable to load keyword index: %w", err)
+		}
+	}
+	return rag, nil
+}
+
+// readSQLite reads a RAG, without the text of its documents
+func readSQLite(db *sql.DB) (*domain.RagSystem, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	meta, generation, err := readMeta(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rag domain.RagSystem
+	if err := json.Unmarshal(meta["info"], &rag); err != nil {
+		return nil, fmt.Errorf("unable to deserialize RAG information: %w", err)
+	}
+	rag.Generation = generation
+
+	if rag.Documents, err = readDocuments(tx); err != nil {
+		return nil, err
+	}
+	if rag.Chunks, err = readChunks(tx); err != nil {
+		return nil, err
+	}
+
+	items, err := readVectors(tx)
+	if err != nil {
+		return nil, err
+	}
+	rag.VectorStore = vector.NewStore()
+	rag.VectorStore.Items = items
+	rag.VectorStore.Generation = generation
+	rag.VectorStore.SetIndexConfig(rag.Index)
+	// A graph that doesn't match the vectors is ignored: searches fall back
+	// to brute force until the next save
+	if graph, ok := meta["hnsw"]; ok && len(items) >= vector.MinIndexSize {
+		if index, err := vector.ReadHNSWIndex(bytes.NewReader(graph), items); err == nil {
+			rag.VectorStore.SetIndex(index)
+		}
+	}
+
+	if data, ok := meta["keywords"]; ok {
+		if rag.KeywordIndex, err = bm25.Unmarshal(data); err != nil {
+			return nil, fmt.Errorf("invalid keyword index: %w", err)
+		}
+	}
+
+	return &rag, tx.Commit()
+}
+
+// Summarize reads the settings and documents of the RAG from its database
+func (s sqliteStorage) Summarize(ragPath string) (RagSummary, error) {
+	db, err := openSQLite(s.dbPath(ragPath))
+	if err != nil {
+		return RagSummary{}, err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return RagSummary{}, fmt.Errorf("unable to read RAG database: %w", err)
+	}
+	defer tx.Rollback()
+
+	meta, _, err := readMeta(tx)
+	if err != nil {
+		return RagSummary{}, fmt.Errorf("unable to read RAG database: %w", err)
+	}
+	var info summaryInfo
+	if err := json.Unmarshal(meta["info"], &info); err != nil {
+		return RagSummary{}, fmt.Errorf("unable to deserialize RAG information: %w", err)
+	}
+	if info.Documents, err = readDocuments(tx); err != nil {
+		return RagSummary{}, fmt.Errorf("unable to read RAG database: %w", err)
+	}
+	var chunks int
+	if err := tx.QueryRow("SELECT count(*) FROM chunks").Scan(&chunks); err != nil {
+		return RagSummary{}, fmt.Errorf("unable to read RAG database: %w", err)
+	}
+	return info.summary(chunks), nil
+}
+
+// Remove deletes the database of the RAG
+func (s sqliteStorage) Remove(ragPath string) error {
+	path := s.dbPath(ragPath)
+	for _, file := range []string{path, path + "-wal", path + "-shm"} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqliteContentStore reads the texts of documents from a RAG database
+type sqliteContentStore struct {
+	path       string
+	db         *sql.DB
+	generation uint64
+	ids        map[string]bool
+}
+
+// update records the documents and the generation written by a save
+func (s *sqliteContentStore) update(rag *domain.RagSystem, generation uint64) {
+	s.generation = generation
+	s.ids = make(map[string]bool, len(rag.Documents))
+	for _, doc := range rag.Documents {
+		s.ids[doc.ID] = true
+	}
+}
+
+// HasContent reports whether the database contains the text of a document
+func (s *sqliteContentStore) HasContent(id string) bool {
+	return s.ids[id]
+}
+
+// ReadContent returns the bytes start to end of the text of a document, up
+// to the end of the text if end is negative. It fails if another process
+// saved the RAG since it was loaded.
+func (s *sqliteContentStore) ReadContent(id string, start, end int) (string, error) {
+	if !s.ids[id] {
+		return "", fmt.Errorf("no content for document '%s'", id)
+	}
+	length := math.MaxInt32
+	if end >= 0 {
+		length = end - start
+	}
+	if start < 0 || length < 0 {
+		return "", fmt.Errorf("invalid range %d-%d in the content of document '%s'", start, end, id)
+	}
+
+	var text []byte
+	var size int
+	var generation string
+	err := s.db.QueryRow(`SELECT substr(d.content, ?, ?), length(d.content), m.value
+		FROM documents d, meta m WHERE d.id = ? AND m.key = 'generation'`,
+		start+1, length, id).Scan(&text, &size, &generation)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("no content for document '%s'", id)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to read the content of document '%s': %w", id, err)
+	}
+	if generation != strconv.FormatUint(s.generation, 10) {
+		return "", fmt.Errorf("unable to read the content of document '%s': the RAG was saved by another process since it was loaded", id)
+	}
+	if start > size {
+		return "", fmt.Errorf("invalid range %d-%d in the content of document '%s'", start, end, id)
+	}
+	return string(text), nil
+}
+
+// Generation returns the generation of the save that wrote the texts
+func (s *sqliteContentStore) Generation() uint64 {
+	return s.generation
+}
+
+// Close closes the database
+func (s *sqliteContentStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,312 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golvellius32/rlama/internal/domain"
+)
+
+// newTestSQLiteRepository returns a repository creating new RAGs in SQLite
+func newTestSQLiteRepository(t *testing.T) *RagRepository {
+	t.Helper()
+	repo := newTestRepository(t)
+	repo.storage = sqliteStorage{}
+	return repo
+}
+
+// readRowChecksums returns the checksum of each row of a table of a RAG database
+func readRowChecksums(t *testing.T, repo *RagRepository, ragName, table string) map[string]int64 {
+	t.Helper()
+	db, err := openSQLite(sqliteStorage{}.dbPath(repo.getRagPath(ragName)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	checksums, err := readChecksums(tx, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return checksums
+}
+
+// readStoredContent returns the text stored with a document in a RAG database
+func readStoredContent(t *testing.T, repo *RagRepository, ragName, id string) string {
+	t.Helper()
+	db, err := openSQLite(sqliteStorage{}.dbPath(repo.getRagPath(ragName)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var content []byte
+	if err := db.QueryRow("SELECT content FROM documents WHERE id = ?", id).Scan(&content); err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// checkTexts checks that the chunks of rag read the given texts, in order
+func checkTexts(t *testing.T, rag *domain.RagSystem, texts ...string) {
+	t.Helper()
+	if len(rag.Chunks) != len(texts) {
+		t.Fatalf("%d chunks, want %d", len(rag.Chunks), len(texts))
+	}
+	for i, chunk := range rag.Chunks {
+		text, err := rag.ChunkText(chunk)
+		if err != nil {
+			t.Errorf("chunk %s: %v", chunk.ID, err)
+			continue
+		}
+		if text != texts[i] {
+			t.Errorf("chunk %s: text %q, want %q", chunk.ID, text, texts[i])
+		}
+	}
+}
+
+func TestSQLiteSaveLoad(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	texts := []string{"Error E1234 means the disk is full.", "Run make install to install the tool.", ""}
+	rag := newTestRag("docs", texts...)
+	if err := repo.Save(rag); err != nil {
+		t.Fatal(err)
+	}
+	if storage := repo.StorageOf("docs"); storage == nil || storage.Name() != StorageSQLite {
+		t.Fatalf("StorageOf = %v, want sqlite", storage)
+	}
+	if _, err := os.Stat(filepath.Join(repo.getRagPath("docs"), "info.json")); !os.IsNotExist(err) {
+		t.Errorf("a SQLite RAG wrote info.json: %v", err)
+	}
+
+	loaded, err := repo.Load("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+	if loaded.Generation != 1 || loaded.ContentStore.Generation() != 1 {
+		t.Errorf("generation %d, content generation %d, want 1", loaded.Generation, loaded.ContentStore.Generation())
+	}
+	if loaded.Migration != nil {
+		t.Errorf("a RAG with current IDs was migrated: %+v", loaded.Migration)
+	}
+	if len(loaded.Documents) != 3 || loaded.Documents[0].Content != "" {
+		t.Fatalf("loaded %d documents, first text %q", len(loaded.Documents), loaded.Documents[0].Content)
+	}
+	for i, doc := range loaded.Documents {
+		if doc.ID != rag.Documents[i].ID || doc.Path != rag.Documents[i].Path {
+			t.Errorf("document %d: %s %s, want %s %s", i, doc.ID, doc.Path, rag.Documents[i].ID, rag.Documents[i].Path)
+		}
+	}
+	checkTexts(t, loaded, texts...)
+	if !reflect.DeepEqual(loaded.VectorStore.Items, rag.VectorStore.Items) {
+		t.Errorf("vectors %+v, want %+v", loaded.VectorStore.Items, rag.VectorStore.Items)
+	}
+	if results := loaded.KeywordIndex.Search("E1234", 0); len(results) != 1 || results[0].ID != rag.Chunks[0].ID {
+		t.Errorf("keyword search = %+v, want %s", results, rag.Chunks[0].ID)
+	}
+
+	summaries, err := repo.ListAll()
+	if err != nil || len(summaries) != 1 || summaries[0].Err != nil || len(summaries[0].Documents) != 3 {
+		t.Errorf("ListAll = %+v, %v", summaries, err)
+	}
+}
+
+func TestSQLiteIncrementalSave(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	if err := repo.Save(newTestRag("docs", "First document.", "Second document.", "Third document.")); err != nil {
+		t.Fatal(err)
+	}
+	before := map[string]map[string]int64{}
+	for _, table := range []string{"documents", "chunks", "vectors"} {
+		before[table] = readRowChecksums(t, repo, "docs", table)
+	}
+
+	rag, err := repo.Load("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rag.Close()
+	first, second, removed := rag.Documents[0], rag.Documents[1], rag.Documents[2]
+	rag.VectorStore.Items[1].Vector = []float32{9, 9, 9}
+	rag.RemoveDocument(removed.ID)
+	added := addTestDocument(rag, "/src/new.txt", "New document.")
+	if err := repo.Save(rag); err != nil {
+		t.Fatal(err)
+	}
+	if rag.ContentStore.Generation() != 2 {
+		t.Errorf("content generation after the save = %d, want 2", rag.ContentStore.Generation())
+	}
+
+	// The vector of the second chunk changed, not its document: only the rows
+	// of the removed and added documents and of that vector were written
+	after := readRowChecksums(t, repo, "docs", "documents")
+	if after[first.ID] != before["documents"][first.ID] || after[second.ID] != before["documents"][second.ID] {
+		t.Error("documents that didn't change were rewritten")
+	}
+	if _, ok := after[removed.ID]; ok {
+		t.Error("the removed document is still stored")
+	}
+	if _, ok := after[added.ID]; !ok {
+		t.Error("the added document was not stored")
+	}
+
+	vectors := readRowChecksums(t, repo, "docs", "vectors")
+	unchangedChunk, changedChunk := rag.Chunks[0].ID, rag.Chunks[1].ID
+	if vectors[unchangedChunk] != before["vectors"][unchangedChunk] {
+		t.Error("a vector that didn't change was rewritten")
+	}
+	if vectors[changedChunk] == before["vectors"][changedChunk] {
+		t.Error("the changed vector was not rewritten")
+	}
+	if len(vectors) != 3 || len(readRowChecksums(t, repo, "docs", "chunks")) != 3 {
+		t.Errorf("%d vectors stored, want 3", len(vectors))
+	}
+
+	// The texts are still read through the same database
+	checkTexts(t, rag, "First document.", "Second document.", "New document.")
+	loaded, err := repo.Load("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+	checkTexts(t, loaded, "First document.", "Second document.", "New document.")
+	if !reflect.DeepEqual(loaded.VectorStore.Items, rag.VectorStore.Items) {
+		t.Errorf("vectors %+v, want %+v", loaded.VectorStore.Items, rag.VectorStore.Items)
+	}
+}
+
+func TestSQLiteKeepsStoredText(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	if err := repo.Save(newTestRag("docs", "Error E1234 means the disk is full.")); err != nil {
+		t.Fatal(err)
+	}
+
+	rag, err := repo.Load("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rag.Close()
+	doc := rag.Documents[0]
+	if doc.Content != "" {
+		t.Fatal("the text was read when loading the RAG")
+	}
+	// A change of the information of the document rewrites its row without
+	// its text
+	doc.Metadata = map[string]string{"author": "me"}
+	if err := repo.Save(rag); err != nil {
+		t.Fatal(err)
+	}
+	if text := readStoredContent(t, repo, "docs", doc.ID); text != "Error E1234 means the disk is full." {
+		t.Errorf("stored text after a save without it = %q", text)
+	}
+
+	loaded, err := repo.Load("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+	if loaded.Documents[0].Metadata["author"] != "me" {
+		t.Errorf("metadata %v, want the author", loaded.Documents[0].Metadata)
+	}
+	checkTexts(t, loaded, "Error E1234 means the disk is full.")
+}
+
+func TestSQLiteContentAfterNewerSave(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	if err := repo.Save(newTestRag("docs", "Original text of the document.")); err != nil {
+		t.Fatal(err)
+	}
+	old, err := repo.Load("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+
+	other := NewRagRepository()
+	newer, err := other.Load("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestDocument(newer, "/src/other.txt", "Another document.")
+	if err := other.Save(newer); err != nil {
+		t.Fatal(err)
+	}
+	newer.Close()
+
+	// The database now holds the texts of another save
+	if _, err := old.ChunkText(old.Chunks[0]); err == nil || !strings.Contains(err.Error(), "saved by another process") {
+		t.Errorf("ChunkText after a newer save = %v, want an error", err)
+	}
+
+	reloaded, err := repo.Load("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+	checkTexts(t, reloaded, "Original text of the document.", "Another document.")
+}
+
+func TestConvert(t *testing.T) {
+	repo := newTestRepository(t)
+	texts := []string{"Error E1234 means the disk is full.", "Run make install to install the tool."}
+	rag := newTestRag("docs", texts...)
+	if err := repo.Save(rag); err != nil {
+		t.Fatal(err)
+	}
+	ragPath := repo.getRagPath("docs")
+
+	for i, to := range []Storage{sqliteStorage{}, jsonStorage{}} {
+		if err := repo.Convert("docs", to); err != nil {
+			t.Fatalf("Convert to %s: %v", to.Name(), err)
+		}
+		if storage := repo.StorageOf("docs"); storage == nil || storage.Name() != to.Name() {
+			t.Fatalf("after converting to %s: StorageOf = %v", to.Name(), storage)
+		}
+
+		entries, err := os.ReadDir(ragPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var files []string
+		for _, entry := range entries {
+			if entry.Name() != ".lock" {
+				files = append(files, entry.Name())
+			}
+		}
+		want := []string{"rag.db"}
+		if to.Name() == StorageJSON {
+			want = []string{"content.bin", "info.json", "keywords.json", "vectors.bin"}
+		}
+		if !reflect.DeepEqual(files, want) {
+			t.Errorf("files after converting to %s: %q, want %q", to.Name(), files, want)
+		}
+
+		loaded, err := repo.Load("docs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.Generation != uint64(i+2) {
+			t.Errorf("generation after converting to %s = %d, want %d", to.Name(), loaded.Generation, i+2)
+		}
+		checkTexts(t, loaded, texts...)
+		if !reflect.DeepEqual(loaded.VectorStore.Items, rag.VectorStore.Items) {
+			t.Errorf("vectors after converting to %s: %+v, want %+v", to.Name(), loaded.VectorStore.Items, rag.VectorStore.Items)
+		}
+		loaded.Close()
+	}
+
+	// Converting to the current storage does nothing
+	if err := repo.Convert("docs", jsonStorage{}); err != nil {
+		t.Error(err)
+	}
+	if err := repo.Convert("missing", sqliteStorage{}); err == nil {
+		t.Error("converting a missing RAG succeeded")
+	}
+}
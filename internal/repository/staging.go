@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/golvellius32/rlama/internal/domain"
+)
+
+// A RAG being created is indexed into a staging folder inside its own folder.
+// The staging folder holds the settings of the RAG and the embeddings computed
+// so far, so that an interrupted indexing can be resumed. The RAG only becomes
+// visible once its info.json is written, after all chunks are embedded.
+
+// StagedEmbedding is the embedding of a chunk computed by an indexing that
+// hasn't completed yet
+type StagedEmbedding struct {
+	ID string `json:"id"`
+	// Hash is the SHA-256 of the chunk content, so that chunks of files
+	// modified since the interruption are embedded again
+	Hash      string    `json:"hash"`
+	Embedding []float32 `json:"embedding"`
+}
+
+// getRagStagingPath returns the path of the staging folder of a RAG
+func (r *RagRepository) getRagStagingPath(ragName string) string {
+	return filepath.Join(r.getRagPath(ragName), "staging")
+}
+
+// getStagingInfoPath returns the path of the settings of a staged RAG
+func (r *RagRepository) getStagingInfoPath(ragName string) string {
+	return filepath.Join(r.getRagStagingPath(ragName), "info.json")
+}
+
+// getStagingEmbeddingsPath returns the path of the staged embeddings, one
+// JSON object per line
+func (r *RagRepository) getStagingEmbeddingsPath(ragName string) string {
+	return filepath.Join(r.getRagStagingPath(ragName), "embeddings.jsonl")
+}
+
+// StagingExists checks if a RAG has an indexing in progress or interrupted
+func (r *RagRepository) StagingExists(ragName string) bool {
+	_, err := os.Stat(r.getStagingInfoPath(ragName))
+	return err == nil
+}
+
+// SaveStaging starts the staging of a RAG by recording its settings
+func (r *RagRepository) SaveStaging(rag *domain.RagSystem) error {
+	if err := os.MkdirAll(r.getRagStagingPath(rag.Name), 0755); err != nil {
+		return fmt.Errorf("unable to create staging folder for RAG: %w", err)
+	}
+
+	infoJSON, err := json.MarshalIndent(rag, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to serialize RAG information: %w", err)
+	}
+	if err := writeFileAtomic(r.getStagingInfoPath(rag.Name), infoJSON, 0644); err != nil {
+		return fmt.Errorf("unable to save RAG information: %w", err)
+	}
+	return nil
+}
+
+// LoadStaging returns the settings of a staged RAG
+func (r *RagRepository) LoadStaging(ragName string) (*domain.RagSystem, error) {
+	infoBytes, err := os.ReadFile(r.getStagingInfoPath(ragName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("RAG '%s' has no interrupted indexing to resume", ragName)
+		}
+		return nil, fmt.Errorf("unable to read staged RAG information: %w", err)
+	}
+
+	rag := domain.NewRagSystem(ragName, "", "")
+	if err := json.Unmarshal(infoBytes, rag); err != nil {
+		return nil, fmt.Errorf("unable to deserialize staged RAG information: %w", err)
+	}
+	return rag, nil
+}
+
+// AppendStagedEmbeddings records the embeddings of chunks and flushes them
+// to disk, so that they survive a crash
+func (r *RagRepository) AppendStagedEmbeddings(ragName string, chunks []*domain.DocumentChunk) error {
+	f, err := os.OpenFile(r.getStagingEmbeddingsPath(ragName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open staged embeddings: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, chunk := range chunks {
+		staged := StagedEmbedding{
+			ID:        chunk.ID,
+			Hash:      ContentHash(chunk.Content),
+			Embedding: chunk.Embedding,
+		}
+		if err := encoder.Encode(staged); err != nil {
+			return fmt.Errorf("unable to save staged embeddings: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("unable to save staged embeddings: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("unable to save staged embeddings: %w", err)
+	}
+	return nil
+}
+
+// LoadStagedEmbeddings returns the embeddings staged so far, by chunk ID.
+// A truncated last line, left by a crash while writing, is ignored.
+func (r *RagRepository) LoadStagedEmbeddings(ragName string) (map[string]StagedEmbedding, error) {
+	staged := make(map[string]StagedEmbedding)
+
+	f, err := os.Open(r.getStagingEmbeddingsPath(ragName))
+	if os.IsNotExist(err) {
+		return staged, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open staged embeddings: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var embedding StagedEmbedding
+		if err := json.Unmarshal(scanner.Bytes(), &embedding); err != nil {
+			continue
+		}
+		staged[embedding.ID] = embedding
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read staged embeddings: %w", err)
+	}
+	return staged, nil
+}
+
+// RemoveStaging deletes the staging folder of a RAG
+func (r *RagRepository) RemoveStaging(ragName string) error {
+	if err := os.RemoveAll(r.getRagStagingPath(ragName)); err != nil {
+		return fmt.Errorf("unable to remove staging folder: %w", err)
+	}
+	return nil
+}
+
+// ContentHash returns the hex-encoded SHA-256 of a text
+func ContentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golvellius32/rlama/internal/domain"
+)
+
+// Storage reads and writes the data of RAG systems in one format, inside the
+// folder of each RAG. The lock, the prompt template and the staging folder
+// of a RAG stay in its folder whatever its storage.
+type Storage interface {
+	// Name identifies the storage in the configuration and in 'rlama migrate'
+	Name() string
+	// Exists reports whether a RAG folder holds data in this format
+	Exists(ragPath string) bool
+	// Save writes a RAG whose files carry generation. The RAG is either
+	// saved completely or left as it was.
+	Save(ragPath string, rag *domain.RagSystem, generation uint64) error
+	// Load reads a RAG without checking that its parts come from the same save
+	Load(ragPath string) (*domain.RagSystem, error)
+	// Summarize reads the settings and documents of a RAG, without its
+	// vectors or the text of its documents
+	Summarize(ragPath string) (RagSummary, error)
+	// Remove deletes the data of a RAG in this format, once converted to another
+	Remove(ragPath string) error
+}
+
+// Storage names
+const (
+	StorageJSON   = "json"
+	StorageSQLite = "sqlite"
+)
+
+// storages lists the supported storages. A folder holding data in several
+// formats after an interrupted conversion is read with the first one: a
+// conversion removes the data of the previous storage only once the new one
+// is complete.
+var storages = []Storage{jsonStorage{}, sqliteStorage{}}
+
+// StorageNames returns the names of the supported storages
+func StorageNames() []string {
+	names := make([]string, len(storages))
+	for i, storage := range storages {
+		names[i] = storage.Name()
+	}
+	return names
+}
+
+// StorageByName returns the storage with the given name; an empty name
+// gives the default JSON storage
+func StorageByName(name string) (Storage, error) {
+	if name == "" {
+		name = StorageJSON
+	}
+	for _, storage := range storages {
+		if storage.Name() == name {
+			return storage, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown storage '%s' (supported: %s)", name, strings.Join(StorageNames(), ", "))
+}
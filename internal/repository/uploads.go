@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Files uploaded through the API are first written to a pending upload
+// folder, then moved into the folder of the RAG they are indexed into, so
+// that update-rag and resuming an interrupted indexing can read them again.
+// They are deleted with the RAG. Pending uploads that are never given to a
+// RAG are removed after pendingUploadTTL.
+
+// pendingUploadTTL is how long an upload not given to a RAG is kept
+const pendingUploadTTL = 24 * time.Hour
+
+// UploadsPath returns the folder of the files uploaded into a RAG
+func (r *RagRepository) UploadsPath(ragName string) string {
+	return filepath.Join(r.getRagPath(ragName), "uploads")
+}
+
+// PendingUploadsPath returns the folder of the uploads not yet given to a RAG
+func (r *RagRepository) PendingUploadsPath() string {
+	return filepath.Join(r.basePath, ".uploads")
+}
+
+// NewPendingUpload creates a folder for uploaded files, after removing the
+// pending uploads that expired
+func (r *RagRepository) NewPendingUpload() (string, error) {
+	dir := r.PendingUploadsPath()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("unable to create upload folder: %w", err)
+	}
+
+	if entries, err := os.ReadDir(dir); err == nil {
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err == nil && time.Since(info.ModTime()) > pendingUploadTTL {
+				os.RemoveAll(filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+
+	folder, err := os.MkdirTemp(dir, "upload-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create upload folder: %w", err)
+	}
+	return folder, nil
+}
+
+// IsPendingUpload reports whether path is a folder created by NewPendingUpload
+func (r *RagRepository) IsPendingUpload(path string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil || filepath.Dir(path) != r.PendingUploadsPath() {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// AdoptUpload moves a pending upload into the folder of a RAG and returns
+// its new path. The upload becomes the uploads folder of a RAG being
+// created, or a folder inside the uploads folder of an existing RAG. The
+// caller must hold the lock of the RAG.
+func (r *RagRepository) AdoptUpload(ragName, upload string) (string, error) {
+	uploads := r.UploadsPath(ragName)
+	target := filepath.Join(uploads, filepath.Base(upload))
+	if !r.Exists(ragName) {
+		// Replace what a creation that crashed may have left
+		if err := os.RemoveAll(uploads); err != nil {
+			return "", fmt.Errorf("unable to clear uploads of RAG '%s': %w", ragName, err)
+		}
+		target = uploads
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", fmt.Errorf("unable to create uploads folder of RAG '%s': %w", ragName, err)
+	}
+	if err := os.Rename(upload, target); err != nil {
+		return "", fmt.Errorf("unable to move uploaded files into RAG '%s': %w", ragName, err)
+	}
+	return target, nil
+}
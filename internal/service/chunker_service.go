@@ -0,0 +1,207 @@
+package service
+
+import (
+	"regexp"
+
+	"github.com/golvellius32/rlama/internal/domain"
+)
+
+var (
+	tokenPattern     = regexp.MustCompile(`\S+`)
+	sentencePattern  = regexp.MustCompile(`[^.!?\n]+(?:[.!?]+|\n|$)`)
+	paragraphPattern = regexp.MustCompile(`\n[ \t]*\n`)
+	headingPattern   = regexp.MustCompile(`(?m)^#{1,6}[ \t]`)
+)
+
+// span is a byte range of a document's content
+type span struct {
+	start int
+	end   int
+}
+
+// ChunkerService splits documents into overlapping chunks before embedding
+type ChunkerService struct {
+	config domain.ChunkingConfig
+}
+
+// NewChunkerService creates a new instance of ChunkerService
+func NewChunkerService(config domain.ChunkingConfig) *ChunkerService {
+	return &ChunkerService{
+		config: config,
+	}
+}
+
+// ChunkDocuments splits every document and returns all resulting chunks
+func (cs *ChunkerService) ChunkDocuments(docs []*domain.Document) []*domain.DocumentChunk {
+	var chunks []*domain.DocumentChunk
+	for _, doc := range docs {
+		chunks = append(chunks, cs.ChunkDocument(doc)...)
+	}
+	return chunks
+}
+
+// ChunkDocument splits a document according to the configured strategy
+func (cs *ChunkerService) ChunkDocument(doc *domain.Document) []*domain.DocumentChunk {
+	var spans []span
+	switch cs.config.Strategy {
+	case domain.ChunkStrategySentence:
+		spans = cs.pack(doc.Content, matchSpans(doc.Content, sentencePattern))
+	case domain.ChunkStrategyParagraph:
+		spans = cs.pack(doc.Content, splitSpans(doc.Content, paragraphPattern, false))
+	case domain.ChunkStrategyMarkdown:
+		spans = cs.pack(doc.Content, splitSpans(doc.Content, headingPattern, true))
+	default:
+		spans = cs.fixed(doc.Content, span{0, len(doc.Content)})
+	}
+
+	chunks := make([]*domain.DocumentChunk, 0, len(spans))
+	for _, s := range spans {
+		s = trimSpan(doc.Content, s)
+		if s.start >= s.end {
+			continue
+		}
+		chunks = append(chunks, domain.NewDocumentChunk(doc, len(chunks), s.start, s.end))
+	}
+	return chunks
+}
+
+// fixed splits a span into windows of ChunkSize tokens with ChunkOverlap tokens of overlap
+func (cs *ChunkerService) fixed(content string, s span) []span {
+	tokens := tokenPattern.FindAllStringIndex(content[s.start:s.end], -1)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	step := cs.config.ChunkSize - cs.config.ChunkOverlap
+	if step <= 0 {
+		step = cs.config.ChunkSize
+	}
+
+	var spans []span
+	for i := 0; i < len(tokens); i += step {
+		last := i + cs.config.ChunkSize
+		if last > len(tokens) {
+			last = len(tokens)
+		}
+		spans = append(spans, span{s.start + tokens[i][0], s.start + tokens[last-1][1]})
+		if last == len(tokens) {
+			break
+		}
+	}
+	return spans
+}
+
+// pack groups consecutive segments into chunks of at most ChunkSize tokens.
+// The trailing segments of a chunk are repeated at the start of the next one
+// as long as they fit in ChunkOverlap tokens. Segments that are too large on
+// their own are split with the fixed strategy.
+func (cs *ChunkerService) pack(content string, segments []span) []span {
+	var spans []span
+	var current []span
+	currentTokens := 0
+
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		spans = append(spans, span{current[0].start, current[len(current)-1].end})
+
+		// Keep the tail of the chunk as overlap for the next one
+		var overlap []span
+		overlapTokens := 0
+		for i := len(current) - 1; i >= 0; i-- {
+			n := countTokens(content[current[i].start:current[i].end])
+			if overlapTokens+n > cs.config.ChunkOverlap {
+				break
+			}
+			overlap = append([]span{current[i]}, overlap...)
+			overlapTokens += n
+		}
+		current = overlap
+		currentTokens = overlapTokens
+	}
+
+	for _, seg := range segments {
+		n := countTokens(content[seg.start:seg.end])
+		if n == 0 {
+			continue
+		}
+
+		if n > cs.config.ChunkSize {
+			flush()
+			current, currentTokens = nil, 0
+			spans = append(spans, cs.fixed(content, seg)...)
+			continue
+		}
+
+		if currentTokens+n > cs.config.ChunkSize {
+			flush()
+			// The overlap alone may still leave no room for this segment
+			if currentTokens+n > cs.config.ChunkSize {
+				current, currentTokens = nil, 0
+			}
+		}
+
+		current = append(current, seg)
+		currentTokens += n
+	}
+
+	// Only emit the remainder if it holds more than the previous overlap
+	if len(current) > 0 && (len(spans) == 0 || current[len(current)-1].end > spans[len(spans)-1].end) {
+		spans = append(spans, span{current[0].start, current[len(current)-1].end})
+	}
+
+	return spans
+}
+
+// trimSpan shrinks a span so that it doesn't start or end with whitespace
+func trimSpan(content string, s span) span {
+	for s.start < s.end && isSpace(content[s.start]) {
+		s.start++
+	}
+	for s.end > s.start && isSpace(content[s.end-1]) {
+		s.end--
+	}
+	return s
+}
+
+// isSpace reports whether b is an ASCII whitespace character
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// countTokens approximates the number of tokens in a text by counting words
+func countTokens(text string) int {
+	return len(tokenPattern.FindAllStringIndex(text, -1))
+}
+
+// matchSpans returns the spans of all matches of pattern in content
+func matchSpans(content string, pattern *regexp.Regexp) []span {
+	var spans []span
+	for _, loc := range pattern.FindAllStringIndex(content, -1) {
+		spans = append(spans, span{loc[0], loc[1]})
+	}
+	return spans
+}
+
+// splitSpans splits content around the matches of pattern. When keepSeparator
+// is true, each match starts a new span instead of being dropped.
+func splitSpans(content string, pattern *regexp.Regexp, keepSeparator bool) []span {
+	var spans []span
+	start := 0
+	for _, loc := range pattern.FindAllStringIndex(content, -1) {
+		end := loc[0]
+		if end > start {
+			spans = append(spans, span{start, end})
+		}
+		if keepSeparator {
+			start = loc[0]
+		} else {
+			start = loc[1]
+		}
+	}
+	if start < len(content) {
+		spans = append(spans, span{start, len(content)})
+	}
+	return spans
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golvellius32/rlama/internal/domain"
+)
+
+// memoryContentStore serves the text of documents from memory
+type memoryContentStore map[string]string
+
+func (m memoryContentStore) HasContent(id string) bool {
+	_, ok := m[id]
+	return ok
+}
+
+func (m memoryContentStore) ReadContent(id string, start, end int) (string, error) {
+	text, ok := m[id]
+	if !ok {
+		return "", fmt.Errorf("no content for %s", id)
+	}
+	if end < 0 {
+		end = len(text)
+	}
+	if start < 0 || start > end || end > len(text) {
+		return "", fmt.Errorf("range %d-%d out of bounds", start, end)
+	}
+	return text[start:end], nil
+}
+
+func (m memoryContentStore) Generation() uint64 { return 0 }
+
+func (m memoryContentStore) Close() error { return nil }
+
+func TestChunkDocument(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		size     int
+		overlap  int
+		content  string
+		want     []string
+	}{
+		{
+			name:     "fixed with overlap",
+			strategy: domain.ChunkStrategyFixed,
+			size:     3, overlap: 1,
+			content: "a b c d e f g",
+			want:    []string{"a b c", "c d e", "e f g"},
+		},
+		{
+			name:     "fixed without overlap",
+			strategy: domain.ChunkStrategyFixed,
+			size:     3, overlap: 0,
+			content: "a b c d e f g",
+			want:    []string{"a b c", "d e f", "g"},
+		},
+		{
+			name:     "fixed keeps the original spacing",
+			strategy: domain.ChunkStrategyFixed,
+			size:     2, overlap: 0,
+			content: "  a\n\tb   c  ",
+			want:    []string{"a\n\tb", "c"},
+		},
+		{
+			name:     "sentences without overlap",
+			strategy: domain.ChunkStrategySentence,
+			size:     5, overlap: 0,
+			content: "One two. Three four five. Six.",
+			want:    []string{"One two. Three four five.", "Six."},
+		},
+		{
+			name:     "sentences with overlap",
+			strategy: domain.ChunkStrategySentence,
+			size:     5, overlap: 3,
+			content: "One two. Three four five. Six.",
+			want:    []string{"One two. Three four five.", "Three four five. Six."},
+		},
+		{
+			name:     "sentence larger than a chunk",
+			strategy: domain.ChunkStrategySentence,
+			size:     2, overlap: 0,
+			content: "Short. This one is too long.",
+			want:    []string{"Short.", "This one", "is too", "long."},
+		},
+		{
+			name:     "paragraphs",
+			strategy: domain.ChunkStrategyParagraph,
+			size:     3, overlap: 0,
+			content: "P1 a b\n\nP2 c d\n  \nP3 e",
+			want:    []string{"P1 a b", "P2 c d", "P3 e"},
+		},
+		{
+			name:     "paragraphs grouped",
+			strategy: domain.ChunkStrategyParagraph,
+			size:     6, overlap: 0,
+			content: "P1 a b\n\nP2 c d\n\nP3 e",
+			want:    []string{"P1 a b\n\nP2 c d", "P3 e"},
+		},
+		{
+			name:     "markdown sections",
+			strategy: domain.ChunkStrategyMarkdown,
+			size:     5, overlap: 0,
+			content: "# Title\nintro text\n## Part\nbody here",
+			want:    []string{"# Title\nintro text", "## Part\nbody here"},
+		},
+		{
+			name:     "markdown sections grouped",
+			strategy: domain.ChunkStrategyMarkdown,
+			size:     10, overlap: 0,
+			content: "# Title\nintro text\n## Part\nbody here",
+			want:    []string{"# Title\nintro text\n## Part\nbody here"},
+		},
+		{
+			name:     "multi-byte text",
+			strategy: domain.ChunkStrategySentence,
+			size:     2, overlap: 0,
+			content: "Café ünïcode. Ça va. 日本語 です。",
+			want:    []string{"Café ünïcode.", "Ça va.", "日本語 です。"},
+		},
+		{
+			name:     "blank document",
+			strategy: domain.ChunkStrategyParagraph,
+			size:     4, overlap: 1,
+			content: " \n\n ",
+			want:    []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &domain.Document{ID: "doc", Content: tt.content}
+			chunker := NewChunkerService(domain.ChunkingConfig{ChunkSize: tt.size, ChunkOverlap: tt.overlap, Strategy: tt.strategy})
+			chunks := chunker.ChunkDocument(doc)
+
+			got := make([]string, len(chunks))
+			for i, chunk := range chunks {
+				got[i] = chunk.Content
+				if chunk.ID != fmt.Sprintf("doc#%d", i) || chunk.ChunkIndex != i || chunk.DocumentID != "doc" {
+					t.Errorf("chunk %d has ID %s, index %d and document %s", i, chunk.ID, chunk.ChunkIndex, chunk.DocumentID)
+				}
+				if tt.content[chunk.StartOffset:chunk.EndOffset] != chunk.Content {
+					t.Errorf("chunk %d: offsets %d-%d don't match its content %q", i, chunk.StartOffset, chunk.EndOffset, chunk.Content)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunks = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkDocumentsChunkText(t *testing.T) {
+	docs := []*domain.Document{
+		{ID: "a", Content: strings.Repeat("Première phrase ici. ", 20)},
+		{ID: "b", Content: "# Titre\n\nDu texte.\n\n## Suite\n\nEncore du texte."},
+	}
+	chunks := NewChunkerService(domain.ChunkingConfig{ChunkSize: 8, ChunkOverlap: 3, Strategy: domain.ChunkStrategyParagraph}).ChunkDocuments(docs)
+	if len(chunks) < 3 {
+		t.Fatalf("got %d chunks, want at least 3", len(chunks))
+	}
+
+	// The text of chunks saved without their content is read back from
+	// their offsets
+	store := memoryContentStore{}
+	for _, doc := range docs {
+		store[doc.ID] = doc.Content
+	}
+	rag := &domain.RagSystem{ContentStore: store}
+	for _, chunk := range chunks {
+		want := chunk.Content
+		chunk.Content = ""
+		got, err := rag.ChunkText(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("ChunkText(%s) = %q, want %q", chunk.ID, got, want)
+		}
+		if countTokens(got) > 8 {
+			t.Errorf("chunk %s has %d tokens, more than 8", chunk.ID, countTokens(got))
+		}
+	}
+}
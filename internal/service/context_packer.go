@@ -0,0 +1,65 @@
+package service
+
+import (
+	"strings"
+)
+
+// packSources keeps the sources that fit in a context of maxTokens tokens,
+// by descending score. A source that does not fit entirely is cut after its
+// last complete sentence within the budget, or skipped if it has none, and
+// the following sources still fill what is left. Cuts happen between
+// tokens, so multi-byte characters are never split. The first source is cut
+// at a word boundary if it has no complete sentence within the budget, so
+// that the context is never empty.
+func packSources(sources []Source, maxTokens int) []Source {
+	var packed []Source
+	remaining := maxTokens
+
+	for _, source := range sources {
+		if remaining <= 0 {
+			break
+		}
+
+		tokens := tokenPattern.FindAllStringIndex(source.Content, -1)
+		if len(tokens) <= remaining {
+			packed = append(packed, source)
+			remaining -= len(tokens)
+			continue
+		}
+
+		// Keep the longest prefix made of complete sentences within the budget
+		limit := tokens[remaining-1][1]
+		end := lastSentenceEnd(source.Content[:limit])
+		if end == 0 {
+			if len(packed) > 0 {
+				continue
+			}
+			end = limit
+		}
+
+		cut := source
+		cut.Content = strings.TrimSpace(source.Content[:end])
+		cut.EndOffset = cut.StartOffset + len(cut.Content)
+		packed = append(packed, cut)
+		remaining -= countTokens(cut.Content)
+	}
+
+	return packed
+}
+
+// lastSentenceEnd returns the offset just after the last sentence terminator
+// of text that ends a sentence, or 0 if there is none
+func lastSentenceEnd(text string) int {
+	for i := len(text) - 1; i >= 0; i-- {
+		switch text[i] {
+		case '\n':
+			return i + 1
+		case '.', '!', '?':
+			// A terminator ends a sentence when followed by a space or the end of text
+			if i == len(text)-1 || isSpace(text[i+1]) {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
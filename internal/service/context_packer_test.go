@@ -0,0 +1,139 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestPackSources(t *testing.T) {
+	tests := []struct {
+		name      string
+		contents  []string
+		maxTokens int
+		want      []string
+	}{
+		{
+			name:      "everything fits",
+			contents:  []string{"one two", "three four five"},
+			maxTokens: 5,
+			want:      []string{"one two", "three four five"},
+		},
+		{
+			name:      "cut at a sentence boundary",
+			contents:  []string{"First sentence here. Second sentence is longer."},
+			maxTokens: 5,
+			want:      []string{"First sentence here."},
+		},
+		{
+			name:      "cut at a line break",
+			contents:  []string{"A title\nthe body of the section"},
+			maxTokens: 4,
+			want:      []string{"A title"},
+		},
+		{
+			name:      "first source without a sentence is cut at a word",
+			contents:  []string{"alpha beta gamma delta"},
+			maxTokens: 2,
+			want:      []string{"alpha beta"},
+		},
+		{
+			name:      "source without a sentence is skipped",
+			contents:  []string{"one two", "three four five six", "seven"},
+			maxTokens: 4,
+			want:      []string{"one two", "seven"},
+		},
+		{
+			name:      "filling goes on after a cut",
+			contents:  []string{"one two", "Three four. Five six seven.", "eight"},
+			maxTokens: 5,
+			want:      []string{"one two", "Three four.", "eight"},
+		},
+		{
+			name:      "multi-byte characters are not split",
+			contents:  []string{"Où est l'élève? Ça dépend du café."},
+			maxTokens: 5,
+			want:      []string{"Où est l'élève?"},
+		},
+		{
+			name:      "multi-byte word cut",
+			contents:  []string{"日本語のテキスト 二番目の単語 三番目"},
+			maxTokens: 1,
+			want:      []string{"日本語のテキスト"},
+		},
+		{
+			name:      "zero budget",
+			contents:  []string{"one two"},
+			maxTokens: 0,
+			want:      nil,
+		},
+		{
+			name:      "negative budget",
+			contents:  []string{"one two"},
+			maxTokens: -3,
+			want:      nil,
+		},
+		{
+			name:      "budget of one token",
+			contents:  []string{"Hi. there", "next"},
+			maxTokens: 1,
+			want:      []string{"Hi."},
+		},
+		{
+			name:      "no sources",
+			contents:  nil,
+			maxTokens: 10,
+			want:      nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources := make([]Source, len(tt.contents))
+			for i, content := range tt.contents {
+				sources[i] = Source{ChunkID: string(rune('a' + i)), StartOffset: 100, EndOffset: 100 + len(content), Content: content}
+			}
+
+			packed := packSources(sources, tt.maxTokens)
+
+			var got []string
+			total := 0
+			for _, source := range packed {
+				got = append(got, source.Content)
+				total += countTokens(source.Content)
+				if !utf8.ValidString(source.Content) {
+					t.Errorf("source %s is not valid UTF-8: %q", source.ChunkID, source.Content)
+				}
+				if source.EndOffset-source.StartOffset != len(source.Content) {
+					t.Errorf("source %s has offsets %d-%d for %d bytes", source.ChunkID, source.StartOffset, source.EndOffset, len(source.Content))
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("packSources = %q, want %q", got, tt.want)
+			}
+			if tt.maxTokens > 0 && total > tt.maxTokens {
+				t.Errorf("packed %d tokens, over the budget of %d", total, tt.maxTokens)
+			}
+		})
+	}
+}
+
+func TestLastSentenceEnd(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"No terminator", 0},
+		{"Ends here.", 10},
+		{"One. Two", 4},
+		{"Version 1.2 is out", 0},
+		{"Line one\nline two", 9},
+		{"Really?! Yes", 8},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := lastSentenceEnd(tt.text); got != tt.want {
+			t.Errorf("lastSentenceEnd(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/golvellius32/rlama/internal/client"
+	"github.com/golvellius32/rlama/internal/domain"
+)
+
+// DefaultMaxTurns is the number of exchanges a conversation remembers
+const DefaultMaxTurns = 5
+
+// maxTurnAnswerLength bounds the size of each remembered answer in prompts
+const maxTurnAnswerLength = 1000
+
+// Turn is a question and the answer given to it
+type Turn struct {
+	Question string
+	Answer   string
+}
+
+// Conversation is an interactive session with a RAG system. It remembers the
+// last exchanges so that follow-up questions can refer to earlier ones.
+// Follow-up questions are rewritten into standalone queries before searching
+// the documents, and the history is passed to the model with each question.
+type Conversation struct {
+	ragService *RagService
+	rag        *domain.RagSystem
+	maxTurns   int
+	retrieval  domain.RetrievalConfig
+	history    []Turn
+}
+
+// NewConversation starts a conversation with a RAG system that remembers at
+// most maxTurns exchanges (DefaultMaxTurns if maxTurns <= 0)
+func (rs *RagService) NewConversation(rag *domain.RagSystem, maxTurns int) *Conversation {
+	if maxTurns <= 0 {
+		maxTurns = DefaultMaxTurns
+	}
+	return &Conversation{
+		ragService: rs,
+		rag:        rag,
+		maxTurns:   maxTurns,
+		retrieval:  rag.GetRetrievalConfig(),
+	}
+}
+
+// Retrieval returns the search settings used by the conversation
+func (c *Conversation) Retrieval() domain.RetrievalConfig {
+	return c.retrieval
+}
+
+// SetRetrieval changes the search settings of the next questions
+func (c *Conversation) SetRetrieval(retrieval domain.RetrievalConfig) error {
+	if err := retrieval.Validate(); err != nil {
+		return err
+	}
+	c.retrieval = retrieval
+	return nil
+}
+
+// History returns the remembered exchanges, oldest first
+func (c *Conversation) History() []Turn {
+	return c.history
+}
+
+// Reset forgets the conversation history
+func (c *Conversation) Reset() {
+	c.history = nil
+}
+
+// Ask answers a question in the context of the conversation, calling onToken
+// for each piece of the answer as soon as the model produces it. A follow-up
+// question that can't be rewritten is searched as asked.
+func (c *Conversation) Ask(ctx context.Context, question string, onToken client.TokenCallback) (*QueryResult, error) {
+	retrievalQuery := question
+	if len(c.history) > 0 {
+		rewritten, err := c.rewriteQuestion(ctx, question)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// If the rewrite fails, search with the question as asked
+		if err == nil {
+			retrievalQuery = rewritten
+		}
+	}
+
+	result, err := c.ragService.queryStream(ctx, c.rag, question, retrievalQuery, c.history, c.retrieval, onToken)
+	if err != nil {
+		return result, err
+	}
+
+	c.history = append(c.history, Turn{Question: question, Answer: result.Answer})
+	if len(c.history) > c.maxTurns {
+		c.history = c.history[len(c.history)-c.maxTurns:]
+	}
+
+	return result, nil
+}
+
+// rewriteQuestion asks the model to turn a follow-up question into a question
+// that can be understood, and searched for, without the conversation
+func (c *Conversation) rewriteQuestion(ctx context.Context, question string) (string, error) {
+	prompt := fmt.Sprintf(`Given the conversation below and a follow-up question, rewrite the follow-up question as a standalone question that can be understood without the conversation. Keep names and details from the conversation that the follow-up question refers to. Reply with the standalone question only.
+
+%sFollow-up question: %s
+
+Standalone question:`, formatHistory(c.history), question)
+
+	rewritten, err := c.ragService.ollamaClient.GenerateCompletion(ctx, c.rag.ModelName, prompt)
+	if err != nil {
+		return "", fmt.Errorf("error rewriting the question: %w", err)
+	}
+
+	// Keep the first non-empty line, without the quotes models like to add
+	for _, line := range strings.Split(rewritten, "\n") {
+		line = strings.Trim(strings.TrimSpace(line), `"'`)
+		if line != "" {
+			return line, nil
+		}
+	}
+	return question, nil
+}
+
+// formatHistory renders the conversation history for a prompt
+func formatHistory(history []Turn) string {
+	if len(history) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("Conversation so far:\n\n")
+	for _, turn := range history {
+		b.WriteString(fmt.Sprintf("User: %s\nAssistant: %s\n\n", turn.Question, truncateAnswer(turn.Answer)))
+	}
+	return b.String()
+}
+
+// truncateAnswer bounds the size of a remembered answer, without cutting a
+// multi-byte character
+func truncateAnswer(answer string) string {
+	answer = strings.TrimSpace(answer)
+	if len(answer) > maxTurnAnswerLength {
+		end := maxTurnAnswerLength
+		for end > 0 && !utf8.RuneStart(answer[end]) {
+			end--
+		}
+		answer = answer[:end] + "..."
+	}
+	return answer
+}
@@ -1,14 +1,12 @@
 package service
 
 import (
-	// Suppression des imports non utilisés
-	// "bytes"
-	// "encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	// "io"
+	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -17,82 +15,28 @@ import (
 
 // DocumentLoader is responsible for loading documents from the file system
 type DocumentLoader struct {
-	supportedExtensions map[string]bool
-	extractorPath       string // Path to the external extractor
+	registry *ExtractorRegistry
 }
 
 // NewDocumentLoader creates a new instance of DocumentLoader
 func NewDocumentLoader() *DocumentLoader {
-	return &DocumentLoader{
-		supportedExtensions: map[string]bool{
-			// Plain text
-			".txt":  true,
-			".md":   true,
-			".html": true,
-			".htm":  true,
-			".json": true,
-			".csv":  true,
-			".log":  true,
-			".xml":  true,
-			".yaml": true,
-			".yml":  true,
-			// Source code
-			".go":    true,
-			".py":    true,
-			".js":    true,
-			".java":  true,
-			".c":     true,
-			".cpp":   true,
-			".h":     true,
-			".rb":    true,
-			".php":   true,
-			".rs":    true,
-			".swift": true,
-			".kt":    true,
-			// Documents
-			".pdf":  true,
-			".docx": true,
-			".doc":  true,
-			".rtf":  true,
-			".odt":  true,
-			".pptx": true,
-			".ppt":  true,
-			".xlsx": true,
-			".xls":  true,
-			".epub": true,
-		},
-		// We'll use pdftotext if available
-		extractorPath: findExternalExtractor(),
-	}
+	return NewDocumentLoaderWithRegistry(DefaultExtractorRegistry())
 }
 
-// findExternalExtractor looks for external extraction tools
-func findExternalExtractor() string {
-	// Priority of text extractors
-	extractors := []string{
-		"pdftotext", // For PDFs (Poppler-utils)
-		"textutil",  // macOS
-		"catdoc",    // For .doc
-		"unrtf",     // For .rtf
-	}
-
-	for _, extractor := range extractors {
-		path, err := exec.LookPath(extractor)
-		if err == nil {
-			fmt.Printf("External extractor found: %s\n", path)
-			return path
-		}
-	}
+// NewDocumentLoaderWithRegistry creates a DocumentLoader that extracts text
+// with the given extractors
+func NewDocumentLoaderWithRegistry(registry *ExtractorRegistry) *DocumentLoader {
+	return &DocumentLoader{registry: registry}
+}
 
-	fmt.Println("No external extractor found. Text extraction will be limited.")
-	return ""
+// Registry returns the extractors used by the loader
+func (dl *DocumentLoader) Registry() *ExtractorRegistry {
+	return dl.registry
 }
 
 // LoadDocumentsFromFolder loads all supported documents from the specified folder
 func (dl *DocumentLoader) LoadDocumentsFromFolder(folderPath string) ([]*domain.Document, error) {
 	var documents []*domain.Document
-	var supportedFiles []string
-	var unsupportedFiles []string
 
 	// Check if the folder exists
 	info, err := os.Stat(folderPath)
@@ -116,27 +60,9 @@ func (dl *DocumentLoader) LoadDocumentsFromFolder(folderPath string) ([]*domain.
 	}
 
 	// Preliminary file check
-	err = filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Ignore folders and hidden files (starting with .)
-		if info.IsDir() || strings.HasPrefix(filepath.Base(path), ".") {
-			return nil
-		}
-
-		ext := strings.ToLower(filepath.Ext(path))
-		if dl.supportedExtensions[ext] {
-			supportedFiles = append(supportedFiles, path)
-		} else {
-			unsupportedFiles = append(unsupportedFiles, path)
-		}
-		return nil
-	})
-
+	supportedFiles, unsupportedFiles, err := dl.ListFiles(folderPath)
 	if err != nil {
-		return nil, fmt.Errorf("error while analyzing folder: %w", err)
+		return nil, err
 	}
 
 	// Display info about found files
@@ -144,10 +70,7 @@ func (dl *DocumentLoader) LoadDocumentsFromFolder(folderPath string) ([]*domain.
 		if len(unsupportedFiles) == 0 {
 			return nil, fmt.Errorf("folder '%s' is empty. Please add documents before creating a RAG", folderPath)
 		} else {
-			extensionsMsg := "Supported extensions: "
-			for ext := range dl.supportedExtensions {
-				extensionsMsg += ext + " "
-			}
+			extensionsMsg := "Supported extensions: " + strings.Join(dl.registry.Extensions(), " ")
 			return nil, fmt.Errorf("no supported files found in '%s'. %d unsupported files detected.\n%s",
 				folderPath, len(unsupportedFiles), extensionsMsg)
 		}
@@ -155,51 +78,20 @@ func (dl *DocumentLoader) LoadDocumentsFromFolder(folderPath string) ([]*domain.
 
 	fmt.Printf("Found %d supported files and %d unsupported files.\n", len(supportedFiles), len(unsupportedFiles))
 
-	// Try to install dependencies if possible
-	dl.tryInstallDependencies()
+	// Point out missing external programs instead of silently degrading
+	if missing := dl.missingBinaries(supportedFiles); len(missing) > 0 {
+		fmt.Printf("Some extractors are unavailable (missing: %s). Run 'rlama doctor' for details.\n",
+			strings.Join(missing, ", "))
+	}
 
 	// Process supported files
 	for _, path := range supportedFiles {
-		ext := strings.ToLower(filepath.Ext(path))
-
-		// Text extraction using multiple methods
-		textContent, err := dl.extractText(path, ext)
+		doc, err := dl.LoadDocument(path)
 		if err != nil {
-			fmt.Printf("Warning: unable to extract text from %s: %v\n", path, err)
-			fmt.Println("Attempting extraction as raw text...")
-
-			// Try reading as a text file
-			rawContent, err := ioutil.ReadFile(path)
-			if err != nil {
-				fmt.Printf("Failed to read raw %s: %v\n", path, err)
-				continue
-			}
-
-			textContent = string(rawContent)
+			fmt.Printf("Warning: %v\n", err)
+			continue
 		}
-
-		// Check that the content is not empty
-		if strings.TrimSpace(textContent) == "" {
-			fmt.Printf("Warning: no text extracted from %s\n", path)
-
-			// For PDFs, try one last method
-			if ext == ".pdf" {
-				fmt.Println("Attempting extraction with OCR (if installed)...")
-				ocrText, err := dl.extractWithOCR(path)
-				if err != nil || strings.TrimSpace(ocrText) == "" {
-					fmt.Println("OCR failed or not available.")
-					continue
-				}
-				textContent = ocrText
-			} else {
-				continue
-			}
-		}
-
-		// Create a document
-		doc := domain.NewDocument(path, textContent)
 		documents = append(documents, doc)
-		fmt.Printf("Document added: %s (%d characters)\n", filepath.Base(path), len(textContent))
 	}
 
 	if len(documents) == 0 {
@@ -209,259 +101,119 @@ func (dl *DocumentLoader) LoadDocumentsFromFolder(folderPath string) ([]*domain.
 	return documents, nil
 }
 
-// extractText extracts text from a file using the appropriate method based on type
-func (dl *DocumentLoader) extractText(path string, ext string) (string, error) {
-	switch ext {
-	case ".pdf":
-		return dl.extractFromPDF(path)
-	case ".docx", ".doc", ".rtf", ".odt":
-		return dl.extractFromDocument(path, ext)
-	case ".pptx", ".ppt":
-		return dl.extractFromPresentation(path, ext)
-	case ".xlsx", ".xls":
-		return dl.extractFromSpreadsheet(path, ext)
-	default:
-		// Treat as a text file
-		data, err := ioutil.ReadFile(path)
-		if err != nil {
-			return "", err
-		}
-		return string(data), nil
-	}
-}
-
-// extractFromPDF extracts text from a PDF using different methods
-func (dl *DocumentLoader) extractFromPDF(path string) (string, error) {
-	// Method 1: Use pdftotext if available
-	if strings.Contains(dl.extractorPath, "pdftotext") {
-		fmt.Printf("Extracting PDF with pdftotext: %s\n", filepath.Base(path))
-		out, err := exec.Command(dl.extractorPath, "-layout", path, "-").Output()
-		if err == nil && len(out) > 0 {
-			return string(out), nil
-		}
-		fmt.Printf("pdftotext failed: %v\n", err)
-	}
+// ListFiles walks a folder and returns the supported and unsupported files it contains.
+// Hidden files are ignored.
+func (dl *DocumentLoader) ListFiles(folderPath string) ([]string, []string, error) {
+	var supportedFiles []string
+	var unsupportedFiles []string
 
-	// Method 2: Try with other tools (pdfinfo, pdftk)
-	for _, tool := range []string{"pdfinfo", "pdftk"} {
-		toolPath, err := exec.LookPath(tool)
-		if err == nil {
-			fmt.Printf("Attempting extraction with %s\n", tool)
-			var out []byte
-			if tool == "pdfinfo" {
-				out, err = exec.Command(toolPath, path).Output()
-			} else {
-				out, err = exec.Command(toolPath, path, "dump_data").Output()
-			}
-			if err == nil && len(out) > 0 {
-				return string(out), nil
-			}
+	err := filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
 		}
-	}
-
-	// Method 3: Last attempt, open as binary file and extract strings
-	fmt.Println("Extracting strings from PDF...")
-	return dl.extractStringsFromBinary(path)
-}
 
-// extractFromDocument extracts text from a Word document or similar
-func (dl *DocumentLoader) extractFromDocument(path string, ext string) (string, error) {
-	// Method 1: Use textutil on macOS
-	if strings.Contains(dl.extractorPath, "textutil") && (ext == ".docx" || ext == ".doc" || ext == ".rtf") {
-		fmt.Printf("Extracting document with textutil: %s\n", filepath.Base(path))
-		out, err := exec.Command(dl.extractorPath, "-convert", "txt", "-stdout", path).Output()
-		if err == nil && len(out) > 0 {
-			return string(out), nil
+		// Ignore folders and hidden files (starting with .)
+		if info.IsDir() || strings.HasPrefix(filepath.Base(path), ".") {
+			return nil
 		}
-	}
 
-	// Method 2: Use catdoc for .doc
-	if ext == ".doc" {
-		catdocPath, err := exec.LookPath("catdoc")
-		if err == nil {
-			out, err := exec.Command(catdocPath, path).Output()
-			if err == nil && len(out) > 0 {
-				return string(out), nil
-			}
+		ext := strings.ToLower(filepath.Ext(path))
+		if dl.registry.Supports(ext) {
+			supportedFiles = append(supportedFiles, path)
+		} else {
+			unsupportedFiles = append(unsupportedFiles, path)
 		}
-	}
+		return nil
+	})
 
-	// Method 3: Use unrtf for .rtf
-	if ext == ".rtf" {
-		unrtfPath, err := exec.LookPath("unrtf")
-		if err == nil {
-			out, err := exec.Command(unrtfPath, "--text", path).Output()
-			if err == nil && len(out) > 0 {
-				return string(out), nil
-			}
-		}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while analyzing folder: %w", err)
 	}
 
-	// Method 4: Extract strings
-	return dl.extractStringsFromBinary(path)
-}
-
-// extractFromPresentation extracts text from a PowerPoint presentation
-func (dl *DocumentLoader) extractFromPresentation(path string, ext string) (string, error) {
-	// External tools for PowerPoint are limited
-	return dl.extractStringsFromBinary(path)
+	return supportedFiles, unsupportedFiles, nil
 }
 
-// extractFromSpreadsheet extracts text from an Excel spreadsheet
-func (dl *DocumentLoader) extractFromSpreadsheet(path string, ext string) (string, error) {
-	// Try to use xlsx2csv for .xlsx
-	if ext == ".xlsx" {
-		xlsx2csvPath, err := exec.LookPath("xlsx2csv")
-		if err == nil {
-			out, err := exec.Command(xlsx2csvPath, path).Output()
-			if err == nil && len(out) > 0 {
-				return string(out), nil
-			}
-		}
-	}
+// LoadDocument extracts the text of a single file and records its source
+// information (modification time, size and content hash)
+func (dl *DocumentLoader) LoadDocument(path string) (*domain.Document, error) {
+	// Text extraction using the registered extractors
+	textContent, err := dl.registry.Extract(path)
+	if err != nil {
+		fmt.Printf("Warning: unable to extract text from %s: %v\n", path, err)
+		fmt.Println("Attempting extraction as raw text...")
 
-	// Try to use xls2csv for .xls
-	if ext == ".xls" {
-		xls2csvPath, err := exec.LookPath("xls2csv")
-		if err == nil {
-			out, err := exec.Command(xls2csvPath, path).Output()
-			if err == nil && len(out) > 0 {
-				return string(out), nil
-			}
+		// Try reading as a text file
+		rawContent, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read raw %s: %w", path, err)
 		}
-	}
-
-	// Extract strings
-	return dl.extractStringsFromBinary(path)
-}
 
-// extractStringsFromBinary extracts strings from a binary file
-func (dl *DocumentLoader) extractStringsFromBinary(path string) (string, error) {
-	// Use the 'strings' tool if available (Unix/Linux/macOS)
-	stringsPath, err := exec.LookPath("strings")
-	if err == nil {
-		out, err := exec.Command(stringsPath, path).Output()
-		if err == nil && len(out) > 0 {
-			return string(out), nil
-		}
+		textContent = string(rawContent)
 	}
 
-	// Basic implementation of 'strings' in Go
-	data, err := ioutil.ReadFile(path)
-	if err != nil {
-		return "", err
+	// Check that the content is not empty
+	if strings.TrimSpace(textContent) == "" {
+		return nil, fmt.Errorf("no text extracted from %s", path)
 	}
 
-	var result strings.Builder
-	var currentWord strings.Builder
+	// Create a document
+	doc := domain.NewDocument(path, textContent)
 
-	for _, b := range data {
-		if (b >= 32 && b <= 126) || b == '\n' || b == '\t' || b == '\r' {
-			currentWord.WriteByte(b)
-		} else {
-			if currentWord.Len() >= 4 { // Word of at least 4 characters
-				result.WriteString(currentWord.String())
-				result.WriteString(" ")
-			}
-			currentWord.Reset()
-		}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to access %s: %w", path, err)
 	}
-
-	if currentWord.Len() >= 4 {
-		result.WriteString(currentWord.String())
+	hash, err := HashFile(path)
+	if err != nil {
+		return nil, err
 	}
+	doc.SourceModTime = info.ModTime()
+	doc.SourceSize = info.Size()
+	doc.SourceHash = hash
+	doc.Metadata = dl.registry.Properties(path)
 
-	return result.String(), nil
+	fmt.Printf("Document added: %s (%d characters)\n", filepath.Base(path), len(textContent))
+	return doc, nil
 }
 
-// extractWithOCR attempts to extract text using OCR
-func (dl *DocumentLoader) extractWithOCR(path string) (string, error) {
-	// Check if tesseract is available
-	tesseractPath, err := exec.LookPath("tesseract")
-	if err != nil {
-		return "", fmt.Errorf("OCR not available: tesseract not found")
-	}
-
-	// Create a temporary output file
-	tempDir, err := ioutil.TempDir("", "rlama-ocr")
-	if err != nil {
-		return "", err
-	}
-	defer os.RemoveAll(tempDir)
-
-	outBasePath := filepath.Join(tempDir, "out")
-
-	// For PDFs, first convert to images if possible
-	ext := strings.ToLower(filepath.Ext(path))
-	if ext == ".pdf" {
-		// Check if pdftoppm is available
-		pdftoppmPath, err := exec.LookPath("pdftoppm")
-		if err == nil {
-			// Convert PDF to images
-			fmt.Println("Converting PDF to images for OCR...")
-			cmd := exec.Command(pdftoppmPath, "-png", path, filepath.Join(tempDir, "page"))
-			if err := cmd.Run(); err != nil {
-				return "", fmt.Errorf("failed to convert PDF to images: %w", err)
-			}
+// missingBinaries returns the external programs that would improve the
+// extraction of the given files but are not installed
+func (dl *DocumentLoader) missingBinaries(files []string) []string {
+	seenExt := make(map[string]bool)
+	seenBin := make(map[string]bool)
+	var missing []string
 
-			// OCR on each image
-			var allText strings.Builder
-			imgFiles, _ := filepath.Glob(filepath.Join(tempDir, "page-*.png"))
-			for _, imgFile := range imgFiles {
-				fmt.Printf("OCR on %s...\n", filepath.Base(imgFile))
-				cmd := exec.Command(tesseractPath, imgFile, outBasePath, "-l", "eng")
-				if err := cmd.Run(); err != nil {
-					fmt.Printf("Warning: OCR failed for %s: %v\n", imgFile, err)
-					continue
-				}
+	for _, path := range files {
+		ext := strings.ToLower(filepath.Ext(path))
+		if seenExt[ext] {
+			continue
+		}
+		seenExt[ext] = true
 
-				// Read the extracted text
-				textBytes, err := ioutil.ReadFile(outBasePath + ".txt")
-				if err != nil {
-					continue
+		for _, e := range dl.registry.Extractors(ext) {
+			for _, bin := range MissingBinaries(e) {
+				if !seenBin[bin] {
+					seenBin[bin] = true
+					missing = append(missing, bin)
 				}
-
-				allText.WriteString(string(textBytes))
-				allText.WriteString("\n\n")
 			}
-
-			return allText.String(), nil
 		}
 	}
 
-	// Direct OCR on the file (for images)
-	cmd := exec.Command(tesseractPath, path, outBasePath, "-l", "eng")
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("OCR failed: %w", err)
-	}
-
-	// Read the extracted text
-	textBytes, err := ioutil.ReadFile(outBasePath + ".txt")
-	if err != nil {
-		return "", err
-	}
-
-	return string(textBytes), nil
+	return missing
 }
 
-// tryInstallDependencies attempts to install dependencies if necessary
-func (dl *DocumentLoader) tryInstallDependencies() {
-	// Check if pip is available (for Python tools)
-	pipPath, err := exec.LookPath("pip3")
+// HashFile returns the hex-encoded SHA-256 of a file's content
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		pipPath, err = exec.LookPath("pip")
+		return "", fmt.Errorf("unable to open %s: %w", path, err)
 	}
+	defer f.Close()
 
-	if err == nil {
-		fmt.Println("Checking Python text extraction tools...")
-		// Try to install useful packages
-		for _, pkg := range []string{"pdfminer.six", "docx2txt", "xlsx2csv"} {
-			cmd := exec.Command(pipPath, "show", pkg)
-			if err := cmd.Run(); err != nil {
-				fmt.Printf("Installing %s...\n", pkg)
-				installCmd := exec.Command(pipPath, "install", "--user", pkg)
-				installCmd.Run() // Ignore errors
-			}
-		}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("unable to hash %s: %w", path, err)
 	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
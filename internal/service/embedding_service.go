@@ -1,31 +1,79 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/golvellius32/rlama/internal/client"
 	"github.com/golvellius32/rlama/internal/domain"
+	"github.com/golvellius32/rlama/internal/repository"
 )
 
-// EmbeddingService manages the generation of embeddings for documents
+const (
+	// DefaultEmbeddingConcurrency is the default number of embedding requests
+	// sent to Ollama at the same time
+	DefaultEmbeddingConcurrency = 4
+	// DefaultEmbeddingBatchSize is the default number of chunks embedded by
+	// a single request to Ollama's batch endpoint
+	DefaultEmbeddingBatchSize = 16
+)
+
+// ProgressFunc is called as work progresses, with the number of items done
+// out of total. Calls never overlap.
+type ProgressFunc func(done, total int)
+
+// EmbeddingOptions controls how chunk embeddings are generated
+type EmbeddingOptions struct {
+	// Concurrency is the number of requests sent to Ollama at the same time
+	Concurrency int
+	// BatchSize is the number of chunks embedded by a single request
+	BatchSize int
+	// Progress, if set, is called each time a batch of chunks is embedded
+	Progress ProgressFunc
+}
+
+// EmbeddingService manages the generation of embeddings for documents.
+// Embeddings are looked up in the on-disk cache before asking Ollama.
 type EmbeddingService struct {
 	ollamaClient *client.OllamaClient
+	cache        *repository.EmbeddingCache
+	options      EmbeddingOptions
+	// singleOnly is set once Ollama turns out not to support batch requests
+	singleOnly atomic.Bool
 }
 
 // NewEmbeddingService creates a new instance of EmbeddingService
 func NewEmbeddingService() *EmbeddingService {
 	return &EmbeddingService{
 		ollamaClient: client.NewOllamaClient(),
+		cache:        repository.NewEmbeddingCache(),
+		options: EmbeddingOptions{
+			Concurrency: DefaultEmbeddingConcurrency,
+			BatchSize:   DefaultEmbeddingBatchSize,
+		},
+	}
+}
+
+// SetOptions changes how chunk embeddings are generated. Zero values keep
+// the defaults.
+func (es *EmbeddingService) SetOptions(opts EmbeddingOptions) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultEmbeddingConcurrency
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultEmbeddingBatchSize
 	}
+	es.options = opts
 }
 
 // GenerateEmbeddings generates embeddings for a list of documents
-func (es *EmbeddingService) GenerateEmbeddings(docs []*domain.Document, modelName string) error {
+func (es *EmbeddingService) GenerateEmbeddings(ctx context.Context, docs []*domain.Document, modelName string) error {
 	for _, doc := range docs {
-		// We can chunk here if needed
-
 		// Generate embedding
-		embedding, err := es.ollamaClient.GenerateEmbedding(modelName, doc.Content)
+		embedding, err := es.embed(ctx, modelName, doc.Content)
 		if err != nil {
 			return fmt.Errorf("error generating embedding for %s: %w", doc.Path, err)
 		}
@@ -36,9 +84,161 @@ func (es *EmbeddingService) GenerateEmbeddings(docs []*domain.Document, modelNam
 	return nil
 }
 
-// GenerateQueryEmbedding generates an embedding for a query
-func (es *EmbeddingService) GenerateQueryEmbedding(query string, modelName string) ([]float32, error) {
-	embedding, err := es.ollamaClient.GenerateEmbedding(modelName, query)
+// GenerateChunkEmbeddings generates embeddings for a list of document chunks.
+// Batches of chunks are embedded by a bounded pool of workers; the first
+// error, or the cancellation of ctx, stops all of them. onBatch, if not nil,
+// is called with each embedded batch, never concurrently; an error stops
+// the generation.
+func (es *EmbeddingService) GenerateChunkEmbeddings(ctx context.Context, chunks []*domain.DocumentChunk, modelName string, onBatch func(batch []*domain.DocumentChunk) error) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	batches := make(chan []*domain.DocumentChunk)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		done     int
+	)
+
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+
+	workers := es.options.Concurrency
+	if workers > (len(chunks)+es.options.BatchSize-1)/es.options.BatchSize {
+		workers = (len(chunks) + es.options.BatchSize - 1) / es.options.BatchSize
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := es.embedBatch(ctx, batch, modelName); err != nil {
+					fail(err)
+					continue
+				}
+
+				mu.Lock()
+				if onBatch != nil {
+					if err := onBatch(batch); err != nil {
+						mu.Unlock()
+						fail(err)
+						continue
+					}
+				}
+				done += len(batch)
+				if es.options.Progress != nil {
+					es.options.Progress(done, len(chunks))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// Feed the workers until every batch is sent or the work is cancelled
+feed:
+	for start := 0; start < len(chunks); start += es.options.BatchSize {
+		end := start + es.options.BatchSize
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+		select {
+		case batches <- chunks[start:end]:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(batches)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	// The parent context may have been cancelled while the last batches ran
+	return ctx.Err()
+}
+
+// embedBatch embeds a batch of chunks, with a single request when Ollama
+// supports batch embeddings and one request per chunk otherwise. Only the
+// chunks missing from the cache are sent.
+func (es *EmbeddingService) embedBatch(ctx context.Context, batch []*domain.DocumentChunk, modelName string) error {
+	var missing []*domain.DocumentChunk
+	for _, chunk := range batch {
+		if embedding, ok := es.cache.Get(modelName, chunk.Content); ok {
+			chunk.Embedding = embedding
+			continue
+		}
+		missing = append(missing, chunk)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	batch = missing
+
+	if !es.singleOnly.Load() {
+		texts := make([]string, len(batch))
+		for i, chunk := range batch {
+			texts[i] = chunk.Content
+		}
+
+		embeddings, err := es.ollamaClient.GenerateEmbeddings(ctx, modelName, texts)
+		if err == nil {
+			for i, chunk := range batch {
+				chunk.Embedding = embeddings[i]
+				es.cache.Put(modelName, chunk.Content, chunk.Embedding)
+			}
+			return nil
+		}
+		if !errors.Is(err, client.ErrBatchEmbeddingUnsupported) {
+			return fmt.Errorf("error generating embeddings for chunks %s to %s: %w", batch[0].ID, batch[len(batch)-1].ID, err)
+		}
+		es.singleOnly.Store(true)
+	}
+
+	for _, chunk := range batch {
+		embedding, err := es.embed(ctx, modelName, chunk.Content)
+		if err != nil {
+			return fmt.Errorf("error generating embedding for chunk %s: %w", chunk.ID, err)
+		}
+
+		chunk.Embedding = embedding
+	}
+
+	return nil
+}
+
+// embed returns the embedding of a text, from the cache when possible.
+// Failing to write the cache is not an error: it only costs a request later.
+func (es *EmbeddingService) embed(ctx context.Context, modelName, text string) ([]float32, error) {
+	if embedding, ok := es.cache.Get(modelName, text); ok {
+		return embedding, nil
+	}
+
+	embedding, err := es.ollamaClient.GenerateEmbedding(ctx, modelName, text)
+	if err != nil {
+		return nil, err
+	}
+	es.cache.Put(modelName, text, embedding)
+	return embedding, nil
+}
+
+// GenerateQueryEmbedding generates an embedding for a query. Queries are
+// not cached: the cache would keep the questions of users on disk.
+func (es *EmbeddingService) GenerateQueryEmbedding(ctx context.Context, query string, modelName string) ([]float32, error) {
+	embedding, err := es.ollamaClient.GenerateEmbedding(ctx, modelName, query)
 	if err != nil {
 		return nil, fmt.Errorf("error generating embedding for query: %w", err)
 	}
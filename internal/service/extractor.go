@@ -0,0 +1,349 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Extractor extracts the text of a file. Extractors that rely on external
+// programs declare them, so they can be skipped when the programs are missing
+// and reported by `rlama doctor`. Nothing is ever installed automatically.
+type Extractor interface {
+	// Name identifies the extractor in messages
+	Name() string
+	// RequiredBinaries lists the external programs the extractor needs
+	RequiredBinaries() []string
+	// Extract returns the text of the file at path
+	Extract(path string) (string, error)
+}
+
+// funcExtractor is an Extractor backed by a function
+type funcExtractor struct {
+	name     string
+	binaries []string
+	extract  func(path string) (string, error)
+}
+
+// NewExtractor creates an extractor from a function and the external
+// programs it needs
+func NewExtractor(name string, binaries []string, extract func(path string) (string, error)) Extractor {
+	return &funcExtractor{name: name, binaries: binaries, extract: extract}
+}
+
+func (e *funcExtractor) Name() string                        { return e.name }
+func (e *funcExtractor) RequiredBinaries() []string          { return e.binaries }
+func (e *funcExtractor) Extract(path string) (string, error) { return e.extract(path) }
+
+// MissingBinaries returns the programs required by an extractor that are not
+// found in the PATH
+func MissingBinaries(e Extractor) []string {
+	var missing []string
+	for _, bin := range e.RequiredBinaries() {
+		if _, err := exec.LookPath(bin); err != nil {
+			missing = append(missing, bin)
+		}
+	}
+	return missing
+}
+
+// PropertyReader reads the properties of a document, such as its title and
+// author, which become filterable metadata
+type PropertyReader func(path string) (map[string]string, error)
+
+// ExtractorRegistry maps file extensions to the extractors able to read them,
+// in order of preference, and to the readers of their properties
+type ExtractorRegistry struct {
+	extractors map[string][]Extractor
+	properties map[string]PropertyReader
+}
+
+// NewExtractorRegistry creates an empty registry
+func NewExtractorRegistry() *ExtractorRegistry {
+	return &ExtractorRegistry{
+		extractors: make(map[string][]Extractor),
+		properties: make(map[string]PropertyReader),
+	}
+}
+
+// RegisterProperties sets the property reader of a file extension
+func (r *ExtractorRegistry) RegisterProperties(ext string, reader PropertyReader) {
+	r.properties[strings.ToLower(ext)] = reader
+}
+
+// Properties returns the non-empty properties of a file, or nil when its
+// format has no property reader or they can't be read
+func (r *ExtractorRegistry) Properties(path string) map[string]string {
+	reader := r.properties[strings.ToLower(filepath.Ext(path))]
+	if reader == nil {
+		return nil
+	}
+	properties, err := reader(path)
+	if err != nil {
+		return nil
+	}
+	for key, value := range properties {
+		if value = strings.TrimSpace(value); value == "" {
+			delete(properties, key)
+		} else {
+			properties[key] = value
+		}
+	}
+	if len(properties) == 0 {
+		return nil
+	}
+	return properties
+}
+
+// Register adds extractors for a file extension, after those already registered
+func (r *ExtractorRegistry) Register(ext string, extractors ...Extractor) {
+	ext = strings.ToLower(ext)
+	r.extractors[ext] = append(r.extractors[ext], extractors...)
+}
+
+// Supports reports whether an extension has at least one extractor
+func (r *ExtractorRegistry) Supports(ext string) bool {
+	return len(r.extractors[strings.ToLower(ext)]) > 0
+}
+
+// Extensions returns the supported extensions, sorted
+func (r *ExtractorRegistry) Extensions() []string {
+	exts := make([]string, 0, len(r.extractors))
+	for ext := range r.extractors {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+// Extractors returns the extractors registered for an extension
+func (r *ExtractorRegistry) Extractors(ext string) []Extractor {
+	return r.extractors[strings.ToLower(ext)]
+}
+
+// Extract tries the extractors of the file's extension in order, skipping the
+// ones whose programs are missing, and returns the first non-empty text
+func (r *ExtractorRegistry) Extract(path string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	extractors := r.extractors[ext]
+	if len(extractors) == 0 {
+		return "", fmt.Errorf("unsupported file type: %s", ext)
+	}
+
+	var lastErr error
+	for _, e := range extractors {
+		if missing := MissingBinaries(e); len(missing) > 0 {
+			continue
+		}
+		text, err := e.Extract(path)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %w", e.Name(), err)
+			continue
+		}
+		if strings.TrimSpace(text) != "" {
+			return text, nil
+		}
+	}
+
+	if lastErr != nil {
+		return "", lastErr
+	}
+	return "", nil
+}
+
+// DefaultExtractorRegistry returns the registry used by the document loader
+func DefaultExtractorRegistry() *ExtractorRegistry {
+	r := NewExtractorRegistry()
+
+	text := NewExtractor("text", nil, readTextFile)
+	for _, ext := range []string{
+		// Plain text
+		".txt", ".md", ".html", ".htm", ".json", ".csv", ".log", ".xml", ".yaml", ".yml",
+		// Source code
+		".go", ".py", ".js", ".java", ".c", ".cpp", ".h", ".rb", ".php", ".rs", ".swift", ".kt",
+		// Documents without a dedicated reader
+		".epub",
+	} {
+		r.Register(ext, text)
+	}
+
+	binaryStrings := NewExtractor("strings", nil, extractStringsFromBinary)
+
+	r.Register(".pdf",
+		NewExtractor("pdftotext", []string{"pdftotext"}, extractWithPdftotext),
+		NewExtractor("pdfinfo", []string{"pdfinfo"}, func(path string) (string, error) {
+			return runExtractor("pdfinfo", path)
+		}),
+		NewExtractor("pdftk", []string{"pdftk"}, func(path string) (string, error) {
+			return runExtractor("pdftk", path, "dump_data")
+		}),
+		binaryStrings,
+		NewExtractor("ocr", []string{"pdftoppm", "tesseract"}, extractWithOCR),
+	)
+	r.RegisterProperties(".pdf", readPDFProperties)
+
+	// Office Open XML and OpenDocument packages are read natively
+	r.Register(".docx", NewExtractor("docx", nil, extractFromDocx))
+	r.Register(".pptx", NewExtractor("pptx", nil, extractFromPptx))
+	r.Register(".xlsx", NewExtractor("xlsx", nil, extractFromXlsx))
+	odf := NewExtractor("opendocument", nil, extractFromODF)
+	r.Register(".odt", odf)
+	r.Register(".ods", odf)
+	r.Register(".odp", odf)
+	for _, ext := range []string{".docx", ".pptx", ".xlsx"} {
+		r.RegisterProperties(ext, readOOXMLProperties)
+	}
+	for _, ext := range []string{".odt", ".ods", ".odp"} {
+		r.RegisterProperties(ext, readODFProperties)
+	}
+
+	r.Register(".rtf",
+		NewExtractor("unrtf", []string{"unrtf"}, func(path string) (string, error) {
+			return runExtractor("unrtf", "--text", path)
+		}),
+		binaryStrings,
+	)
+
+	// Legacy binary formats: only printable strings can be recovered
+	r.Register(".doc", binaryStrings)
+	r.Register(".ppt", binaryStrings)
+	r.Register(".xls", binaryStrings)
+
+	return r
+}
+
+// readTextFile returns the content of a text file
+func readTextFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// runExtractor runs an external program and returns its standard output
+func runExtractor(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// extractWithPdftotext extracts the text of a PDF with pdftotext (Poppler)
+func extractWithPdftotext(path string) (string, error) {
+	fmt.Printf("Extracting PDF with pdftotext: %s\n", filepath.Base(path))
+	return runExtractor("pdftotext", "-layout", path, "-")
+}
+
+// readPDFProperties reads the document information of a PDF with pdfinfo
+func readPDFProperties(path string) (map[string]string, error) {
+	if _, err := exec.LookPath("pdfinfo"); err != nil {
+		return nil, err
+	}
+	out, err := runExtractor("pdfinfo", path)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{
+		"Title":    "title",
+		"Author":   "author",
+		"Subject":  "subject",
+		"Keywords": "keywords",
+		"Pages":    "pages",
+	}
+	properties := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if key, known := fields[name]; ok && known {
+			properties[key] = value
+		}
+	}
+	return properties, nil
+}
+
+// extractStringsFromBinary extracts strings from a binary file
+func extractStringsFromBinary(path string) (string, error) {
+	// Use the 'strings' tool if available (Unix/Linux/macOS)
+	stringsPath, err := exec.LookPath("strings")
+	if err == nil {
+		out, err := exec.Command(stringsPath, path).Output()
+		if err == nil && len(out) > 0 {
+			return string(out), nil
+		}
+	}
+
+	// Basic implementation of 'strings' in Go
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	var result strings.Builder
+	var currentWord strings.Builder
+
+	for _, b := range data {
+		if (b >= 32 && b <= 126) || b == '\n' || b == '\t' || b == '\r' {
+			currentWord.WriteByte(b)
+		} else {
+			if currentWord.Len() >= 4 { // Word of at least 4 characters
+				result.WriteString(currentWord.String())
+				result.WriteString(" ")
+			}
+			currentWord.Reset()
+		}
+	}
+
+	if currentWord.Len() >= 4 {
+		result.WriteString(currentWord.String())
+	}
+
+	return result.String(), nil
+}
+
+// extractWithOCR converts the pages of a PDF to images and runs tesseract on them
+func extractWithOCR(path string) (string, error) {
+	tempDir, err := ioutil.TempDir("", "rlama-ocr")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tempDir)
+
+	outBasePath := filepath.Join(tempDir, "out")
+
+	// Convert PDF to images
+	fmt.Println("Converting PDF to images for OCR...")
+	cmd := exec.Command("pdftoppm", "-png", path, filepath.Join(tempDir, "page"))
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to convert PDF to images: %w", err)
+	}
+
+	// OCR on each image
+	var allText strings.Builder
+	imgFiles, _ := filepath.Glob(filepath.Join(tempDir, "page-*.png"))
+	for _, imgFile := range imgFiles {
+		fmt.Printf("OCR on %s...\n", filepath.Base(imgFile))
+		cmd := exec.Command("tesseract", imgFile, outBasePath, "-l", "eng")
+		if err := cmd.Run(); err != nil {
+			fmt.Printf("Warning: OCR failed for %s: %v\n", imgFile, err)
+			continue
+		}
+
+		// Read the extracted text
+		textBytes, err := ioutil.ReadFile(outBasePath + ".txt")
+		if err != nil {
+			continue
+		}
+
+		// Pages are separated by form feeds, as in pdftotext's output
+		allText.WriteString(string(textBytes))
+		allText.WriteString("\f")
+	}
+
+	return allText.String(), nil
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"sort"
+
+	"github.com/golvellius32/rlama/pkg/bm25"
+	"github.com/golvellius32/rlama/pkg/vector"
+)
+
+// rrfK dampens the weight of the top ranks in reciprocal rank fusion. 60 is
+// the value proposed by Cormack et al. and used by most search engines.
+const rrfK = 60
+
+// fusionCandidates is the minimum number of results taken from each search
+// before fusing them, so that documents ranked well by only one of the
+// searches can still make it to the top
+const fusionCandidates = 20
+
+// rankedResult is a search result after fusion
+type rankedResult struct {
+	ID           string
+	Score        float64
+	KeywordScore float64
+}
+
+// fuseRankings merges the vector and keyword rankings with weighted
+// reciprocal rank fusion: each document scores the sum over the rankings of
+// weight / (rrfK + rank)
+func fuseRankings(vectorResults []vector.SearchResult, keywordResults []bm25.SearchResult, vectorWeight, keywordWeight float64) []rankedResult {
+	byID := make(map[string]*rankedResult)
+	get := func(id string) *rankedResult {
+		r, ok := byID[id]
+		if !ok {
+			r = &rankedResult{ID: id}
+			byID[id] = r
+		}
+		return r
+	}
+
+	for rank, result := range vectorResults {
+		get(result.ID).Score += vectorWeight / float64(rrfK+rank+1)
+	}
+	for rank, result := range keywordResults {
+		r := get(result.ID)
+		r.Score += keywordWeight / float64(rrfK+rank+1)
+		r.KeywordScore = result.Score
+	}
+
+	fused := make([]rankedResult, 0, len(byID))
+	for _, r := range byID {
+		fused = append(fused, *r)
+	}
+
+	// Sort by descending score, then by ID for stable results
+	sort.Slice(fused, func(i, j int) bool {
+		if fused[i].Score != fused[j].Score {
+			return fused[i].Score > fused[j].Score
+		}
+		return fused[i].ID < fused[j].ID
+	})
+
+	return fused
+}
+
+// minSimilarity keeps the vector search results that reach minScore
+func minSimilarity(results []vector.SearchResult, minScore float64) []vector.SearchResult {
+	kept := results[:0]
+	for _, result := range results {
+		if result.Score >= minScore {
+			kept = append(kept, result)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/golvellius32/rlama/pkg/bm25"
+	"github.com/golvellius32/rlama/pkg/vector"
+)
+
+// rankedIDs returns the IDs of ranked results, in order
+func rankedIDs(results []rankedResult) []string {
+	ids := make([]string, len(results))
+	for i, result := range results {
+		ids[i] = result.ID
+	}
+	return ids
+}
+
+func TestFuseRankings(t *testing.T) {
+	vectorResults := []vector.SearchResult{{ID: "a", Score: 0.9}, {ID: "b", Score: 0.8}, {ID: "c", Score: 0.7}}
+	keywordResults := []bm25.SearchResult{{ID: "c", Score: 5}, {ID: "d", Score: 3}}
+
+	tests := []struct {
+		name                        string
+		vectorWeight, keywordWeight float64
+		want                        []string
+	}{
+		// c is ranked by both searches, d by the keyword search only
+		{"equal weights", 1, 1, []string{"c", "a", "b", "d"}},
+		{"vector only", 1, 0, []string{"a", "b", "c", "d"}},
+		{"keyword only", 0, 1, []string{"c", "d", "a", "b"}},
+		{"keyword heavy", 0.3, 1, []string{"c", "d", "a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fuseRankings(vectorResults, keywordResults, tt.vectorWeight, tt.keywordWeight)
+			if ids := rankedIDs(got); !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("order = %q, want %q", ids, tt.want)
+			}
+		})
+	}
+
+	fused := fuseRankings(vectorResults, keywordResults, 0.5, 2)
+	byID := make(map[string]rankedResult)
+	for _, r := range fused {
+		byID[r.ID] = r
+	}
+	if want := 0.5/float64(rrfK+3) + 2/float64(rrfK+1); math.Abs(byID["c"].Score-want) > 1e-12 {
+		t.Errorf("score of c = %v, want %v", byID["c"].Score, want)
+	}
+	if byID["c"].KeywordScore != 5 || byID["a"].KeywordScore != 0 {
+		t.Errorf("keyword scores = %v and %v, want 5 and 0", byID["c"].KeywordScore, byID["a"].KeywordScore)
+	}
+}
+
+func TestFuseRankingsTies(t *testing.T) {
+	// Documents with the same score are ordered by ID
+	fused := fuseRankings(
+		[]vector.SearchResult{{ID: "b", Score: 0.9}},
+		[]bm25.SearchResult{{ID: "a", Score: 1}},
+		1, 1)
+	if ids := rankedIDs(fused); !reflect.DeepEqual(ids, []string{"a", "b"}) {
+		t.Errorf("order = %q, want [a b]", ids)
+	}
+}
+
+func TestMinSimilarityKeepsKeywordHits(t *testing.T) {
+	vectorResults := []vector.SearchResult{{ID: "a", Score: 0.95}, {ID: "b", Score: 0.5}, {ID: "c", Score: 0.2}}
+	keywordResults := []bm25.SearchResult{{ID: "c", Score: 4}, {ID: "d", Score: 2}}
+
+	kept := minSimilarity(vectorResults, 0.9)
+	if len(kept) != 1 || kept[0].ID != "a" {
+		t.Fatalf("minSimilarity kept %v, want only a", kept)
+	}
+
+	// The cut applies to the vector results only: keyword hits below the
+	// minimum similarity, or without any vector result, are still fused
+	fused := fuseRankings(kept, keywordResults, 1, 1)
+	if ids := rankedIDs(fused); !reflect.DeepEqual(ids, []string{"a", "c", "d"}) {
+		t.Errorf("order = %q, want [a c d]", ids)
+	}
+	for _, r := range fused {
+		if r.ID == "b" {
+			t.Error("b is below the minimum similarity and has no keyword hit, but was fused")
+		}
+	}
+
+	if kept := minSimilarity(nil, 0.5); len(kept) != 0 {
+		t.Errorf("minSimilarity(nil) = %v", kept)
+	}
+}
@@ -0,0 +1,587 @@
+package service
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Office Open XML (.docx, .pptx, .xlsx) and OpenDocument (.odt, .ods, .odp)
+// files are zip packages of XML parts. The readers below walk those parts
+// with a streaming XML decoder and only keep the text, in document order.
+
+var slidePartPattern = regexp.MustCompile(`^ppt/slides/slide(\d+)\.xml$`)
+
+// openPackage opens a zip-based document
+func openPackage(filePath string) (*zip.ReadCloser, error) {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("not a valid document package: %w", err)
+	}
+	return r, nil
+}
+
+// findPart returns the file of a package with the given name, or nil
+func findPart(r *zip.ReadCloser, name string) *zip.File {
+	for _, f := range r.File {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// walkPart decodes an XML part and calls visit for every token
+func walkPart(f *zip.File, visit func(tok xml.Token)) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	decoder := xml.NewDecoder(rc)
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid XML in %s: %w", f.Name, err)
+		}
+		visit(tok)
+	}
+}
+
+// readPartProperties collects the text of the elements of an XML part whose
+// local name is in fields, under the property name they map to. The first
+// non-empty element wins.
+func readPartProperties(filePath, partName string, fields map[string]string) (map[string]string, error) {
+	r, err := openPackage(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	f := findPart(r, partName)
+	if f == nil {
+		return nil, fmt.Errorf("missing %s", partName)
+	}
+
+	properties := make(map[string]string)
+	var current string
+	var value strings.Builder
+	err = walkPart(f, func(tok xml.Token) {
+		switch el := tok.(type) {
+		case xml.StartElement:
+			if key, ok := fields[el.Name.Local]; ok {
+				current = key
+				value.Reset()
+			}
+		case xml.CharData:
+			if current != "" {
+				value.Write(el)
+			}
+		case xml.EndElement:
+			if current != "" && fields[el.Name.Local] == current {
+				if properties[current] == "" {
+					properties[current] = strings.TrimSpace(value.String())
+				}
+				current = ""
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return properties, nil
+}
+
+// readOOXMLProperties reads the core properties of an Office Open XML package
+func readOOXMLProperties(filePath string) (map[string]string, error) {
+	return readPartProperties(filePath, "docProps/core.xml", map[string]string{
+		"title":    "title",
+		"creator":  "author",
+		"subject":  "subject",
+		"keywords": "keywords",
+	})
+}
+
+// readODFProperties reads the metadata of an OpenDocument package. The
+// initial creator is the author; dc:creator is whoever saved it last.
+func readODFProperties(filePath string) (map[string]string, error) {
+	return readPartProperties(filePath, "meta.xml", map[string]string{
+		"title":           "title",
+		"initial-creator": "author",
+		"subject":         "subject",
+		"keyword":         "keywords",
+	})
+}
+
+// relationships reads a .rels part and maps relationship IDs to their target,
+// resolved relative to the directory of the part that owns them
+func relationships(r *zip.ReadCloser, relsName, baseDir string) (map[string]string, map[string]string, error) {
+	targets := map[string]string{}
+	types := map[string]string{}
+
+	f := findPart(r, relsName)
+	if f == nil {
+		return targets, types, nil
+	}
+
+	err := walkPart(f, func(tok xml.Token) {
+		if el, ok := tok.(xml.StartElement); ok && el.Name.Local == "Relationship" {
+			var id, target, typ string
+			for _, attr := range el.Attr {
+				switch attr.Name.Local {
+				case "Id":
+					id = attr.Value
+				case "Target":
+					target = attr.Value
+				case "Type":
+					typ = attr.Value
+				}
+			}
+			if strings.HasPrefix(target, "/") {
+				target = strings.TrimPrefix(target, "/")
+			} else {
+				target = path.Join(baseDir, target)
+			}
+			targets[id] = target
+			types[id] = typ
+		}
+	})
+	return targets, types, err
+}
+
+// textBuilder accumulates extracted text and avoids piling up blank lines
+type textBuilder struct {
+	strings.Builder
+}
+
+// newline ends the current line, keeping at most one empty line in a row
+func (b *textBuilder) newline() {
+	s := b.String()
+	if s == "" || strings.HasSuffix(s, "\n\n") {
+		return
+	}
+	b.WriteString("\n")
+}
+
+// extractFromDocx extracts paragraphs and tables from a Word document
+func extractFromDocx(filePath string) (string, error) {
+	r, err := openPackage(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	var text textBuilder
+	parts := []string{"word/document.xml", "word/footnotes.xml", "word/endnotes.xml"}
+	for _, name := range parts {
+		f := findPart(r, name)
+		if f == nil {
+			if name == "word/document.xml" {
+				return "", fmt.Errorf("missing word/document.xml")
+			}
+			continue
+		}
+
+		inText := false
+		runDepth := 0  // tabs and breaks only count inside runs, not in paragraph properties
+		cellDepth := 0 // paragraphs of a table cell stay on the row's line
+		err := walkPart(f, func(tok xml.Token) {
+			switch el := tok.(type) {
+			case xml.StartElement:
+				switch el.Name.Local {
+				case "r":
+					runDepth++
+				case "tc":
+					cellDepth++
+				case "t":
+					inText = true
+				case "tab":
+					if runDepth > 0 {
+						text.WriteString("\t")
+					}
+				case "br", "cr":
+					if runDepth > 0 {
+						text.WriteString("\n")
+					}
+				}
+			case xml.EndElement:
+				switch el.Name.Local {
+				case "r":
+					runDepth--
+				case "t":
+					inText = false
+				case "p":
+					if cellDepth > 0 {
+						text.WriteString(" ")
+					} else {
+						text.WriteString("\n")
+					}
+				case "tc":
+					cellDepth--
+					text.WriteString("\t")
+				case "tr", "tbl":
+					text.newline()
+				}
+			case xml.CharData:
+				if inText {
+					text.Write(el)
+				}
+			}
+		})
+		if err != nil {
+			return "", err
+		}
+		text.newline()
+	}
+
+	return text.String(), nil
+}
+
+// drawingMLText extracts the text of a DrawingML part (slides, notes)
+func drawingMLText(f *zip.File) (string, error) {
+	var text textBuilder
+	inText := false
+	cellDepth := 0 // paragraphs of a table cell stay on the row's line
+	err := walkPart(f, func(tok xml.Token) {
+		switch el := tok.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "tc":
+				cellDepth++
+			case "t":
+				inText = true
+			case "br":
+				text.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch el.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if cellDepth > 0 {
+					text.WriteString(" ")
+				} else {
+					text.WriteString("\n")
+				}
+			case "tc":
+				cellDepth--
+				text.WriteString("\t")
+			case "tr":
+				text.newline()
+			}
+		case xml.CharData:
+			if inText {
+				text.Write(el)
+			}
+		}
+	})
+	return strings.TrimSpace(text.String()), err
+}
+
+// extractFromPptx extracts the text and speaker notes of each slide
+func extractFromPptx(filePath string) (string, error) {
+	r, err := openPackage(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	// Slides are numbered in their part name
+	type slide struct {
+		number int
+		file   *zip.File
+	}
+	var slides []slide
+	for _, f := range r.File {
+		if m := slidePartPattern.FindStringSubmatch(f.Name); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			slides = append(slides, slide{n, f})
+		}
+	}
+	if len(slides) == 0 {
+		return "", fmt.Errorf("no slides found")
+	}
+	sort.Slice(slides, func(i, j int) bool { return slides[i].number < slides[j].number })
+
+	var text textBuilder
+	for i, s := range slides {
+		content, err := drawingMLText(s.file)
+		if err != nil {
+			return "", err
+		}
+		text.WriteString(fmt.Sprintf("Slide %d:\n%s\n", i+1, content))
+
+		// Speaker notes are linked from the slide's relationships
+		relsName := path.Join("ppt/slides/_rels", path.Base(s.file.Name)+".rels")
+		targets, types, err := relationships(r, relsName, "ppt/slides")
+		if err != nil {
+			return "", err
+		}
+		for id, typ := range types {
+			if !strings.HasSuffix(typ, "/notesSlide") {
+				continue
+			}
+			if f := findPart(r, targets[id]); f != nil {
+				notes, err := drawingMLText(f)
+				if err != nil {
+					return "", err
+				}
+				if notes != "" {
+					text.WriteString(fmt.Sprintf("Notes:\n%s\n", notes))
+				}
+			}
+		}
+		// Each slide is a page of the document
+		text.WriteString("\f")
+	}
+
+	return text.String(), nil
+}
+
+// sharedStrings reads the shared string table of a workbook
+func sharedStrings(r *zip.ReadCloser) ([]string, error) {
+	f := findPart(r, "xl/sharedStrings.xml")
+	if f == nil {
+		return nil, nil
+	}
+
+	var table []string
+	var current strings.Builder
+	inText := false
+	err := walkPart(f, func(tok xml.Token) {
+		switch el := tok.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "si":
+				current.Reset()
+			case "t":
+				inText = true
+			}
+		case xml.EndElement:
+			switch el.Name.Local {
+			case "si":
+				table = append(table, current.String())
+			case "t":
+				inText = false
+			}
+		case xml.CharData:
+			if inText {
+				current.Write(el)
+			}
+		}
+	})
+	return table, err
+}
+
+// extractFromXlsx extracts the cells of each sheet, one row per line
+func extractFromXlsx(filePath string) (string, error) {
+	r, err := openPackage(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	strs, err := sharedStrings(r)
+	if err != nil {
+		return "", err
+	}
+	targets, _, err := relationships(r, "xl/_rels/workbook.xml.rels", "xl")
+	if err != nil {
+		return "", err
+	}
+
+	// The workbook lists the sheets in display order
+	type sheet struct {
+		name string
+		part string
+	}
+	var sheets []sheet
+	workbook := findPart(r, "xl/workbook.xml")
+	if workbook == nil {
+		return "", fmt.Errorf("missing xl/workbook.xml")
+	}
+	err = walkPart(workbook, func(tok xml.Token) {
+		if el, ok := tok.(xml.StartElement); ok && el.Name.Local == "sheet" {
+			var s sheet
+			for _, attr := range el.Attr {
+				switch {
+				case attr.Name.Local == "name":
+					s.name = attr.Value
+				case attr.Name.Local == "id" && attr.Name.Space != "":
+					s.part = targets[attr.Value]
+				}
+			}
+			sheets = append(sheets, s)
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var text textBuilder
+	for _, s := range sheets {
+		f := findPart(r, s.part)
+		if f == nil {
+			continue
+		}
+		text.WriteString(fmt.Sprintf("Sheet: %s\n", s.name))
+
+		var row []string
+		var value strings.Builder
+		cellType := ""
+		inValue := false
+		err := walkPart(f, func(tok xml.Token) {
+			switch el := tok.(type) {
+			case xml.StartElement:
+				switch el.Name.Local {
+				case "row":
+					row = row[:0]
+				case "c":
+					cellType = ""
+					value.Reset()
+					for _, attr := range el.Attr {
+						if attr.Name.Local == "t" {
+							cellType = attr.Value
+						}
+					}
+				case "v", "t":
+					inValue = true
+				}
+			case xml.EndElement:
+				switch el.Name.Local {
+				case "v", "t":
+					inValue = false
+				case "c":
+					cell := value.String()
+					if cellType == "s" {
+						if i, err := strconv.Atoi(cell); err == nil && i >= 0 && i < len(strs) {
+							cell = strs[i]
+						}
+					}
+					row = append(row, cell)
+				case "row":
+					if strings.TrimSpace(strings.Join(row, "")) != "" {
+						text.WriteString(strings.Join(row, "\t"))
+						text.WriteString("\n")
+					}
+				}
+			case xml.CharData:
+				if inValue {
+					value.Write(el)
+				}
+			}
+		})
+		if err != nil {
+			return "", err
+		}
+		text.newline()
+	}
+
+	return text.String(), nil
+}
+
+// extractFromODF extracts the text of an OpenDocument text, spreadsheet or
+// presentation, including tables and presentation notes
+func extractFromODF(filePath string) (string, error) {
+	r, err := openPackage(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	f := findPart(r, "content.xml")
+	if f == nil {
+		return "", fmt.Errorf("missing content.xml")
+	}
+
+	// Sheet names are only worth keeping for spreadsheets
+	spreadsheet := false
+	if m := findPart(r, "mimetype"); m != nil {
+		if rc, err := m.Open(); err == nil {
+			mimetype, _ := io.ReadAll(rc)
+			rc.Close()
+			spreadsheet = strings.Contains(string(mimetype), "spreadsheet")
+		}
+	}
+
+	var text textBuilder
+	depth := 0     // depth inside text:p / text:h elements
+	cellDepth := 0 // paragraphs of a table cell stay on the row's line
+	pages := 0
+	err = walkPart(f, func(tok xml.Token) {
+		switch el := tok.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "p", "h":
+				depth++
+			case "tab":
+				text.WriteString("\t")
+			case "s":
+				count := 1
+				for _, attr := range el.Attr {
+					if attr.Name.Local == "c" {
+						if n, err := strconv.Atoi(attr.Value); err == nil {
+							count = n
+						}
+					}
+				}
+				text.WriteString(strings.Repeat(" ", count))
+			case "line-break":
+				text.WriteString("\n")
+			case "page":
+				// Each slide is a page of the document
+				if pages > 0 {
+					text.WriteString("\f")
+				}
+				pages++
+				text.newline()
+				text.WriteString(fmt.Sprintf("Slide %d:\n", pages))
+			case "notes":
+				text.WriteString("Notes:\n")
+			case "table-cell":
+				cellDepth++
+			case "table":
+				for _, attr := range el.Attr {
+					if attr.Name.Local == "name" && spreadsheet {
+						text.newline()
+						text.WriteString(fmt.Sprintf("Sheet: %s\n", attr.Value))
+					}
+				}
+			}
+		case xml.EndElement:
+			switch el.Name.Local {
+			case "p", "h":
+				depth--
+				if cellDepth > 0 {
+					text.WriteString(" ")
+				} else {
+					text.WriteString("\n")
+				}
+			case "table-cell":
+				cellDepth--
+				text.WriteString("\t")
+			case "table-row", "table":
+				text.newline()
+			}
+		case xml.CharData:
+			if depth > 0 {
+				text.Write(el)
+			}
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return text.String(), nil
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/golvellius32/rlama/internal/client"
+)
+
+// DefaultPromptTemplate is used by RAG systems without a prompt template of
+// their own. It renders the system message sent to the model.
+const DefaultPromptTemplate = `You are a helpful AI assistant. Use the information below to answer the user's question.
+Answer concisely based only on the information provided.
+
+Relevant information:
+
+{{.Context}}`
+
+// PromptData holds the variables available to prompt templates
+type PromptData struct {
+	// RagName is the name of the RAG system being queried
+	RagName string
+	// Question is the user's question
+	Question string
+	// Context is the retrieved passages, formatted as "--- Document: name ---" sections
+	Context string
+	// Sources is the retrieved passages, for templates that format them themselves
+	Sources []Source
+	// History is the previous exchanges of the conversation. They are also
+	// sent to the model as chat messages.
+	History []Turn
+}
+
+// PromptTemplate turns retrieved passages and a question into chat messages.
+// The template renders the system message. It may define a "user" template
+// to render the user message instead of the bare question.
+type PromptTemplate struct {
+	tmpl *template.Template
+}
+
+// ParsePromptTemplate parses a text/template prompt template
+func ParsePromptTemplate(text string) (*PromptTemplate, error) {
+	tmpl, err := template.New("prompt").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt template: %w", err)
+	}
+	return &PromptTemplate{tmpl: tmpl}, nil
+}
+
+// LoadPromptTemplateFile reads and parses a prompt template file
+func LoadPromptTemplateFile(path string) (string, *PromptTemplate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to read prompt template: %w", err)
+	}
+	tmpl, err := ParsePromptTemplate(string(data))
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return string(data), tmpl, nil
+}
+
+// Messages renders the chat messages for a question: the system message,
+// the conversation history and the user message
+func (p *PromptTemplate) Messages(data PromptData) ([]client.ChatMessage, error) {
+	if data.Context == "" {
+		data.Context = formatSources(data.Sources)
+	}
+
+	var system bytes.Buffer
+	if err := p.tmpl.Execute(&system, data); err != nil {
+		return nil, fmt.Errorf("error rendering prompt template: %w", err)
+	}
+
+	user := data.Question
+	if p.tmpl.Lookup("user") != nil {
+		var b bytes.Buffer
+		if err := p.tmpl.ExecuteTemplate(&b, "user", data); err != nil {
+			return nil, fmt.Errorf("error rendering prompt template: %w", err)
+		}
+		user = b.String()
+	}
+
+	messages := []client.ChatMessage{{Role: "system", Content: strings.TrimSpace(system.String())}}
+	for _, turn := range data.History {
+		messages = append(messages,
+			client.ChatMessage{Role: "user", Content: turn.Question},
+			client.ChatMessage{Role: "assistant", Content: truncateAnswer(turn.Answer)},
+		)
+	}
+	messages = append(messages, client.ChatMessage{Role: "user", Content: user})
+
+	return messages, nil
+}
+
+// formatSources renders retrieved passages for a prompt
+func formatSources(sources []Source) string {
+	var b strings.Builder
+	for _, source := range sources {
+		b.WriteString(fmt.Sprintf("--- Document: %s ---\n%s\n\n", source.Name, source.Content))
+	}
+	return b.String()
+}
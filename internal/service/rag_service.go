@@ -1,12 +1,19 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/golvellius32/rlama/internal/client"
 	"github.com/golvellius32/rlama/internal/domain"
 	"github.com/golvellius32/rlama/internal/repository"
+	"github.com/golvellius32/rlama/pkg/vector"
 )
 
 // RagService manages operations related to RAG systems
@@ -27,54 +34,604 @@ func NewRagService() *RagService {
 	}
 }
 
-// CreateRag creates a new RAG system
-func (rs *RagService) CreateRag(modelName, ragName, folderPath string) error {
-	// Check if Ollama is available
-	if err := rs.ollamaClient.CheckOllamaAndModel(modelName); err != nil {
+// SetEmbeddingOptions changes how the chunks of created and updated RAGs
+// are embedded
+func (rs *RagService) SetEmbeddingOptions(opts EmbeddingOptions) {
+	rs.embeddingService.SetOptions(opts)
+}
+
+// CreateRagOptions holds the optional settings of a new RAG system
+type CreateRagOptions struct {
+	// EmbeddingModel is the model used to embed chunks and queries.
+	// When empty, the generation model is used.
+	EmbeddingModel string
+	Chunking       domain.ChunkingConfig
+	// Index holds the parameters of the HNSW index used by large RAGs
+	Index vector.HNSWConfig
+	// PromptTemplate is the path of a text/template file used to build the
+	// prompt. When empty, DefaultPromptTemplate is used.
+	PromptTemplate string
+	// Retrieval holds the default search settings of the RAG's queries
+	Retrieval domain.RetrievalConfig
+	// Resume continues an interrupted indexing with its recorded settings,
+	// instead of starting a new one. The other options are ignored.
+	Resume bool
+}
+
+// CreateRag creates a new RAG system. Embeddings are saved to a staging area
+// as they are generated: if indexing fails or ctx is cancelled, it can be
+// resumed with opts.Resume. The RAG becomes visible once fully indexed.
+// A pending upload given as folderPath is moved into the RAG folder, and
+// back if the creation fails before indexing starts.
+func (rs *RagService) CreateRag(ctx context.Context, modelName, ragName, folderPath string, opts CreateRagOptions) (err error) {
+	// Folders starting with a dot hold the cache and the pending uploads
+	if strings.HasPrefix(ragName, ".") {
+		return fmt.Errorf("invalid RAG name '%s': names cannot start with a dot", ragName)
+	}
+
+	unlock, err := rs.ragRepository.Lock(ragName)
+	if err != nil {
 		return err
 	}
+	defer unlock()
 
 	// Check if the RAG already exists
 	if rs.ragRepository.Exists(ragName) {
 		return fmt.Errorf("a RAG with name '%s' already exists", ragName)
 	}
 
+	var rag *domain.RagSystem
+	if opts.Resume {
+		// The settings of the interrupted indexing apply
+		rag, err = rs.ragRepository.LoadStaging(ragName)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Resuming the indexing of RAG '%s' from folder '%s'...\n", ragName, rag.SourceFolder)
+	} else {
+		if rs.ragRepository.StagingExists(ragName) {
+			return fmt.Errorf("the indexing of RAG '%s' was interrupted; resume it with --resume or delete it with 'rlama delete %s'", ragName, ragName)
+		}
+		if rs.ragRepository.IsPendingUpload(folderPath) {
+			upload := folderPath
+			if folderPath, err = rs.ragRepository.AdoptUpload(ragName, upload); err != nil {
+				return err
+			}
+			defer func() {
+				if err != nil && !rs.ragRepository.StagingExists(ragName) {
+					os.Rename(folderPath, upload)
+				}
+			}()
+		}
+		if rag, err = rs.newRag(modelName, ragName, folderPath, opts); err != nil {
+			return err
+		}
+	}
+
+	// Check if Ollama is available
+	if err := rs.ollamaClient.CheckOllamaAndModel(ctx, rag.ModelName); err != nil {
+		return err
+	}
+	if rag.GetEmbeddingModel() != rag.ModelName {
+		if err := rs.ollamaClient.CheckOllamaAndModel(ctx, rag.GetEmbeddingModel()); err != nil {
+			return err
+		}
+	}
+
 	// Load documents
-	docs, err := rs.documentLoader.LoadDocumentsFromFolder(folderPath)
+	docs, err := rs.documentLoader.LoadDocumentsFromFolder(rag.SourceFolder)
 	if err != nil {
 		return fmt.Errorf("error loading documents: %w", err)
 	}
 
 	if len(docs) == 0 {
-		return fmt.Errorf("no valid documents found in folder %s", folderPath)
+		return fmt.Errorf("no valid documents found in folder %s", rag.SourceFolder)
 	}
 
-	fmt.Printf("Successfully loaded %d documents. Generating embeddings...\n", len(docs))
+	// Identify documents by their path, so that same-named files don't collide
+	for _, doc := range docs {
+		doc.ID = rag.DocumentID(doc)
+	}
 
-	// Create the RAG system
-	rag := domain.NewRagSystem(ragName, modelName)
+	// Split documents into chunks
+	chunks := NewChunkerService(rag.Chunking).ChunkDocuments(docs)
+	fmt.Printf("Successfully loaded %d documents (%d chunks).\n", len(docs), len(chunks))
+
+	if !opts.Resume {
+		// Record the settings so that an interrupted indexing can be resumed
+		if err := rs.ragRepository.SaveStaging(rag); err != nil {
+			return err
+		}
+	}
 
-	// Generate embeddings for all documents
-	err = rs.embeddingService.GenerateEmbeddings(docs, modelName)
+	// Reuse the embeddings of chunks that haven't changed since the interruption
+	staged, err := rs.ragRepository.LoadStagedEmbeddings(ragName)
 	if err != nil {
-		return fmt.Errorf("error generating embeddings: %w", err)
+		return err
+	}
+	var pending []*domain.DocumentChunk
+	for _, chunk := range chunks {
+		if s, ok := staged[chunk.ID]; ok && s.Hash == repository.ContentHash(chunk.Content) {
+			chunk.Embedding = s.Embedding
+			continue
+		}
+		pending = append(pending, chunk)
+	}
+	if reused := len(chunks) - len(pending); reused > 0 {
+		fmt.Printf("Reusing %d embeddings from the interrupted indexing.\n", reused)
 	}
+	fmt.Printf("Generating embeddings for %d chunks...\n", len(pending))
 
-	// Add documents to the RAG
+	// Generate embeddings for the remaining chunks, saving them as they come
+	err = rs.embeddingService.GenerateChunkEmbeddings(ctx, pending, rag.GetEmbeddingModel(), func(batch []*domain.DocumentChunk) error {
+		return rs.ragRepository.AppendStagedEmbeddings(ragName, batch)
+	})
+	if err != nil {
+		return &IndexingError{RagName: ragName, Err: fmt.Errorf("error generating embeddings: %w", err)}
+	}
+
+	// Add documents and their chunks to the RAG
 	for _, doc := range docs {
 		rag.AddDocument(doc)
 	}
+	for _, chunk := range chunks {
+		rag.AddChunk(chunk)
+	}
 
 	// Save the RAG
 	err = rs.ragRepository.Save(rag)
 	if err != nil {
-		return fmt.Errorf("error saving the RAG: %w", err)
+		return &IndexingError{RagName: ragName, Err: fmt.Errorf("error saving the RAG: %w", err)}
+	}
+	if err := rs.ragRepository.RemoveStaging(ragName); err != nil {
+		return err
 	}
 
 	fmt.Printf("RAG created with %d indexed documents.\n", len(docs))
 	return nil
 }
 
+// newRag validates the settings of a new RAG system and creates it, without
+// documents
+func (rs *RagService) newRag(modelName, ragName, folderPath string, opts CreateRagOptions) (*domain.RagSystem, error) {
+	if err := opts.Chunking.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Retrieval == (domain.RetrievalConfig{}) {
+		opts.Retrieval = domain.DefaultRetrievalConfig()
+	}
+	if err := opts.Retrieval.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Validate the prompt template before doing any work
+	var promptTemplate string
+	if opts.PromptTemplate != "" {
+		text, _, err := LoadPromptTemplateFile(opts.PromptTemplate)
+		if err != nil {
+			return nil, err
+		}
+		promptTemplate = text
+	}
+
+	// Remember the folder so that the RAG can be updated later
+	folderPath, err := filepath.Abs(folderPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid folder path: %w", err)
+	}
+
+	rag := domain.NewRagSystem(ragName, modelName, opts.EmbeddingModel)
+	rag.Chunking = opts.Chunking
+	if opts.Index != (vector.HNSWConfig{}) {
+		rag.Index = opts.Index
+	}
+	rag.SourceFolder = folderPath
+	rag.Retrieval = opts.Retrieval
+
+	// Keep a copy of the prompt template with the RAG
+	if promptTemplate != "" {
+		rag.PromptTemplate, err = rs.ragRepository.SavePromptTemplate(ragName, promptTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rag, nil
+}
+
+// UpstreamError is returned by queries when Ollama or the reranking endpoint
+// fails, rather than the query or the RAG
+type UpstreamError struct {
+	Err error
+}
+
+func (e *UpstreamError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// ErrIncompatibleEmbeddings is returned by queries on a RAG whose vectors
+// don't have the dimension of those of its embedding model
+var ErrIncompatibleEmbeddings = errors.New("incompatible embeddings")
+
+// IndexingError is returned by CreateRag when indexing stops after it has
+// started. The embeddings generated so far are kept and indexing can be
+// resumed.
+type IndexingError struct {
+	RagName string
+	Err     error
+}
+
+func (e *IndexingError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *IndexingError) Unwrap() error {
+	return e.Err
+}
+
+// UpdateReport lists the files affected by an incremental update
+type UpdateReport struct {
+	Added     []string
+	Updated   []string
+	Removed   []string
+	Unchanged int
+	// Migration describes the document IDs migrated when the RAG was loaded
+	Migration *domain.IDMigration
+}
+
+// HasChanges reports whether the update modified the RAG
+func (r *UpdateReport) HasChanges() bool {
+	return len(r.Added) > 0 || len(r.Updated) > 0 || len(r.Removed) > 0
+}
+
+// UpdateRag re-indexes the files of the RAG's source folder that were added,
+// modified or deleted since the last indexing, and the files added from
+// elsewhere with AddDocuments. If folderPath is not empty it replaces the
+// recorded source folder, and only its files are kept. Cancelling ctx stops the generation of
+// embeddings, and the RAG is left unchanged.
+func (rs *RagService) UpdateRag(ctx context.Context, ragName, folderPath string) (*UpdateReport, error) {
+	unlock, err := rs.ragRepository.Lock(ragName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rag, err := rs.LoadRag(ragName)
+	if err != nil {
+		return nil, err
+	}
+	defer rag.Close()
+
+	if folderPath != "" {
+		if rag.SourceFolder, err = filepath.Abs(folderPath); err != nil {
+			return nil, fmt.Errorf("invalid folder path: %w", err)
+		}
+	}
+	if rag.SourceFolder == "" {
+		return nil, fmt.Errorf("RAG '%s' has no recorded source folder; specify it with --folder", ragName)
+	}
+	if info, err := os.Stat(rag.SourceFolder); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("source folder '%s' of RAG '%s' is not accessible", rag.SourceFolder, ragName)
+	}
+
+	// RAGs created before chunking was configurable use the default settings
+	if rag.Chunking.ChunkSize == 0 {
+		rag.Chunking = domain.DefaultChunkingConfig()
+	}
+
+	if err := rs.ollamaClient.CheckOllamaAndModel(ctx, rag.GetEmbeddingModel()); err != nil {
+		return nil, err
+	}
+
+	files, _, err := rs.documentLoader.ListFiles(rag.SourceFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	// Documents added from outside the source folder with add-docs stay as
+	// long as their file exists, unless the source folder is replaced
+	seen := make(map[string]bool, len(files))
+	for _, path := range files {
+		seen[path] = true
+	}
+	for _, doc := range rag.Documents {
+		if folderPath != "" || seen[doc.Path] || rag.InSourceFolder(doc.Path) {
+			continue
+		}
+		if info, err := os.Stat(doc.Path); err == nil && !info.IsDir() {
+			files = append(files, doc.Path)
+			seen[doc.Path] = true
+		}
+	}
+
+	// Forget the documents whose file no longer exists
+	report := &UpdateReport{Migration: rag.Migration}
+	for _, doc := range append([]*domain.Document(nil), rag.Documents...) {
+		if !seen[doc.Path] {
+			rag.RemoveDocument(doc.ID)
+			report.Removed = append(report.Removed, doc.Path)
+		}
+	}
+
+	if err := rs.indexFiles(ctx, rag, files, report); err != nil {
+		return nil, err
+	}
+
+	// Save even without changes to keep the refreshed modification times
+	if err := rs.ragRepository.Save(rag); err != nil {
+		return nil, fmt.Errorf("error saving the RAG: %w", err)
+	}
+
+	return report, nil
+}
+
+// indexFiles indexes the files that are new to the RAG and re-indexes those
+// that changed since they were indexed, recording them in report. On error,
+// the RAG must not be saved.
+func (rs *RagService) indexFiles(ctx context.Context, rag *domain.RagSystem, files []string, report *UpdateReport) error {
+	existing := make(map[string]*domain.Document, len(rag.Documents))
+	for _, doc := range rag.Documents {
+		existing[doc.Path] = doc
+	}
+
+	var changed []*domain.Document
+	for _, path := range files {
+		doc, ok := existing[path]
+		if ok {
+			info, err := os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("unable to access %s: %w", path, err)
+			}
+			if info.ModTime().Equal(doc.SourceModTime) && info.Size() == doc.SourceSize {
+				report.Unchanged++
+				continue
+			}
+
+			// The file was touched: compare its content before re-embedding it
+			hash, err := HashFile(path)
+			if err != nil {
+				return err
+			}
+			if hash == doc.SourceHash {
+				doc.SourceModTime = info.ModTime()
+				doc.SourceSize = info.Size()
+				report.Unchanged++
+				continue
+			}
+		}
+
+		newDoc, err := rs.documentLoader.LoadDocument(path)
+		if err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			continue
+		}
+		newDoc.ID = rag.DocumentID(newDoc)
+
+		if ok {
+			rag.RemoveDocument(doc.ID)
+			report.Updated = append(report.Updated, path)
+		} else {
+			report.Added = append(report.Added, path)
+		}
+		changed = append(changed, newDoc)
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	chunks := NewChunkerService(rag.Chunking).ChunkDocuments(changed)
+	fmt.Printf("Generating embeddings for %d documents (%d chunks)...\n", len(changed), len(chunks))
+
+	if err := rs.embeddingService.GenerateChunkEmbeddings(ctx, chunks, rag.GetEmbeddingModel(), nil); err != nil {
+		return fmt.Errorf("error generating embeddings: %w", err)
+	}
+
+	for _, doc := range changed {
+		rag.AddDocument(doc)
+	}
+	for _, chunk := range chunks {
+		rag.AddChunk(chunk)
+	}
+	return nil
+}
+
+// AddDocuments indexes files into an existing RAG. Folders are walked for
+// supported files. Files that are already indexed are re-indexed if they
+// changed. Cancelling ctx stops the generation of embeddings, and the RAG is
+// left unchanged. Pending uploads are moved into the RAG folder, and back if
+// adding fails. They are deleted if none of their files is indexed.
+func (rs *RagService) AddDocuments(ctx context.Context, ragName string, paths []string) (report *UpdateReport, err error) {
+	unlock, err := rs.ragRepository.Lock(ragName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rag, err := rs.LoadRag(ragName)
+	if err != nil {
+		return nil, err
+	}
+	defer rag.Close()
+
+	var files, adoptedUploads []string
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if rs.ragRepository.IsPendingUpload(path) {
+			upload := path
+			if path, err = rs.ragRepository.AdoptUpload(ragName, upload); err != nil {
+				return nil, err
+			}
+			adopted := path
+			adoptedUploads = append(adoptedUploads, adopted)
+			defer func() {
+				if err != nil {
+					os.Rename(adopted, upload)
+				}
+			}()
+		}
+
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path: %w", err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to access %s: %w", path, err)
+		}
+
+		found := []string{path}
+		if info.IsDir() {
+			if found, _, err = rs.documentLoader.ListFiles(path); err != nil {
+				return nil, err
+			}
+		} else if !rs.documentLoader.Registry().Supports(strings.ToLower(filepath.Ext(path))) {
+			return nil, fmt.Errorf("unsupported file type: %s", path)
+		}
+
+		for _, file := range found {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no supported files found")
+	}
+
+	// RAGs created before chunking was configurable use the default settings
+	if rag.Chunking.ChunkSize == 0 {
+		rag.Chunking = domain.DefaultChunkingConfig()
+	}
+
+	if err := rs.ollamaClient.CheckOllamaAndModel(ctx, rag.GetEmbeddingModel()); err != nil {
+		return nil, err
+	}
+
+	report = &UpdateReport{Migration: rag.Migration}
+	if err := rs.indexFiles(ctx, rag, files, report); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err == nil {
+			removeUnindexed(adoptedUploads, report)
+		}
+	}()
+	if !report.HasChanges() {
+		return report, nil
+	}
+
+	if err := rs.ragRepository.Save(rag); err != nil {
+		return nil, fmt.Errorf("error saving the RAG: %w", err)
+	}
+	return report, nil
+}
+
+// removeUnindexed deletes the adopted uploads from which no document was
+// added or updated: nothing in the RAG refers to them
+func removeUnindexed(uploads []string, report *UpdateReport) {
+	for _, upload := range uploads {
+		used := false
+		for _, paths := range [][]string{report.Added, report.Updated} {
+			for _, path := range paths {
+				if path == upload || strings.HasPrefix(path, upload+string(filepath.Separator)) {
+					used = true
+				}
+			}
+		}
+		if !used {
+			os.RemoveAll(upload)
+		}
+	}
+}
+
+// RemoveDocuments removes from a RAG the documents matching pattern, a
+// document ID or a glob on their path (see domain.RagSystem.FindDocuments),
+// and returns them
+func (rs *RagService) RemoveDocuments(ragName, pattern string) ([]*domain.Document, error) {
+	unlock, err := rs.ragRepository.Lock(ragName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rag, err := rs.LoadRag(ragName)
+	if err != nil {
+		return nil, err
+	}
+	defer rag.Close()
+
+	docs, err := rag.FindDocuments(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("%w in RAG '%s' for '%s'", ErrNoMatchingDocument, ragName, pattern)
+	}
+
+	for _, doc := range docs {
+		rag.RemoveDocument(doc.ID)
+	}
+	if err := rs.ragRepository.Save(rag); err != nil {
+		return nil, fmt.Errorf("error saving the RAG: %w", err)
+	}
+	return docs, nil
+}
+
+// ErrNoMatchingDocument is returned by RemoveDocuments when no document
+// matches the pattern
+var ErrNoMatchingDocument = errors.New("no matching document")
+
+// DocumentInfo describes an indexed document, without its content
+type DocumentInfo struct {
+	ID          string    `json:"id"`
+	Path        string    `json:"path"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Chunks      int       `json:"chunks"`
+	CreatedAt   time.Time `json:"created_at"`
+	// ModifiedAt is the modification time of the file when it was indexed
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// ListDocuments describes the documents of a RAG, sorted by ID
+func (rs *RagService) ListDocuments(ragName string) ([]DocumentInfo, error) {
+	rag, err := rs.LoadRag(ragName)
+	if err != nil {
+		return nil, err
+	}
+	defer rag.Close()
+
+	chunks := make(map[string]int)
+	for _, chunk := range rag.Chunks {
+		chunks[chunk.DocumentID]++
+	}
+
+	docs := make([]DocumentInfo, 0, len(rag.Documents))
+	for _, doc := range rag.Documents {
+		docs = append(docs, DocumentInfo{
+			ID:          doc.ID,
+			Path:        doc.Path,
+			Name:        doc.Name,
+			ContentType: doc.ContentType,
+			Size:        doc.Size,
+			Chunks:      chunks[doc.ID],
+			CreatedAt:   doc.CreatedAt,
+			ModifiedAt:  doc.SourceModTime,
+		})
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].ID < docs[j].ID
+	})
+	return docs, nil
+}
+
 // LoadRag loads a RAG system
 func (rs *RagService) LoadRag(ragName string) (*domain.RagSystem, error) {
 	rag, err := rs.ragRepository.Load(ragName)
@@ -85,52 +642,260 @@ func (rs *RagService) LoadRag(ragName string) (*domain.RagSystem, error) {
 	return rag, nil
 }
 
-// Query performs a query on a RAG system
-func (rs *RagService) Query(rag *domain.RagSystem, query string) (string, error) {
-	// Check if Ollama is available
-	if err := rs.ollamaClient.CheckOllamaAndModel(rag.ModelName); err != nil {
-		return "", err
+// Source is a piece of a document retrieved to answer a query
+type Source struct {
+	ChunkID    string `json:"chunk_id,omitempty"`
+	DocumentID string `json:"document_id"`
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	// Score ranks the sources: the cosine similarity for vector search only,
+	// the reciprocal rank fusion score for hybrid search
+	Score float64 `json:"score"`
+	// Similarity is the cosine similarity between the query and the source
+	Similarity float64 `json:"similarity"`
+	// KeywordScore is the BM25 score of the source, 0 if no keyword matched
+	KeywordScore float64 `json:"keyword_score"`
+	// RerankScore is the relevance given by the reranker, if the RAG uses one.
+	// Reranked sources are sorted by this score.
+	RerankScore *float64 `json:"rerank_score,omitempty"`
+	// Page is the page where the source starts, 0 when unknown
+	Page        int    `json:"page,omitempty"`
+	StartOffset int    `json:"start_offset"`
+	EndOffset   int    `json:"end_offset"`
+	Content     string `json:"content"`
+}
+
+// QueryResult is the answer to a query along with the sources it is based on
+type QueryResult struct {
+	Answer string `json:"answer"`
+	// RetrievalQuery is the query used to search the documents. It differs
+	// from the question when a follow-up question was rewritten.
+	RetrievalQuery string   `json:"retrieval_query,omitempty"`
+	Sources        []Source `json:"sources"`
+}
+
+// Query performs a query on a RAG system. Use rag.GetRetrievalConfig() as
+// retrieval to search with the RAG's own settings.
+func (rs *RagService) Query(ctx context.Context, rag *domain.RagSystem, query string, retrieval domain.RetrievalConfig) (*QueryResult, error) {
+	messages, sources, err := rs.buildMessages(ctx, rag, query, query, nil, retrieval)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate the response
+	response, err := rs.ollamaClient.Chat(ctx, rag.ModelName, messages)
+	if err != nil {
+		return nil, &UpstreamError{Err: fmt.Errorf("error generating response: %w", err)}
+	}
+
+	return &QueryResult{Answer: response, Sources: sources}, nil
+}
+
+// QueryStream performs a query on a RAG system and calls onToken for each
+// piece of the answer as soon as the model produces it. On error, the result
+// holds the part of the answer generated so far.
+func (rs *RagService) QueryStream(ctx context.Context, rag *domain.RagSystem, query string, retrieval domain.RetrievalConfig, onToken client.TokenCallback) (*QueryResult, error) {
+	return rs.queryStream(ctx, rag, query, query, nil, retrieval, onToken)
+}
+
+// queryStream retrieves the chunks matching retrievalQuery and streams the
+// answer to question, given the previous turns of the conversation
+func (rs *RagService) queryStream(ctx context.Context, rag *domain.RagSystem, question, retrievalQuery string, history []Turn, retrieval domain.RetrievalConfig, onToken client.TokenCallback) (*QueryResult, error) {
+	messages, sources, err := rs.buildMessages(ctx, rag, question, retrievalQuery, history, retrieval)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate the response
+	response, err := rs.ollamaClient.ChatStream(ctx, rag.ModelName, messages, onToken)
+	result := &QueryResult{Answer: response, Sources: sources}
+	if retrievalQuery != question {
+		result.RetrievalQuery = retrievalQuery
+	}
+	if err != nil {
+		return result, &UpstreamError{Err: fmt.Errorf("error generating response: %w", err)}
+	}
+
+	return result, nil
+}
+
+// retrieve searches the chunks most similar to the query, fusing vector and
+// keyword (BM25) rankings, optionally reranks them and packs them into the
+// context token budget. The minimum similarity applies to the vector ranking
+// only: passages found by their keywords are kept whatever their similarity.
+func (rs *RagService) retrieve(ctx context.Context, rag *domain.RagSystem, query string, retrieval domain.RetrievalConfig) ([]Source, error) {
+	if err := retrieval.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Generate embedding for the query with the model used at indexing time
+	queryEmbedding, err := rs.embeddingService.GenerateQueryEmbedding(ctx, query, rag.GetEmbeddingModel())
+	if err != nil {
+		return nil, &UpstreamError{Err: fmt.Errorf("error generating embedding for query: %w", err)}
+	}
+
+	// Refuse to compare vectors coming from incompatible embedders
+	if dim := rag.VectorStore.Dimension(); dim != 0 && dim != len(queryEmbedding) {
+		return nil, fmt.Errorf("%w: embedding model '%s' returned %d-dimensional vectors but RAG '%s' stores %d-dimensional vectors; "+
+			"the RAG must be re-created with the current embedding model", ErrIncompatibleEmbeddings, rag.GetEmbeddingModel(), len(queryEmbedding), rag.Name, dim)
+	}
+
+	reranker, err := NewReranker(retrieval.Rerank, rs.ollamaClient, rag.ModelName)
+	if err != nil {
+		return nil, err
 	}
 
-	// Generate embedding for the query
-	queryEmbedding, err := rs.embeddingService.GenerateQueryEmbedding(query, rag.ModelName)
+	// The filter restricts both searches before their results are limited
+	filter, err := vector.ParseFilter(retrieval.Filter)
 	if err != nil {
-		return "", fmt.Errorf("error generating embedding for query: %w", err)
+		return nil, err
+	}
+	var keywordFilter func(id string) bool
+	if filter != nil {
+		keywordFilter = func(id string) bool {
+			metadata, _ := rag.VectorStore.Metadata(id)
+			return filter(metadata)
+		}
+	}
+
+	// With a reranker, more candidates are retrieved than finally kept
+	limit := retrieval.TopK
+	if reranker != nil && retrieval.Rerank.Candidates > limit {
+		limit = retrieval.Rerank.Candidates
+	}
+
+	var ranked []rankedResult
+	hybrid := retrieval.KeywordWeight > 0 && rag.KeywordIndex != nil && rag.KeywordIndex.Len() > 0
+	if hybrid {
+		candidates := limit * 2
+		if candidates < fusionCandidates {
+			candidates = fusionCandidates
+		}
+		var vectorResults []vector.SearchResult
+		if retrieval.VectorWeight > 0 {
+			vectorResults = minSimilarity(rag.VectorStore.SearchFiltered(queryEmbedding, candidates, filter), retrieval.MinScore)
+		}
+		keywordResults := rag.KeywordIndex.SearchFiltered(query, candidates, keywordFilter)
+		ranked = fuseRankings(vectorResults, keywordResults, retrieval.VectorWeight, retrieval.KeywordWeight)
+	} else {
+		for _, result := range minSimilarity(rag.VectorStore.SearchFiltered(queryEmbedding, limit, filter), retrieval.MinScore) {
+			ranked = append(ranked, rankedResult{ID: result.ID, Score: result.Score})
+		}
 	}
 
-	// Search for the most relevant documents
-	results := rag.VectorStore.Search(queryEmbedding, 3) // Top 3 documents
+	var sources []Source
+	for _, result := range ranked {
+		if len(sources) == limit {
+			break
+		}
+
+		// Zero for keyword hits whose vector is missing
+		similarity, _ := rag.VectorStore.Similarity(queryEmbedding, result.ID)
 
-	// Build the context
-	var context strings.Builder
-	context.WriteString("Relevant information:\n\n")
+		if chunk := rag.GetChunkByID(result.ID); chunk != nil {
+			content, err := rag.ChunkText(chunk)
+			if err != nil {
+				return nil, err
+			}
+			source := Source{
+				ChunkID:      chunk.ID,
+				DocumentID:   chunk.DocumentID,
+				Name:         chunk.DocumentID,
+				Score:        result.Score,
+				Similarity:   similarity,
+				KeywordScore: result.KeywordScore,
+				StartOffset:  chunk.StartOffset,
+				EndOffset:    chunk.EndOffset,
+				Content:      content,
+			}
+			if doc := rag.GetDocumentByID(chunk.DocumentID); doc != nil {
+				source.Name = doc.Name
+				source.Path = doc.Path
+				source.Page = doc.PageAt(chunk.StartOffset)
+			}
+			sources = append(sources, source)
+			continue
+		}
 
-	for _, result := range results {
+		// RAGs created before chunking index whole documents
 		doc := rag.GetDocumentByID(result.ID)
 		if doc != nil {
-			// Limit content size to avoid prompts that are too long
-			content := doc.Content
-			if len(content) > 1000 {
-				content = content[:1000] + "..."
+			content, err := rag.DocumentText(doc)
+			if err != nil {
+				return nil, err
 			}
-			context.WriteString(fmt.Sprintf("--- Document: %s ---\n%s\n\n", doc.Name, content))
+			sources = append(sources, Source{
+				DocumentID:   doc.ID,
+				Name:         doc.Name,
+				Path:         doc.Path,
+				Score:        result.Score,
+				Similarity:   similarity,
+				KeywordScore: result.KeywordScore,
+				StartOffset:  0,
+				EndOffset:    len(content),
+				Content:      content,
+			})
 		}
 	}
 
-	// Build the prompt
-	prompt := fmt.Sprintf(`You are a helpful AI assistant. Use the information below to answer the question.
+	if reranker != nil && len(sources) > 0 {
+		scores, err := reranker.Rerank(ctx, query, sources)
+		if err != nil {
+			return nil, &UpstreamError{Err: fmt.Errorf("error reranking with %s: %w", reranker.Name(), err)}
+		}
+		for i := range sources {
+			score := scores[i]
+			sources[i].RerankScore = &score
+		}
+		sort.SliceStable(sources, func(i, j int) bool {
+			return *sources[i].RerankScore > *sources[j].RerankScore
+		})
+		if len(sources) > retrieval.TopK {
+			sources = sources[:retrieval.TopK]
+		}
+	}
+
+	// Limit the context size to avoid prompts that are too long
+	return packSources(sources, retrieval.ContextTokens), nil
+}
+
+// promptTemplate returns the prompt template of a RAG system
+func (rs *RagService) promptTemplate(rag *domain.RagSystem) (*PromptTemplate, error) {
+	if rag.PromptTemplate == "" {
+		return ParsePromptTemplate(DefaultPromptTemplate)
+	}
+	_, tmpl, err := LoadPromptTemplateFile(rs.ragRepository.PromptTemplatePath(rag))
+	return tmpl, err
+}
 
-%s
+// buildMessages retrieves the chunks relevant to retrievalQuery and builds the
+// chat messages sent to the model to answer question, after the given history
+func (rs *RagService) buildMessages(ctx context.Context, rag *domain.RagSystem, question, retrievalQuery string, history []Turn, retrieval domain.RetrievalConfig) ([]client.ChatMessage, []Source, error) {
+	// Check if Ollama is available
+	if err := rs.ollamaClient.CheckOllamaAndModel(ctx, rag.ModelName); err != nil {
+		return nil, nil, &UpstreamError{Err: err}
+	}
 
-Question: %s
+	tmpl, err := rs.promptTemplate(rag)
+	if err != nil {
+		return nil, nil, err
+	}
 
-Answer concisely based only on the information provided above:`, context.String(), query)
+	// Search for the most relevant chunks
+	sources, err := rs.retrieve(ctx, rag, retrievalQuery, retrieval)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// Generate the response
-	response, err := rs.ollamaClient.GenerateCompletion(rag.ModelName, prompt)
+	messages, err := tmpl.Messages(PromptData{
+		RagName:  rag.Name,
+		Question: question,
+		Sources:  sources,
+		History:  history,
+	})
 	if err != nil {
-		return "", fmt.Errorf("error generating response: %w", err)
+		return nil, nil, err
 	}
 
-	return response, nil
+	return messages, sources, nil
 }
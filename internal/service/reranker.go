@@ -0,0 +1,175 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golvellius32/rlama/internal/client"
+	"github.com/golvellius32/rlama/internal/domain"
+)
+
+// Reranker rescores the passages retrieved for a query. Rerankers read the
+// whole query and passage together, which makes them slower but more
+// accurate than comparing embeddings.
+type Reranker interface {
+	// Name identifies the reranker
+	Name() string
+	// Rerank returns the relevance score of each source, in the same order
+	Rerank(ctx context.Context, query string, sources []Source) ([]float64, error)
+}
+
+// NewReranker creates the reranker described by config. model is the
+// generation model of the RAG, used by the LLM reranker when config doesn't
+// name one. It returns nil when reranking is disabled.
+func NewReranker(config domain.RerankConfig, ollamaClient *client.OllamaClient, model string) (Reranker, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch config.Reranker {
+	case domain.RerankerLLM:
+		if config.Model != "" {
+			model = config.Model
+		}
+		return &llmReranker{client: ollamaClient, model: model}, nil
+	case domain.RerankerCrossEncoder:
+		return &crossEncoderReranker{
+			url:    config.URL,
+			client: &http.Client{Timeout: 60 * time.Second},
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// llmReranker asks an Ollama model to grade the relevance of each passage
+type llmReranker struct {
+	client *client.OllamaClient
+	model  string
+}
+
+// gradePattern finds the grade in the model's reply
+var gradePattern = regexp.MustCompile(`\d+(?:\.\d+)?`)
+
+func (r *llmReranker) Name() string { return domain.RerankerLLM }
+
+// Rerank grades each passage from 0 to 10 and returns the grades scaled to [0, 1]
+func (r *llmReranker) Rerank(ctx context.Context, query string, sources []Source) ([]float64, error) {
+	scores := make([]float64, len(sources))
+	for i, source := range sources {
+		messages := []client.ChatMessage{
+			{Role: "system", Content: "You grade how relevant a passage is to a question. " +
+				"Reply with a single number from 0 (irrelevant) to 10 (answers the question), and nothing else."},
+			{Role: "user", Content: fmt.Sprintf("Question: %s\n\nPassage:\n%s\n\nRelevance (0-10):", query, source.Content)},
+		}
+
+		reply, err := r.client.Chat(ctx, r.model, messages)
+		if err != nil {
+			return nil, fmt.Errorf("error grading passage %d: %w", i+1, err)
+		}
+
+		// A reply without a grade ranks the passage last
+		grade, err := strconv.ParseFloat(gradePattern.FindString(reply), 64)
+		if err != nil {
+			grade = 0
+		}
+		if grade > 10 {
+			grade = 10
+		}
+		scores[i] = grade / 10
+	}
+	return scores, nil
+}
+
+// crossEncoderReranker scores passages with a cross-encoder served over HTTP,
+// such as text-embeddings-inference, Infinity or llama.cpp's server
+type crossEncoderReranker struct {
+	url    string
+	client *http.Client
+}
+
+// crossEncoderRequest is the body sent to the cross-encoder. The passages are
+// sent as both "texts" (text-embeddings-inference) and "documents" (Cohere
+// style APIs) so that the common servers accept it.
+type crossEncoderRequest struct {
+	Query     string   `json:"query"`
+	Texts     []string `json:"texts"`
+	Documents []string `json:"documents"`
+}
+
+// crossEncoderScore is the score of one passage, as returned by
+// text-embeddings-inference (score) or Cohere style APIs (relevance_score)
+type crossEncoderScore struct {
+	Index          int      `json:"index"`
+	Score          *float64 `json:"score"`
+	RelevanceScore *float64 `json:"relevance_score"`
+}
+
+func (r *crossEncoderReranker) Name() string { return domain.RerankerCrossEncoder }
+
+// Rerank sends all the passages in a single request
+func (r *crossEncoderReranker) Rerank(ctx context.Context, query string, sources []Source) ([]float64, error) {
+	texts := make([]string, len(sources))
+	for i, source := range sources {
+		texts[i] = source.Content
+	}
+
+	reqJSON, err := json.Marshal(crossEncoderRequest{Query: query, Texts: texts, Documents: texts})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewBuffer(reqJSON))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cross-encoder is not accessible: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cross-encoder responded with error code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	// The scores come either as a bare list or in a "results" field
+	var results []crossEncoderScore
+	if err := json.Unmarshal(body, &results); err != nil {
+		var wrapped struct {
+			Results []crossEncoderScore `json:"results"`
+		}
+		if err := json.Unmarshal(body, &wrapped); err != nil {
+			return nil, fmt.Errorf("invalid cross-encoder response: %w", err)
+		}
+		results = wrapped.Results
+	}
+
+	scores := make([]float64, len(sources))
+	for _, result := range results {
+		if result.Index < 0 || result.Index >= len(scores) {
+			return nil, fmt.Errorf("invalid cross-encoder response: unknown passage %d", result.Index)
+		}
+		switch {
+		case result.Score != nil:
+			scores[result.Index] = *result.Score
+		case result.RelevanceScore != nil:
+			scores[result.Index] = *result.RelevanceScore
+		}
+	}
+	return scores, nil
+}
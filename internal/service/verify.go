@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/golvellius32/rlama/internal/domain"
+)
+
+// VerifyReport describes the problems found in a RAG system by VerifyRag
+type VerifyReport struct {
+	*domain.Inconsistencies
+	// TempFiles are the temporary files left by interrupted saves
+	TempFiles []string
+	// StaleStaging is set when the staging folder of a RAG that was fully
+	// created is still there
+	StaleStaging bool
+	// Reembedded is the number of chunks embedded again by the repair
+	Reembedded int
+	// Repaired is set once the problems have been repaired
+	Repaired bool
+	// Migration describes the document IDs of a RAG created by a previous
+	// version, migrated when it was loaded. The repair saves it.
+	Migration *domain.IDMigration
+}
+
+// Count returns the number of problems found
+func (r *VerifyReport) Count() int {
+	count := r.Inconsistencies.Count() + len(r.TempFiles)
+	if r.StaleStaging {
+		count++
+	}
+	return count
+}
+
+// VerifyRag checks that the documents, chunks, vectors and keyword index of
+// a RAG system match, and that no save was interrupted. With repair, orphan
+// chunks and vectors are removed, missing vectors are embedded again, and
+// the RAG is saved so that all its files match, with its migrated IDs.
+func (rs *RagService) VerifyRag(ctx context.Context, ragName string, repair bool) (*VerifyReport, error) {
+	unlock, err := rs.ragRepository.Lock(ragName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	rag, err := rs.ragRepository.LoadForRepair(ragName)
+	if err != nil {
+		return nil, fmt.Errorf("error loading RAG '%s': %w", ragName, err)
+	}
+	defer rag.Close()
+
+	report := &VerifyReport{
+		Inconsistencies: rag.Verify(),
+		StaleStaging:    rs.ragRepository.StagingExists(ragName),
+		Migration:       rag.Migration,
+	}
+	if report.TempFiles, err = rs.ragRepository.TempFiles(ragName); err != nil {
+		return nil, err
+	}
+	if !repair || (report.Count() == 0 && report.Migration == nil) {
+		return report, nil
+	}
+
+	missing, err := rag.Repair(report.Inconsistencies)
+	if err != nil {
+		return nil, err
+	}
+	if err := rs.embedMissing(ctx, rag, missing); err != nil {
+		return nil, err
+	}
+	report.Reembedded = len(missing)
+
+	if err := rs.ragRepository.Save(rag); err != nil {
+		return nil, fmt.Errorf("error saving the RAG: %w", err)
+	}
+	if err := rs.ragRepository.RemoveTempFiles(ragName); err != nil {
+		return nil, fmt.Errorf("unable to remove temporary files: %w", err)
+	}
+	if report.StaleStaging {
+		if err := rs.ragRepository.RemoveStaging(ragName); err != nil {
+			return nil, err
+		}
+	}
+	report.Repaired = true
+	return report, nil
+}
+
+// embedMissing computes the vectors of the chunks, or of the documents of
+// RAGs without chunks, listed by ids
+func (rs *RagService) embedMissing(ctx context.Context, rag *domain.RagSystem, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	var chunks []*domain.DocumentChunk
+	for _, chunk := range rag.Chunks {
+		if wanted[chunk.ID] {
+			text, err := rag.ChunkText(chunk)
+			if err != nil {
+				return err
+			}
+			chunk.Content = text
+			chunks = append(chunks, chunk)
+		}
+	}
+	var docs []*domain.Document
+	for _, doc := range rag.Documents {
+		if wanted[doc.ID] {
+			text, err := rag.DocumentText(doc)
+			if err != nil {
+				return err
+			}
+			doc.Content = text
+			docs = append(docs, doc)
+		}
+	}
+
+	// Documents of a chunked RAG that lost their chunks, such as those whose
+	// IDs collided before the migration, are chunked again
+	var rechunked []*domain.DocumentChunk
+	if rag.IsChunked() && len(docs) > 0 {
+		if rag.Chunking.ChunkSize == 0 {
+			rag.Chunking = domain.DefaultChunkingConfig()
+		}
+		rechunked = NewChunkerService(rag.Chunking).ChunkDocuments(docs)
+		chunks = append(chunks, rechunked...)
+		docs = nil
+	}
+
+	model := rag.GetEmbeddingModel()
+	if err := rs.ollamaClient.CheckOllamaAndModel(ctx, model); err != nil {
+		return err
+	}
+	if err := rs.embeddingService.GenerateChunkEmbeddings(ctx, chunks, model, nil); err != nil {
+		return err
+	}
+	if err := rs.embeddingService.GenerateEmbeddings(ctx, docs, model); err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks[:len(chunks)-len(rechunked)] {
+		rag.VectorStore.AddWithMetadata(chunk.ID, chunk.Embedding, rag.ChunkMetadata(chunk, rag.GetDocumentByID(chunk.DocumentID)))
+	}
+	for _, chunk := range rechunked {
+		rag.AddChunk(chunk)
+	}
+	for _, doc := range docs {
+		rag.VectorStore.AddWithMetadata(doc.ID, doc.Embedding, rag.DocumentMetadata(doc))
+	}
+
+	// A RAG left without chunks indexed the keywords of its documents
+	if len(rechunked) > 0 {
+		return rag.BuildKeywordIndex()
+	}
+	return nil
+}
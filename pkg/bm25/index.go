@@ -0,0 +1,333 @@
+package bm25
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Okapi BM25 parameters
+const (
+	DefaultK1 = 1.2
+	DefaultB  = 0.75
+)
+
+// termPattern matches words and identifiers such as error codes
+// (E1234, ERR-404), function names (get_user, pkg.Func) or ticket numbers
+// (PROJ-123). Their parts are indexed as well, see Tokenize.
+var termPattern = regexp.MustCompile(`[\p{L}\p{N}_]+(?:[-.:/#][\p{L}\p{N}_]+)*`)
+
+// partPattern splits a compound identifier into its parts
+var partPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// Tokenize returns the lowercase terms of a text. Compound identifiers are
+// returned whole and followed by their parts, so that both "ERR-404" and
+// "404" match them.
+func Tokenize(text string) []string {
+	var terms []string
+	for _, term := range termPattern.FindAllString(strings.ToLower(text), -1) {
+		terms = append(terms, term)
+		parts := partPattern.FindAllString(term, -1)
+		if len(parts) > 1 || (len(parts) == 1 && parts[0] != term) {
+			terms = append(terms, parts...)
+		}
+	}
+	return terms
+}
+
+// posting records the number of occurrences of a term in a document
+type posting struct {
+	Doc  int `json:"d"`
+	Freq int `json:"f"`
+}
+
+// document is an indexed document; an empty ID marks a removed document
+type document struct {
+	ID     string `json:"id"`
+	Length int    `json:"len"`
+	// terms lists the distinct terms of the document, so that Remove only
+	// touches their postings
+	terms []string
+}
+
+// SearchResult represents a search result
+type SearchResult struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// Index is an inverted index that scores documents with Okapi BM25.
+// It is not safe for concurrent use.
+type Index struct {
+	K1 float64
+	B  float64
+	// Generation identifies the save that wrote the index file, so that it
+	// can be matched with the other files written by the same save
+	Generation uint64
+
+	docs     []document
+	postings map[string][]posting
+	// positions maps IDs to their position in docs
+	positions   map[string]int
+	totalLength int
+	count       int
+}
+
+// NewIndex creates an empty index with the default parameters
+func NewIndex() *Index {
+	return &Index{
+		K1:        DefaultK1,
+		B:         DefaultB,
+		postings:  make(map[string][]posting),
+		positions: make(map[string]int),
+	}
+}
+
+// Len returns the number of indexed documents
+func (idx *Index) Len() int {
+	return idx.count
+}
+
+// Add indexes the text of a document, replacing any document with the same ID
+func (idx *Index) Add(id, text string) {
+	idx.Remove(id)
+
+	terms := Tokenize(text)
+	freqs := make(map[string]int)
+	for _, term := range terms {
+		freqs[term]++
+	}
+
+	doc := len(idx.docs)
+	distinct := make([]string, 0, len(freqs))
+	for term, freq := range freqs {
+		idx.postings[term] = append(idx.postings[term], posting{Doc: doc, Freq: freq})
+		distinct = append(distinct, term)
+	}
+
+	idx.docs = append(idx.docs, document{ID: id, Length: len(terms), terms: distinct})
+	idx.positions[id] = doc
+	idx.totalLength += len(terms)
+	idx.count++
+}
+
+// Remove removes a document from the index. It returns false if the
+// document was not indexed.
+func (idx *Index) Remove(id string) bool {
+	doc, ok := idx.positions[id]
+	if !ok {
+		return false
+	}
+
+	for _, term := range idx.docs[doc].terms {
+		list := idx.postings[term]
+		for i, p := range list {
+			if p.Doc == doc {
+				list = append(list[:i], list[i+1:]...)
+				break
+			}
+		}
+		if len(list) == 0 {
+			delete(idx.postings, term)
+		} else {
+			idx.postings[term] = list
+		}
+	}
+
+	idx.totalLength -= idx.docs[doc].Length
+	idx.docs[doc] = document{}
+	delete(idx.positions, id)
+	idx.count--
+	return true
+}
+
+// Search returns the limit documents that best match the query, by
+// descending BM25 score. Documents that share no term with the query are
+// not returned.
+func (idx *Index) Search(query string, limit int) []SearchResult {
+	return idx.SearchFiltered(query, limit, nil)
+}
+
+// SearchFiltered is like Search but only returns the documents whose ID is
+// accepted by filter. The filter applies before the limit.
+func (idx *Index) SearchFiltered(query string, limit int, filter func(id string) bool) []SearchResult {
+	if idx.count == 0 {
+		return nil
+	}
+
+	avgLength := float64(idx.totalLength) / float64(idx.count)
+	if avgLength == 0 {
+		avgLength = 1
+	}
+
+	scores := make(map[int]float64)
+	seen := make(map[string]bool)
+	for _, term := range Tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		list := idx.postings[term]
+		if len(list) == 0 {
+			continue
+		}
+
+		// Inverse document frequency, always positive
+		n := float64(len(list))
+		idf := math.Log(1 + (float64(idx.count)-n+0.5)/(n+0.5))
+
+		for _, p := range list {
+			tf := float64(p.Freq)
+			norm := idx.K1 * (1 - idx.B + idx.B*float64(idx.docs[p.Doc].Length)/avgLength)
+			scores[p.Doc] += idf * tf * (idx.K1 + 1) / (tf + norm)
+		}
+	}
+
+	results := make([]SearchResult, 0, len(scores))
+	for doc, score := range scores {
+		if filter != nil && !filter(idx.docs[doc].ID) {
+			continue
+		}
+		results = append(results, SearchResult{ID: idx.docs[doc].ID, Score: score})
+	}
+
+	// Sort by descending score, then by ID for stable results
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+
+	// Limit the number of results
+	if limit > 0 && limit < len(results) {
+		results = results[:limit]
+	}
+
+	return results
+}
+
+// indexFile is the serialized form of an index
+type indexFile struct {
+	K1         float64              `json:"k1"`
+	B          float64              `json:"b"`
+	Generation uint64               `json:"generation,omitempty"`
+	Docs       []document           `json:"docs"`
+	Postings   map[string][]posting `json:"postings"`
+}
+
+// Marshal encodes the index in the JSON form written by Save
+func (idx *Index) Marshal() ([]byte, error) {
+	// Renumber the remaining documents
+	renumber := make(map[int]int, idx.count)
+	file := indexFile{
+		K1:         idx.K1,
+		B:          idx.B,
+		Generation: idx.Generation,
+		Docs:       make([]document, 0, idx.count),
+		Postings:   make(map[string][]posting, len(idx.postings)),
+	}
+	for i, doc := range idx.docs {
+		if doc.ID == "" {
+			continue
+		}
+		renumber[i] = len(file.Docs)
+		file.Docs = append(file.Docs, doc)
+	}
+	for term, list := range idx.postings {
+		renumbered := make([]posting, len(list))
+		for i, p := range list {
+			renumbered[i] = posting{Doc: renumber[p.Doc], Freq: p.Freq}
+		}
+		file.Postings[term] = renumbered
+	}
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to serialize keyword index: %w", err)
+	}
+	return data, nil
+}
+
+// Save writes the index to a JSON file. Removed documents are dropped.
+func (idx *Index) Save(path string) error {
+	data, err := idx.Marshal()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load reads an index written by Save
+func Load(path string) (*Index, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyword index %s: %w", path, err)
+	}
+	return idx, nil
+}
+
+// Unmarshal decodes an index encoded by Marshal
+func Unmarshal(data []byte) (*Index, error) {
+	var file indexFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	idx := NewIndex()
+	idx.K1 = file.K1
+	idx.B = file.B
+	idx.Generation = file.Generation
+	idx.docs = file.Docs
+	if file.Postings != nil {
+		idx.postings = file.Postings
+	}
+	for i, doc := range idx.docs {
+		idx.positions[doc.ID] = i
+		idx.totalLength += doc.Length
+	}
+	idx.count = len(idx.docs)
+
+	for term, list := range idx.postings {
+		for _, p := range list {
+			if p.Doc < 0 || p.Doc >= len(idx.docs) {
+				return nil, fmt.Errorf("term %q refers to unknown document %d", term, p.Doc)
+			}
+			idx.docs[p.Doc].terms = append(idx.docs[p.Doc].terms, term)
+		}
+	}
+
+	return idx, nil
+}
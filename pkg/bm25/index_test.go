@@ -0,0 +1,151 @@
+package bm25
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// ids returns the IDs of results, in order
+func ids(results []SearchResult) []string {
+	out := make([]string, len(results))
+	for i, result := range results {
+		out[i] = result.ID
+	}
+	return out
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello, World", []string{"hello", "world"}},
+		{"ERR-404 occurred", []string{"err-404", "err", "404", "occurred"}},
+		{"call get_user()", []string{"call", "get_user", "get", "user"}},
+		{"pkg.Func", []string{"pkg.func", "pkg", "func"}},
+		{"Éléphant déjà", []string{"éléphant", "déjà"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSearchScores(t *testing.T) {
+	idx := NewIndex()
+	idx.Add("a", "the quick brown fox")
+	idx.Add("b", "the lazy dog")
+	idx.Add("c", "fox fox fox")
+
+	results := idx.Search("fox", 0)
+	if got, want := ids(results), []string{"c", "a"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Search(fox) = %q, want %q", got, want)
+	}
+
+	// Score of "a" computed by hand: one occurrence, length 4, average
+	// length 10/3, "fox" in 2 of the 3 documents
+	idf := math.Log(1 + (3-2+0.5)/(2+0.5))
+	norm := DefaultK1 * (1 - DefaultB + DefaultB*4/(10.0/3))
+	want := idf * (DefaultK1 + 1) / (1 + norm)
+	if math.Abs(results[1].Score-want) > 1e-9 {
+		t.Errorf("score of a = %v, want %v", results[1].Score, want)
+	}
+
+	if results := idx.Search("cat", 0); len(results) != 0 {
+		t.Errorf("Search(cat) = %v, want no result", results)
+	}
+	if results := idx.Search("fox dog", 1); len(results) != 1 {
+		t.Errorf("Search with limit 1 returned %d results", len(results))
+	}
+}
+
+func TestSearchFiltered(t *testing.T) {
+	idx := NewIndex()
+	idx.Add("a", "fox fox")
+	idx.Add("b", "fox")
+
+	results := idx.SearchFiltered("fox", 1, func(id string) bool { return id == "b" })
+	if got, want := ids(results), []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SearchFiltered = %q, want %q", got, want)
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	idx := NewIndex()
+	idx.Add("a", "alpha beta")
+	idx.Add("b", "beta gamma")
+
+	// Replacing a document drops its previous terms
+	idx.Add("a", "delta")
+	if results := idx.Search("alpha", 0); len(results) != 0 {
+		t.Errorf("replaced document still matches its previous text: %v", results)
+	}
+	if got, want := ids(idx.Search("delta", 0)), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Search(delta) = %q, want %q", got, want)
+	}
+
+	if !idx.Remove("b") {
+		t.Fatal("Remove(b) = false, want true")
+	}
+	if idx.Remove("b") {
+		t.Error("second Remove(b) = true, want false")
+	}
+	if idx.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", idx.Len())
+	}
+	if _, ok := idx.postings["gamma"]; ok {
+		t.Error("postings of a removed document are kept")
+	}
+	if got, want := ids(idx.Search("beta delta", 0)), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Search after Remove = %q, want %q", got, want)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	idx := NewIndex()
+	idx.Generation = 7
+	idx.Add("a", "the quick brown fox")
+	idx.Add("b", "the lazy dog")
+	idx.Add("c", "a fox and a dog")
+	idx.Remove("b")
+
+	data, err := idx.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.Generation != 7 || loaded.Len() != 2 {
+		t.Fatalf("loaded generation %d with %d documents, want 7 with 2", loaded.Generation, loaded.Len())
+	}
+	for _, query := range []string{"fox", "dog", "quick lazy"} {
+		if got, want := loaded.Search(query, 0), idx.Search(query, 0); !reflect.DeepEqual(got, want) {
+			t.Errorf("Search(%q) after round trip = %v, want %v", query, got, want)
+		}
+	}
+
+	// The terms of loaded documents are known again, so Remove works
+	if !loaded.Remove("a") {
+		t.Fatal("Remove(a) after round trip = false")
+	}
+	if got, want := ids(loaded.Search("fox quick", 0)), []string{"c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Search after Remove = %q, want %q", got, want)
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	for _, data := range []string{
+		`not json`,
+		`{"docs":[{"id":"a","len":1}],"postings":{"x":[{"d":3,"f":1}]}}`,
+	} {
+		if _, err := Unmarshal([]byte(data)); err == nil {
+			t.Errorf("Unmarshal(%s) succeeded, want an error", data)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package vector
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"unsafe"
+)
+
+// Binary storage layout (all integers little-endian):
+//
+//	magic     [4]byte  "RLVS"
+//	version   uint32
+//	dimension uint32
+//	count     uint64
+//	generation uint64
+//	vectors   [count][dimension]float32
+//	ids       [count]{length uint32; id [length]byte}
+//	metadata  [count]{pairs uint32; [pairs]{key string; value string}}
+//
+// where strings are stored as {length uint32; bytes [length]byte}. Version 1
+// files have no metadata table; files before version 3 have no generation
+// and a 20-byte header.
+//
+// The header is 28 bytes long so the vector block is 4-byte aligned and can
+// be used in place once the file is memory-mapped.
+const (
+	binaryMagic      = "RLVS"
+	binaryVersion    = 3
+	binaryHeaderSize = 28
+)
+
+// headerSize returns the size of the header of a file of the given version
+func headerSize(version uint32) int {
+	if version < 3 {
+		return 20
+	}
+	return binaryHeaderSize
+}
+
+// isLittleEndian reports whether the host stores integers little-endian,
+// in which case mapped vectors can be used without decoding
+var isLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// isBinaryStore reports whether data starts with the binary storage magic
+func isBinaryStore(data []byte) bool {
+	return len(data) >= len(binaryMagic) && string(data[:len(binaryMagic)]) == binaryMagic
+}
+
+// writeBinary writes the storage to path in the binary format. The file is
+// written next to path and renamed over it so that a store currently mapped
+// from path keeps reading the previous version.
+func (s *Store) writeBinary(path string) error {
+	dim := s.Dimension()
+	for _, item := range s.Items {
+		if len(item.Vector) != dim {
+			return fmt.Errorf("vector '%s' has dimension %d, expected %d", item.ID, len(item.Vector), dim)
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+
+	var header [binaryHeaderSize]byte
+	copy(header[:4], binaryMagic)
+	binary.LittleEndian.PutUint32(header[4:8], binaryVersion)
+	binary.LittleEndian.PutUint32(header[8:12], uint32(dim))
+	binary.LittleEndian.PutUint64(header[12:20], uint64(len(s.Items)))
+	binary.LittleEndian.PutUint64(header[20:28], s.Generation)
+	w.Write(header[:])
+
+	var buf [4]byte
+	for _, item := range s.Items {
+		for _, v := range item.Vector {
+			binary.LittleEndian.PutUint32(buf[:], math.Float32bits(v))
+			w.Write(buf[:])
+		}
+	}
+
+	writeString := func(str string) {
+		binary.LittleEndian.PutUint32(buf[:], uint32(len(str)))
+		w.Write(buf[:])
+		w.WriteString(str)
+	}
+
+	for _, item := range s.Items {
+		writeString(item.ID)
+	}
+
+	for _, item := range s.Items {
+		// Sort the keys so that saving the same storage twice gives the same file
+		keys := make([]string, 0, len(item.Metadata))
+		for key := range item.Metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		binary.LittleEndian.PutUint32(buf[:], uint32(len(keys)))
+		w.Write(buf[:])
+		for _, key := range keys {
+			writeString(key)
+			writeString(item.Metadata[key])
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	// Make sure the data is on disk before the rename makes it visible
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// readBinary fills the storage from data in the binary format. When the host
+// is little-endian the vectors point directly into data, which must then stay
+// valid for as long as the storage is used.
+func (s *Store) readBinary(data []byte) error {
+	if len(data) < 8 || !isBinaryStore(data) {
+		return fmt.Errorf("not a binary vector storage file")
+	}
+
+	version := binary.LittleEndian.Uint32(data[4:8])
+	if version < 1 || version > binaryVersion {
+		return fmt.Errorf("unsupported vector storage version %d", version)
+	}
+	size := headerSize(version)
+	if len(data) < size {
+		return fmt.Errorf("truncated vector storage file")
+	}
+
+	dim := int(binary.LittleEndian.Uint32(data[8:12]))
+	count := binary.LittleEndian.Uint64(data[12:20])
+	var generation uint64
+	if version >= 3 {
+		generation = binary.LittleEndian.Uint64(data[20:28])
+	}
+
+	available := uint64(len(data) - size)
+	if dim > 0 && count > available/(uint64(dim)*4) {
+		return fmt.Errorf("truncated vector storage file")
+	}
+	vectorBytes := uint64(dim) * 4 * count
+	// Each ID takes at least its 4-byte length: don't trust a count the
+	// file can't hold, even with no vectors
+	if count > (available-vectorBytes)/4 {
+		return fmt.Errorf("truncated vector storage file")
+	}
+
+	block := data[size : size+int(vectorBytes)]
+	var floats []float32
+	if isLittleEndian && len(block) > 0 {
+		floats = unsafe.Slice((*float32)(unsafe.Pointer(&block[0])), len(block)/4)
+	} else {
+		floats = make([]float32, len(block)/4)
+		for i := range floats {
+			floats[i] = math.Float32frombits(binary.LittleEndian.Uint32(block[i*4:]))
+		}
+	}
+
+	table := bytes.NewReader(data[size+int(vectorBytes):])
+	items := make([]VectorItem, count)
+	for i := range items {
+		id, err := readString(table)
+		if err != nil {
+			return fmt.Errorf("truncated vector storage ID table")
+		}
+
+		offset := i * dim
+		items[i] = VectorItem{
+			ID:     id,
+			Vector: floats[offset : offset+dim : offset+dim],
+		}
+	}
+
+	if version >= 2 {
+		for i := range items {
+			var pairs uint32
+			if err := binary.Read(table, binary.LittleEndian, &pairs); err != nil {
+				return fmt.Errorf("truncated vector storage metadata table")
+			}
+			if pairs == 0 {
+				continue
+			}
+			if uint64(pairs) > uint64(table.Len())/8 {
+				return fmt.Errorf("truncated vector storage metadata table")
+			}
+			items[i].Metadata = make(map[string]string, pairs)
+			for j := uint32(0); j < pairs; j++ {
+				key, err := readString(table)
+				if err != nil {
+					return fmt.Errorf("truncated vector storage metadata table")
+				}
+				value, err := readString(table)
+				if err != nil {
+					return fmt.Errorf("truncated vector storage metadata table")
+				}
+				items[i].Metadata[key] = value
+			}
+		}
+	}
+
+	s.Items = items
+	s.Generation = generation
+	return nil
+}
+
+// readString reads a length-prefixed string
+func readString(r *bytes.Reader) (string, error) {
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return "", err
+	}
+	if uint64(length) > uint64(r.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,226 @@
+package vector
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// randomStore returns a storage of n random vectors of dimension dim
+func randomStore(rng *rand.Rand, n, dim int) *Store {
+	store := NewStore()
+	for i := 0; i < n; i++ {
+		vector := make([]float32, dim)
+		for j := range vector {
+			vector[j] = rng.Float32()*2 - 1
+		}
+		store.Add(fmt.Sprintf("doc%d_chunk_%d", i/10, i%10), vector)
+	}
+	return store
+}
+
+// writeLegacyJSON writes store in the JSON format used before the binary one
+func writeLegacyJSON(t testing.TB, store *Store, path string) {
+	t.Helper()
+	data, err := json.Marshal(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertSameItems fails unless got holds the same vectors as want
+func assertSameItems(t *testing.T, got, want *Store) {
+	t.Helper()
+	if len(got.Items) != len(want.Items) {
+		t.Fatalf("got %d items, want %d", len(got.Items), len(want.Items))
+	}
+	for i := range want.Items {
+		g, w := got.Items[i], want.Items[i]
+		if g.ID != w.ID || !reflect.DeepEqual(g.Vector, w.Vector) {
+			t.Fatalf("item %d: got %s, want %s", i, g.ID, w.ID)
+		}
+		if len(g.Metadata) != len(w.Metadata) || (len(w.Metadata) > 0 && !reflect.DeepEqual(g.Metadata, w.Metadata)) {
+			t.Fatalf("item %d: got metadata %v, want %v", i, g.Metadata, w.Metadata)
+		}
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	store := randomStore(rng, 50, 16)
+	store.SetMetadata("doc0_chunk_1", map[string]string{"path": "notes/é.md", "type": "text/markdown"})
+	store.Generation = 42
+
+	path := filepath.Join(t.TempDir(), "vectors.bin")
+	if err := store.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewStore()
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+	assertSameItems(t, loaded, store)
+	if loaded.Generation != 42 {
+		t.Fatalf("got generation %d, want 42", loaded.Generation)
+	}
+}
+
+func TestLegacyJSONConversion(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	store := randomStore(rng, 30, 8)
+	store.SetMetadata("doc1_chunk_2", map[string]string{"type": "text/plain"})
+
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "vectors.json")
+	writeLegacyJSON(t, store, jsonPath)
+
+	legacy := NewStore()
+	if err := legacy.Load(jsonPath); err != nil {
+		t.Fatal(err)
+	}
+	assertSameItems(t, legacy, store)
+
+	// Saving a legacy storage converts it to the binary format
+	binPath := filepath.Join(dir, "vectors.bin")
+	if err := legacy.Save(binPath); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(binPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isBinaryStore(data) {
+		t.Fatal("converted storage is not in the binary format")
+	}
+
+	converted := NewStore()
+	if err := converted.Load(binPath); err != nil {
+		t.Fatal(err)
+	}
+	defer converted.Close()
+	assertSameItems(t, converted, store)
+}
+
+func TestLoadLegacyJSONReleasesMapping(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	dir := t.TempDir()
+	binPath := filepath.Join(dir, "vectors.bin")
+	if err := randomStore(rng, 20, 4).Save(binPath); err != nil {
+		t.Fatal(err)
+	}
+	legacy := randomStore(rng, 10, 4)
+	jsonPath := filepath.Join(dir, "vectors.json")
+	writeLegacyJSON(t, legacy, jsonPath)
+
+	store := NewStore()
+	if err := store.Load(binPath); err != nil {
+		t.Fatal(err)
+	}
+	if store.mapping == nil {
+		t.Fatal("binary storage is not memory-mapped")
+	}
+	if err := store.Load(jsonPath); err != nil {
+		t.Fatal(err)
+	}
+	if store.mapping != nil {
+		t.Fatal("the mapping of the previous file was not released")
+	}
+	assertSameItems(t, store, legacy)
+}
+
+func TestLoadCorruptedBinary(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	store := randomStore(rng, 20, 4)
+	store.SetMetadata("doc0_chunk_0", map[string]string{"type": "text/plain"})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "vectors.bin")
+	if err := store.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every truncation must be reported, not panic or return fewer items
+	for size := 0; size < len(data); size++ {
+		if err := (&Store{}).readBinary(data[:size]); err == nil {
+			t.Fatalf("truncated to %d of %d bytes: no error", size, len(data))
+		}
+	}
+
+	truncated := filepath.Join(dir, "truncated.bin")
+	if err := os.WriteFile(truncated, data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewStore().Load(truncated); err == nil {
+		t.Fatal("loading a truncated file: no error")
+	}
+
+	// A header announcing more items than the file holds must not allocate them
+	for _, dim := range []uint32{0, 4} {
+		corrupted := append([]byte(nil), data...)
+		binary.LittleEndian.PutUint32(corrupted[8:12], dim)
+		binary.LittleEndian.PutUint64(corrupted[12:20], 1<<60)
+		if err := (&Store{}).readBinary(corrupted); err == nil {
+			t.Fatalf("dimension %d with a huge count: no error", dim)
+		}
+	}
+
+	corrupted := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(corrupted[4:8], binaryVersion+1)
+	if err := (&Store{}).readBinary(corrupted); err == nil {
+		t.Fatal("unknown version: no error")
+	}
+}
+
+// benchmarkStore is the storage loaded by the benchmarks, about the size of
+// a RAG of a few hundred documents
+func benchmarkStore() *Store {
+	return randomStore(rand.New(rand.NewSource(4)), 10000, 384)
+}
+
+func BenchmarkLoadJSON(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "vectors.json")
+	writeLegacyJSON(b, benchmarkStore(), path)
+	benchmarkLoad(b, path)
+}
+
+func BenchmarkLoadBinary(b *testing.B) {
+	// Without the HNSW index, which the JSON storage doesn't have
+	path := filepath.Join(b.TempDir(), "vectors.bin")
+	if err := benchmarkStore().writeBinary(path); err != nil {
+		b.Fatal(err)
+	}
+	benchmarkLoad(b, path)
+}
+
+// benchmarkLoad loads path b.N times and reports the size of the file
+func benchmarkLoad(b *testing.B, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store := NewStore()
+		if err := store.Load(path); err != nil {
+			b.Fatal(err)
+		}
+		store.Close()
+	}
+	// Reported after ResetTimer, which discards the metrics
+	b.ReportMetric(float64(info.Size()), "file-bytes")
+}
@@ -0,0 +1,271 @@
+package vector
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter reports whether a vector should be searched, given its metadata
+type Filter func(metadata map[string]string) bool
+
+// ParseFilter parses a filter expression made of key:pattern terms combined
+// with AND, OR, NOT and parentheses, for example
+//
+//	path:docs/api/** AND (type:pdf OR type:md) AND NOT author:"John Doe"
+//
+// Adjacent terms are implicitly combined with AND. Patterns are globs matched
+// case-insensitively against the whole value: * matches any sequence without
+// a slash, ** any sequence and ? a single character. Terms can also compare
+// the value with <, <=, > or >=, as in page>=3 or created<2024-07-01: as
+// numbers when both sides are numbers, as text otherwise, which orders
+// YYYY-MM-DD dates. A term never matches a vector that has no value for its
+// key. An empty expression gives a nil filter.
+func ParseFilter(expr string) (Filter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	p := &filterParser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected '%s' in filter", p.tokens[p.pos])
+	}
+	return filter, nil
+}
+
+// tokenizeFilter splits a filter expression into parentheses and words.
+// Double quotes keep spaces and parentheses inside a word.
+func tokenizeFilter(expr string) ([]string, error) {
+	var tokens []string
+	var word strings.Builder
+	inWord, quoted := false, false
+
+	flush := func() {
+		if inWord {
+			tokens = append(tokens, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+
+	for _, r := range expr {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inWord = true
+		case quoted:
+			word.WriteRune(r)
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in filter")
+	}
+	flush()
+	return tokens, nil
+}
+
+// filterParser is a recursive descent parser over filter tokens
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+// peek returns the next token, or "" at the end of the expression
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// keyword reports whether the next token is the given operator and consumes it
+func (p *filterParser) keyword(op string) bool {
+	if strings.EqualFold(p.peek(), op) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// parseOr parses terms separated by OR
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(m map[string]string) bool { return l(m) || right(m) }
+	}
+	return left, nil
+}
+
+// parseAnd parses terms separated by AND or simply juxtaposed
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if !p.keyword("AND") {
+			next := p.peek()
+			if next == "" || next == ")" || strings.EqualFold(next, "OR") {
+				return left, nil
+			}
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(m map[string]string) bool { return l(m) && right(m) }
+	}
+}
+
+// parseNot parses an optionally negated term or parenthesized expression
+func (p *filterParser) parseNot() (Filter, error) {
+	if p.keyword("NOT") {
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(m map[string]string) bool { return !inner(m) }, nil
+	}
+
+	token := p.peek()
+	switch token {
+	case "":
+		return nil, fmt.Errorf("unexpected end of filter")
+	case ")":
+		return nil, fmt.Errorf("unexpected ')' in filter")
+	case "(":
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword(")") {
+			return nil, fmt.Errorf("missing ')' in filter")
+		}
+		return inner, nil
+	}
+
+	p.pos++
+	i := strings.IndexAny(token, ":<>")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid filter term '%s' (expected key:pattern or a comparison such as key>=value)", token)
+	}
+	if token[i] != ':' {
+		return parseComparison(token, i)
+	}
+	key, pattern := token[:i], token[i+1:]
+	re, err := GlobPattern(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern in filter term '%s': %w", token, err)
+	}
+	key = strings.ToLower(key)
+	return func(m map[string]string) bool {
+		value, ok := m[key]
+		return ok && re.MatchString(value)
+	}, nil
+}
+
+// parseComparison parses a term whose operator starts at token[i]
+func parseComparison(token string, i int) (Filter, error) {
+	key, op, operand := strings.ToLower(token[:i]), token[i:i+1], token[i+1:]
+	if strings.HasPrefix(operand, "=") {
+		op += "="
+		operand = operand[1:]
+	}
+	if operand == "" {
+		return nil, fmt.Errorf("missing value in filter term '%s'", token)
+	}
+
+	return func(m map[string]string) bool {
+		value, ok := m[key]
+		if !ok {
+			return false
+		}
+		c := compareValues(value, operand)
+		switch op {
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		default:
+			return c >= 0
+		}
+	}, nil
+}
+
+// compareValues compares two values as numbers if both are numbers, and
+// case-insensitively as text otherwise
+func compareValues(a, b string) int {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+// GlobPattern compiles a glob into a case-insensitive regular expression
+// matching the whole value
+func GlobPattern(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	runes := []rune(glob)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				i++
+				// "**/" also matches no directory at all
+				if i+1 < len(runes) && runes[i+1] == '/' {
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
@@ -0,0 +1,125 @@
+package vector
+
+import (
+	"testing"
+)
+
+func TestParseFilterInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"path",
+		":pdf",
+		"type:pdf AND",
+		"type:pdf OR",
+		"NOT",
+		"(type:pdf",
+		"type:pdf)",
+		"()",
+		`author:"Jane`,
+		"page>",
+		"page>=",
+		"<3",
+		"type:pdf AND AND type:md",
+	} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Errorf("ParseFilter(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestParseFilterEmpty(t *testing.T) {
+	for _, expr := range []string{"", "   "} {
+		filter, err := ParseFilter(expr)
+		if err != nil || filter != nil {
+			t.Errorf("ParseFilter(%q) = %v, %v, want a nil filter", expr, filter != nil, err)
+		}
+	}
+}
+
+func TestParseFilterMatch(t *testing.T) {
+	pdf := map[string]string{
+		"path":     "docs/api/guide.pdf",
+		"name":     "guide.pdf",
+		"type":     "pdf",
+		"author":   "Jane Doe",
+		"created":  "2024-03-15",
+		"modified": "2023-11-02",
+		"page":     "12",
+	}
+	md := map[string]string{
+		"path":    "README.md",
+		"name":    "README.md",
+		"type":    "md",
+		"created": "2024-07-01",
+	}
+
+	tests := []struct {
+		expr    string
+		pdf, md bool
+	}{
+		{"type:pdf", true, false},
+		{"TYPE:PDF", true, false},
+		{"path:docs/**", true, false},
+		{"path:**/*.md", false, true},
+		{"path:docs/*.pdf", false, false},
+		{"path:docs/*/guide.pdf", true, false},
+		{"name:guide.???", true, false},
+		{`author:"jane doe"`, true, false},
+		{"author:jane*", true, false},
+		{"NOT author:*", false, true},
+		{"type:pdf OR type:md", true, true},
+		{"type:pdf AND type:md", false, false},
+		{"type:pdf type:md", false, false},
+		{"(type:pdf OR type:md) AND created:2024-07-*", false, true},
+		{"type:md OR type:pdf AND page:12", true, true},
+		{"NOT (type:pdf OR type:md)", false, false},
+		{"modified:2023-*", true, false},
+
+		// Pages are compared as numbers: "12" > "9" although "12" < "9" as text
+		{"page>9", true, false},
+		{"page>=12", true, false},
+		{"page>12", false, false},
+		{"page<=12 AND page>=12", true, false},
+		{"page<100", true, false},
+		{"NOT page<10", true, true},
+
+		// Dates are compared as text
+		{"created>=2024-07-01", false, true},
+		{"created<2024-07-01", true, false},
+		{"created>2024-01-01 AND created<2024-12-31", true, true},
+		{`created>="2024-03-15"`, true, true},
+		{"modified<2024-01-01", true, false},
+		{"name>H", false, true},
+	}
+
+	for _, tt := range tests {
+		filter, err := ParseFilter(tt.expr)
+		if err != nil {
+			t.Errorf("ParseFilter(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := filter(pdf); got != tt.pdf {
+			t.Errorf("%q on the PDF = %v, want %v", tt.expr, got, tt.pdf)
+		}
+		if got := filter(md); got != tt.md {
+			t.Errorf("%q on the README = %v, want %v", tt.expr, got, tt.md)
+		}
+	}
+}
+
+func TestSearchFilteredBeforeTopK(t *testing.T) {
+	store := NewStore()
+	for i, page := range []string{"1", "2", "3", "4"} {
+		vector := []float32{1, float32(i) * 0.1}
+		store.AddWithMetadata("chunk"+page, vector, map[string]string{"page": page})
+	}
+
+	filter, err := ParseFilter("page>=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The closest vectors are on pages 1 and 2, filtered out before the limit
+	results := store.SearchFiltered([]float32{1, 0}, 2, filter)
+	if len(results) != 2 || results[0].ID != "chunk3" || results[1].ID != "chunk4" {
+		t.Errorf("SearchFiltered = %+v, want chunk3 and chunk4", results)
+	}
+}
@@ -0,0 +1,577 @@
+package vector
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// HNSWConfig holds the parameters of a HNSW index
+type HNSWConfig struct {
+	// M is the number of neighbors kept per node (twice as many on the bottom layer)
+	M int `json:"m"`
+	// EfConstruction is the size of the candidate list used while inserting
+	EfConstruction int `json:"ef_construction"`
+	// EfSearch is the size of the candidate list used while searching
+	EfSearch int `json:"ef_search"`
+}
+
+// DefaultHNSWConfig returns the default HNSW parameters
+func DefaultHNSWConfig() HNSWConfig {
+	return HNSWConfig{
+		M:              16,
+		EfConstruction: 200,
+		EfSearch:       64,
+	}
+}
+
+// withDefaults replaces unset parameters with their default value
+func (c HNSWConfig) withDefaults() HNSWConfig {
+	def := DefaultHNSWConfig()
+	if c.M <= 1 {
+		c.M = def.M
+	}
+	if c.EfConstruction <= 0 {
+		c.EfConstruction = def.EfConstruction
+	}
+	if c.EfSearch <= 0 {
+		c.EfSearch = def.EfSearch
+	}
+	return c
+}
+
+// hnswNode is a vector of the graph with its neighbors on each layer
+type hnswNode struct {
+	id        string
+	vector    []float32
+	norm      float64
+	deleted   bool
+	neighbors [][]int32
+}
+
+// HNSWIndex is an approximate nearest neighbor index based on Hierarchical
+// Navigable Small World graphs. Removed vectors stay in the graph to keep it
+// connected but are no longer returned. An index is not safe for concurrent use.
+type HNSWIndex struct {
+	config   HNSWConfig
+	nodes    []*hnswNode
+	ids      map[string]int32
+	entry    int32
+	maxLevel int
+	deleted  int
+	levelMul float64
+	rng      *rand.Rand
+
+	// visited[i] == visitEpoch marks node i as visited by the current search
+	visited    []uint32
+	visitEpoch uint32
+}
+
+// NewHNSWIndex creates an empty HNSW index
+func NewHNSWIndex(config HNSWConfig) *HNSWIndex {
+	config = config.withDefaults()
+	return &HNSWIndex{
+		config:   config,
+		ids:      make(map[string]int32),
+		entry:    -1,
+		levelMul: 1 / math.Log(float64(config.M)),
+		rng:      rand.New(rand.NewSource(1)),
+	}
+}
+
+// Config returns the parameters of the index
+func (h *HNSWIndex) Config() HNSWConfig {
+	return h.config
+}
+
+// SetEfSearch changes the size of the candidate list used while searching
+func (h *HNSWIndex) SetEfSearch(ef int) {
+	if ef > 0 {
+		h.config.EfSearch = ef
+	}
+}
+
+// Len returns the number of vectors that can be returned by the index
+func (h *HNSWIndex) Len() int {
+	return len(h.ids)
+}
+
+// DeletedRatio returns the fraction of graph nodes that were removed
+func (h *HNSWIndex) DeletedRatio() float64 {
+	if len(h.nodes) == 0 {
+		return 0
+	}
+	return float64(h.deleted) / float64(len(h.nodes))
+}
+
+// Remove marks a vector as removed
+func (h *HNSWIndex) Remove(id string) {
+	if i, ok := h.ids[id]; ok {
+		h.nodes[i].deleted = true
+		h.deleted++
+		delete(h.ids, id)
+	}
+}
+
+// Add inserts a vector into the graph
+func (h *HNSWIndex) Add(id string, vector []float32) {
+	h.Remove(id)
+
+	level := int(-math.Log(1-h.rng.Float64()) * h.levelMul)
+	node := &hnswNode{
+		id:        id,
+		vector:    vector,
+		norm:      norm(vector),
+		neighbors: make([][]int32, level+1),
+	}
+	idx := int32(len(h.nodes))
+	h.nodes = append(h.nodes, node)
+	h.ids[id] = idx
+
+	if h.entry < 0 {
+		h.entry = idx
+		h.maxLevel = level
+		return
+	}
+
+	// Descend greedily through the layers above the node's level
+	ep := h.entry
+	for l := h.maxLevel; l > level; l-- {
+		ep = h.greedy(vector, node.norm, ep, l)
+	}
+
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		candidates := h.searchLayer(vector, node.norm, []int32{ep}, h.config.EfConstruction, l)
+		neighbors := h.selectNeighbors(candidates, h.maxNeighbors(l))
+		node.neighbors[l] = neighbors
+
+		for _, n := range neighbors {
+			h.connect(n, idx, l)
+		}
+		ep = candidates[0].node
+	}
+
+	if level > h.maxLevel {
+		h.entry = idx
+		h.maxLevel = level
+	}
+}
+
+// Search returns the limit vectors most similar to query
+func (h *HNSWIndex) Search(query []float32, limit int) []SearchResult {
+	if h.entry < 0 || len(h.ids) == 0 {
+		return nil
+	}
+
+	qNorm := norm(query)
+	ep := h.entry
+	for l := h.maxLevel; l > 0; l-- {
+		ep = h.greedy(query, qNorm, ep, l)
+	}
+
+	ef := h.config.EfSearch
+	if limit > ef {
+		ef = limit
+	}
+	// Removed nodes take room in the candidate list, compensate for them
+	ef += int(float64(ef) * h.DeletedRatio())
+
+	var results []SearchResult
+	for _, c := range h.searchLayer(query, qNorm, []int32{ep}, ef, 0) {
+		node := h.nodes[c.node]
+		if node.deleted {
+			continue
+		}
+		results = append(results, SearchResult{ID: node.id, Score: c.score})
+		if limit > 0 && len(results) == limit {
+			break
+		}
+	}
+	return results
+}
+
+// maxNeighbors returns the number of neighbors kept on layer l
+func (h *HNSWIndex) maxNeighbors(l int) int {
+	if l == 0 {
+		return 2 * h.config.M
+	}
+	return h.config.M
+}
+
+// similarity returns the cosine similarity between a vector and a node
+func (h *HNSWIndex) similarity(vector []float32, vNorm float64, i int32) float64 {
+	node := h.nodes[i]
+	if vNorm == 0 || node.norm == 0 || len(vector) != len(node.vector) {
+		return 0
+	}
+	return float64(dot(vector, node.vector)) / (vNorm * node.norm)
+}
+
+// greedy moves from ep to the most similar node reachable on layer l
+func (h *HNSWIndex) greedy(vector []float32, vNorm float64, ep int32, l int) int32 {
+	best := h.similarity(vector, vNorm, ep)
+	for changed := true; changed; {
+		changed = false
+		for _, n := range h.nodes[ep].neighbors[l] {
+			if s := h.similarity(vector, vNorm, n); s > best {
+				best, ep, changed = s, n, true
+			}
+		}
+	}
+	return ep
+}
+
+// candidate is a node with its similarity to the current query
+type candidate struct {
+	node  int32
+	score float64
+}
+
+// candidateHeap is a heap of candidates, ordered by ascending score unless max is set
+type candidateHeap struct {
+	items []candidate
+	max   bool
+}
+
+func (c *candidateHeap) Len() int { return len(c.items) }
+func (c *candidateHeap) Less(i, j int) bool {
+	if c.max {
+		return c.items[i].score > c.items[j].score
+	}
+	return c.items[i].score < c.items[j].score
+}
+func (c *candidateHeap) Swap(i, j int)      { c.items[i], c.items[j] = c.items[j], c.items[i] }
+func (c *candidateHeap) Push(x interface{}) { c.items = append(c.items, x.(candidate)) }
+func (c *candidateHeap) Pop() interface{} {
+	last := c.items[len(c.items)-1]
+	c.items = c.items[:len(c.items)-1]
+	return last
+}
+
+// searchLayer returns up to ef nodes of layer l close to vector, by descending score
+func (h *HNSWIndex) searchLayer(vector []float32, vNorm float64, entries []int32, ef int, l int) []candidate {
+	if len(h.visited) < len(h.nodes) {
+		h.visited = make([]uint32, len(h.nodes)+len(h.nodes)/2)
+		h.visitEpoch = 0
+	}
+	h.visitEpoch++
+	if h.visitEpoch == 0 {
+		for i := range h.visited {
+			h.visited[i] = 0
+		}
+		h.visitEpoch = 1
+	}
+	visited := h.visited
+	epoch := h.visitEpoch
+
+	toVisit := &candidateHeap{max: true}
+	found := &candidateHeap{}
+
+	for _, e := range entries {
+		c := candidate{e, h.similarity(vector, vNorm, e)}
+		visited[e] = epoch
+		heap.Push(toVisit, c)
+		heap.Push(found, c)
+	}
+
+	for toVisit.Len() > 0 {
+		current := heap.Pop(toVisit).(candidate)
+		if found.Len() >= ef && current.score < found.items[0].score {
+			break
+		}
+
+		node := h.nodes[current.node]
+		if l >= len(node.neighbors) {
+			continue
+		}
+		for _, n := range node.neighbors[l] {
+			if visited[n] == epoch {
+				continue
+			}
+			visited[n] = epoch
+
+			s := h.similarity(vector, vNorm, n)
+			if found.Len() < ef || s > found.items[0].score {
+				heap.Push(toVisit, candidate{n, s})
+				heap.Push(found, candidate{n, s})
+				if found.Len() > ef {
+					heap.Pop(found)
+				}
+			}
+		}
+	}
+
+	results := found.items
+	sort.Slice(results, func(i, j int) bool { return results[i].score > results[j].score })
+	return results
+}
+
+// selectNeighbors picks up to m neighbors among candidates sorted by
+// descending score. A candidate is preferred when it is closer to the new
+// node than to the neighbors already selected, which keeps links spread in
+// different directions; the remaining slots are filled with the closest of
+// the other candidates.
+func (h *HNSWIndex) selectNeighbors(candidates []candidate, m int) []int32 {
+	neighbors := make([]int32, 0, m)
+	var skipped []int32
+
+	for _, c := range candidates {
+		if len(neighbors) == m {
+			break
+		}
+		node := h.nodes[c.node]
+		diverse := true
+		for _, n := range neighbors {
+			if h.similarity(node.vector, node.norm, n) > c.score {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			neighbors = append(neighbors, c.node)
+		} else {
+			skipped = append(skipped, c.node)
+		}
+	}
+
+	for _, n := range skipped {
+		if len(neighbors) == m {
+			break
+		}
+		neighbors = append(neighbors, n)
+	}
+	return neighbors
+}
+
+// connect adds a link from node a to node b on layer l, keeping only the
+// closest neighbors of a if it has too many
+func (h *HNSWIndex) connect(a, b int32, l int) {
+	node := h.nodes[a]
+	node.neighbors[l] = append(node.neighbors[l], b)
+
+	max := h.maxNeighbors(l)
+	if len(node.neighbors[l]) <= max {
+		return
+	}
+
+	candidates := make([]candidate, len(node.neighbors[l]))
+	for i, n := range node.neighbors[l] {
+		candidates[i] = candidate{n, h.similarity(node.vector, node.norm, n)}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	for i := 0; i < max; i++ {
+		node.neighbors[l][i] = candidates[i].node
+	}
+	node.neighbors[l] = node.neighbors[l][:max]
+}
+
+// dot returns the dot product of two vectors of the same length
+func dot(a, b []float32) float32 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return s0 + s1 + s2 + s3
+}
+
+// norm returns the euclidean norm of a vector
+func norm(v []float32) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x * x)
+	}
+	return math.Sqrt(sum)
+}
+
+// Index file layout (all integers little-endian):
+//
+//	magic    [4]byte "RLHN"
+//	version  uint32
+//	m, efConstruction, efSearch uint32
+//	entry    int32
+//	maxLevel uint32
+//	count    uint32
+//	nodes    [count]{idLength uint32; id []byte; deleted uint8; levels uint32;
+//	                  [levels]{n uint32; neighbors [n]int32}}
+//
+// Vectors are not duplicated: they are attached from the vector storage on load.
+const (
+	hnswMagic   = "RLHN"
+	hnswVersion = 1
+)
+
+// Save writes the graph of the index to path
+func (h *HNSWIndex) Save(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := h.Write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Write writes the graph of the index to out, in the layout of the index file
+func (h *HNSWIndex) Write(out io.Writer) error {
+	w := bufio.NewWriter(out)
+	le := binary.LittleEndian
+	w.WriteString(hnswMagic)
+	binary.Write(w, le, []uint32{hnswVersion, uint32(h.config.M), uint32(h.config.EfConstruction), uint32(h.config.EfSearch)})
+	binary.Write(w, le, h.entry)
+	binary.Write(w, le, []uint32{uint32(h.maxLevel), uint32(len(h.nodes))})
+
+	for _, node := range h.nodes {
+		binary.Write(w, le, uint32(len(node.id)))
+		w.WriteString(node.id)
+		var deleted uint8
+		if node.deleted {
+			deleted = 1
+		}
+		binary.Write(w, le, deleted)
+		binary.Write(w, le, uint32(len(node.neighbors)))
+		for _, neighbors := range node.neighbors {
+			binary.Write(w, le, uint32(len(neighbors)))
+			binary.Write(w, le, neighbors)
+		}
+	}
+
+	return w.Flush()
+}
+
+// LoadHNSWIndex reads a graph written by Save and attaches the vectors of
+// items to it. It fails if the graph doesn't match the items exactly.
+func LoadHNSWIndex(path string, items []VectorItem) (*HNSWIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadHNSWIndex(f, items)
+}
+
+// ReadHNSWIndex reads a graph written by Write and attaches the vectors of
+// items to it, as LoadHNSWIndex does
+func ReadHNSWIndex(in io.Reader, items []VectorItem) (*HNSWIndex, error) {
+	r := bufio.NewReader(in)
+	le := binary.LittleEndian
+
+	magic := make([]byte, len(hnswMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != hnswMagic {
+		return nil, fmt.Errorf("not a HNSW index file")
+	}
+
+	var header [4]uint32
+	if err := binary.Read(r, le, &header); err != nil {
+		return nil, fmt.Errorf("truncated HNSW index file")
+	}
+	if header[0] != hnswVersion {
+		return nil, fmt.Errorf("unsupported HNSW index version %d", header[0])
+	}
+
+	h := NewHNSWIndex(HNSWConfig{M: int(header[1]), EfConstruction: int(header[2]), EfSearch: int(header[3])})
+	var counts [2]uint32
+	if err := binary.Read(r, le, &h.entry); err != nil {
+		return nil, fmt.Errorf("truncated HNSW index file")
+	}
+	if err := binary.Read(r, le, &counts); err != nil {
+		return nil, fmt.Errorf("truncated HNSW index file")
+	}
+	h.maxLevel = int(counts[0])
+
+	vectors := make(map[string][]float32, len(items))
+	for _, item := range items {
+		vectors[item.ID] = item.Vector
+	}
+
+	h.nodes = make([]*hnswNode, 0, counts[1])
+	for i := uint32(0); i < counts[1]; i++ {
+		var idLength uint32
+		if err := binary.Read(r, le, &idLength); err != nil {
+			return nil, fmt.Errorf("truncated HNSW index file")
+		}
+		id := make([]byte, idLength)
+		if _, err := io.ReadFull(r, id); err != nil {
+			return nil, fmt.Errorf("truncated HNSW index file")
+		}
+		var deleted uint8
+		var levels uint32
+		if err := binary.Read(r, le, &deleted); err != nil {
+			return nil, fmt.Errorf("truncated HNSW index file")
+		}
+		if err := binary.Read(r, le, &levels); err != nil || levels > 64 {
+			return nil, fmt.Errorf("invalid HNSW index file")
+		}
+
+		node := &hnswNode{id: string(id), deleted: deleted == 1, neighbors: make([][]int32, levels)}
+		for l := range node.neighbors {
+			var n uint32
+			if err := binary.Read(r, le, &n); err != nil || n > uint32(2*h.config.M) {
+				return nil, fmt.Errorf("invalid HNSW index file")
+			}
+			node.neighbors[l] = make([]int32, n)
+			if err := binary.Read(r, le, node.neighbors[l]); err != nil {
+				return nil, fmt.Errorf("truncated HNSW index file")
+			}
+		}
+
+		// Removed nodes keep the vector they had when they were live; if it
+		// is gone from the storage, route through them without comparing
+		if vector, ok := vectors[node.id]; ok && !node.deleted {
+			node.vector = vector
+			h.ids[node.id] = int32(i)
+		} else if !node.deleted {
+			return nil, fmt.Errorf("HNSW index references unknown vector '%s'", node.id)
+		} else {
+			h.deleted++
+		}
+		node.norm = norm(node.vector)
+		h.nodes = append(h.nodes, node)
+	}
+
+	if len(h.ids) != len(items) {
+		return nil, fmt.Errorf("HNSW index is out of date (%d vectors indexed, %d stored)", len(h.ids), len(items))
+	}
+	for _, node := range h.nodes {
+		for _, neighbors := range node.neighbors {
+			for _, n := range neighbors {
+				if n < 0 || int(n) >= len(h.nodes) {
+					return nil, fmt.Errorf("invalid HNSW index file")
+				}
+			}
+		}
+	}
+	if h.entry >= int32(len(h.nodes)) || (h.entry < 0 && len(h.nodes) > 0) {
+		return nil, fmt.Errorf("invalid HNSW index file")
+	}
+
+	return h, nil
+}
@@ -0,0 +1,101 @@
+package vector
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// randomVector returns a random vector of dimension dim
+func randomVector(rng *rand.Rand, dim int) []float32 {
+	vector := make([]float32, dim)
+	for j := range vector {
+		vector[j] = float32(rng.NormFloat64())
+	}
+	return vector
+}
+
+// recall returns the fraction of the exact top-k results found by the index
+// of store, over random queries
+func recall(rng *rand.Rand, store *Store, queries, k int) float64 {
+	found := 0
+	for q := 0; q < queries; q++ {
+		query := randomVector(rng, store.Dimension())
+		exact := make(map[string]bool, k)
+		for _, result := range store.SearchExact(query, k) {
+			exact[result.ID] = true
+		}
+		for _, result := range store.Search(query, k) {
+			if exact[result.ID] {
+				found++
+			}
+		}
+	}
+	return float64(found) / float64(queries*k)
+}
+
+func TestHNSWRecall(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	store := NewStore()
+	for i := 0; i < 3000; i++ {
+		store.Add(fmt.Sprintf("id%d", i), randomVector(rng, 32))
+	}
+	store.BuildIndex()
+	if _, ok := store.index().(*HNSWIndex); !ok {
+		t.Fatal("the storage is not searched through the HNSW index")
+	}
+
+	if r := recall(rng, store, 200, 10); r < 0.95 {
+		t.Fatalf("recall@10 = %.3f at the default ef, want at least 0.95", r)
+	}
+}
+
+func TestHNSWRemoveAdd(t *testing.T) {
+	rng := rand.New(rand.NewSource(6))
+	store := NewStore()
+	for i := 0; i < 2000; i++ {
+		store.Add(fmt.Sprintf("id%d", i), randomVector(rng, 32))
+	}
+	store.BuildIndex()
+
+	// Remove a third of the vectors and replace some of the others
+	for i := 0; i < 2000; i += 3 {
+		store.Remove(fmt.Sprintf("id%d", i))
+	}
+	added := make(map[string][]float32)
+	for i := 0; i < 500; i++ {
+		id := fmt.Sprintf("new%d", i)
+		added[id] = randomVector(rng, 32)
+		store.Add(id, added[id])
+	}
+	for i := 1; i < 300; i += 3 {
+		id := fmt.Sprintf("id%d", i)
+		added[id] = randomVector(rng, 32)
+		store.Add(id, added[id])
+	}
+
+	index, ok := store.index().(*HNSWIndex)
+	if !ok {
+		t.Fatal("the storage is not searched through the HNSW index")
+	}
+	if index.Len() != len(store.Items) {
+		t.Fatalf("index holds %d vectors, storage %d", index.Len(), len(store.Items))
+	}
+
+	// Removed vectors are never returned, added ones are found
+	for id, vector := range added {
+		results := store.Search(vector, 10)
+		if len(results) == 0 || results[0].ID != id {
+			t.Fatalf("vector %s is not its own nearest neighbor: %v", id, results)
+		}
+		for _, result := range results {
+			if _, ok := store.position(result.ID); !ok {
+				t.Fatalf("removed vector %s returned", result.ID)
+			}
+		}
+	}
+
+	if r := recall(rng, store, 200, 10); r < 0.95 {
+		t.Fatalf("recall@10 = %.3f after removals, want at least 0.95", r)
+	}
+}
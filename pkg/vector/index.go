@@ -0,0 +1,70 @@
+package vector
+
+import (
+	"sort"
+)
+
+// MinIndexSize is the number of vectors from which searches go through the
+// HNSW index. Smaller storages are searched exhaustively, which is both exact
+// and fast enough.
+const MinIndexSize = 1000
+
+// Index finds the vectors most similar to a query
+type Index interface {
+	// Add indexes a vector, replacing any vector with the same ID
+	Add(id string, vector []float32)
+	// Remove removes a vector from the index
+	Remove(id string)
+	// Search returns the limit most similar vectors by descending score
+	Search(query []float32, limit int) []SearchResult
+	// Len returns the number of indexed vectors
+	Len() int
+}
+
+// flatIndex searches the items of a storage exhaustively. It reads the
+// storage directly, so Add and Remove have nothing to do. Items whose
+// metadata doesn't match filter, if any, are skipped.
+type flatIndex struct {
+	store  *Store
+	filter Filter
+}
+
+// Add is a no-op: the vector is already in the storage
+func (f *flatIndex) Add(id string, vector []float32) {}
+
+// Remove is a no-op: the vector is already removed from the storage
+func (f *flatIndex) Remove(id string) {}
+
+// Len returns the number of vectors in the storage
+func (f *flatIndex) Len() int {
+	return len(f.store.Items)
+}
+
+// Search computes the cosine similarity with every vector of the storage
+func (f *flatIndex) Search(query []float32, limit int) []SearchResult {
+	var results []SearchResult
+
+	// Calculate cosine similarity for each vector
+	for _, item := range f.store.Items {
+		if f.filter != nil && !f.filter(item.Metadata) {
+			continue
+		}
+		score := cosineSimilarity(query, item.Vector)
+		results = append(results, SearchResult{
+			ID:    item.ID,
+			Score: score,
+		})
+	}
+
+	// Sort by descending score
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	// Limit the number of results
+	if limit > 0 && limit < len(results) {
+		results = results[:limit]
+	}
+
+	return results
+}
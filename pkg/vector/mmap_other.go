@@ -0,0 +1,17 @@
+//go:build !unix
+
+package vector
+
+import (
+	"os"
+)
+
+// mapFile reads the whole file on platforms without mmap support
+func mapFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+// unmapFile is a no-op since mapFile doesn't map anything
+func unmapFile(data []byte) error {
+	return nil
+}
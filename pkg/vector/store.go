@@ -5,13 +5,16 @@ import (
 	"fmt"
 	"math"
 	"os"
-	"sort"
+	"path/filepath"
+	"strings"
 )
 
 // VectorItem represents an item in the vector storage
 type VectorItem struct {
 	ID      string    `json:"id"`
 	Vector  []float32 `json:"vector"`
+	// Metadata holds key/value pairs that searches can filter on
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 // SearchResult represents a search result
@@ -20,60 +23,203 @@ type SearchResult struct {
 	Score    float64 `json:"score"`
 }
 
-// Store is a simple vector storage with cosine similarity search
+// Store is a simple vector storage with cosine similarity search.
+// Large storages are searched through an approximate HNSW index.
 type Store struct {
 	Items []VectorItem `json:"items"`
+	// Generation identifies the save that wrote the storage file, so that it
+	// can be matched with the other files written by the same save
+	Generation uint64 `json:"-"`
+
+	// mapping holds the memory-mapped file the vectors point into, if any
+	mapping []byte
+	// positions maps IDs to their position in Items; nil when it must be rebuilt
+	positions map[string]int
+	// hnsw is the approximate index, nil until the storage is large enough
+	hnsw        *HNSWIndex
+	indexConfig HNSWConfig
 }
 
 // NewStore creates a new vector storage
 func NewStore() *Store {
 	return &Store{
-		Items: []VectorItem{},
+		Items:       []VectorItem{},
+		indexConfig: DefaultHNSWConfig(),
 	}
 }
 
-// Add adds a vector to the storage
+// position returns the position of id in Items
+func (s *Store) position(id string) (int, bool) {
+	if s.positions == nil {
+		s.positions = make(map[string]int, len(s.Items))
+		for i, item := range s.Items {
+			s.positions[item.ID] = i
+		}
+	}
+	i, ok := s.positions[id]
+	return i, ok
+}
+
+// Add adds a vector to the storage. Replacing a vector keeps its metadata.
 func (s *Store) Add(id string, vector []float32) {
+	if s.hnsw != nil {
+		s.hnsw.Add(id, vector)
+	}
+
 	// Check if the ID already exists
-	for i, item := range s.Items {
-		if item.ID == id {
-			// Replace the existing vector
-			s.Items[i].Vector = vector
-			return
-		}
+	if i, ok := s.position(id); ok {
+		// Replace the existing vector
+		s.Items[i].Vector = vector
+		return
 	}
-	
+
 	// Add a new vector
+	s.positions[id] = len(s.Items)
 	s.Items = append(s.Items, VectorItem{
 		ID:     id,
 		Vector: vector,
 	})
 }
 
+// AddWithMetadata adds a vector and its metadata to the storage
+func (s *Store) AddWithMetadata(id string, vector []float32, metadata map[string]string) {
+	s.Add(id, vector)
+	s.SetMetadata(id, metadata)
+}
+
+// SetMetadata replaces the metadata of a vector and reports whether the
+// vector was present
+func (s *Store) SetMetadata(id string, metadata map[string]string) bool {
+	i, ok := s.position(id)
+	if !ok {
+		return false
+	}
+	s.Items[i].Metadata = metadata
+	return true
+}
+
+// Metadata returns the metadata of a vector
+func (s *Store) Metadata(id string) (map[string]string, bool) {
+	i, ok := s.position(id)
+	if !ok {
+		return nil, false
+	}
+	return s.Items[i].Metadata, true
+}
+
+// HasMetadata reports whether any vector of the storage has metadata
+func (s *Store) HasMetadata() bool {
+	for _, item := range s.Items {
+		if len(item.Metadata) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove removes a vector from the storage and reports whether it was present
+func (s *Store) Remove(id string) bool {
+	i, ok := s.position(id)
+	if !ok {
+		return false
+	}
+
+	s.Items = append(s.Items[:i], s.Items[i+1:]...)
+	s.positions = nil
+	if s.hnsw != nil {
+		s.hnsw.Remove(id)
+	}
+	return true
+}
+
+// Rename changes the ID of a vector, replacing any vector that already has
+// the new ID, and reports whether the vector was present. The HNSW index is
+// rebuilt on next save.
+func (s *Store) Rename(oldID, newID string) bool {
+	if _, ok := s.position(oldID); !ok {
+		return false
+	}
+	if oldID == newID {
+		return true
+	}
+	s.Remove(newID)
+
+	i, _ := s.position(oldID)
+	s.Items[i].ID = newID
+	s.positions = nil
+	s.hnsw = nil
+	return true
+}
+
+// Dimension returns the dimension of the stored vectors, or 0 if the storage is empty
+func (s *Store) Dimension() int {
+	if len(s.Items) == 0 {
+		return 0
+	}
+	return len(s.Items[0].Vector)
+}
+
+// SetIndexConfig changes the parameters of the HNSW index. Changing M or
+// EfConstruction discards the current graph, which is rebuilt on next save.
+func (s *Store) SetIndexConfig(config HNSWConfig) {
+	config = config.withDefaults()
+	if s.hnsw != nil {
+		current := s.hnsw.Config()
+		if current.M != config.M || current.EfConstruction != config.EfConstruction {
+			s.hnsw = nil
+		} else {
+			s.hnsw.SetEfSearch(config.EfSearch)
+		}
+	}
+	s.indexConfig = config
+}
+
+// BuildIndex (re)builds the HNSW index from all stored vectors
+func (s *Store) BuildIndex() {
+	s.hnsw = NewHNSWIndex(s.indexConfig)
+	for _, item := range s.Items {
+		s.hnsw.Add(item.ID, item.Vector)
+	}
+}
+
+// index returns the index used to search the storage
+func (s *Store) index() Index {
+	if s.hnsw != nil && len(s.Items) >= MinIndexSize {
+		return s.hnsw
+	}
+	return &flatIndex{store: s}
+}
+
 // Search searches for the most similar vectors
 func (s *Store) Search(query []float32, limit int) []SearchResult {
-	var results []SearchResult
-	
-	// Calculate cosine similarity for each vector
-	for _, item := range s.Items {
-		score := cosineSimilarity(query, item.Vector)
-		results = append(results, SearchResult{
-			ID:    item.ID,
-			Score: score,
-		})
+	return s.index().Search(query, limit)
+}
+
+// SearchFiltered searches for the most similar vectors among those whose
+// metadata matches filter. The filter applies before the limit: the storage
+// is searched exhaustively, as the HNSW graph can't skip non-matching vectors
+// without losing results.
+func (s *Store) SearchFiltered(query []float32, limit int, filter Filter) []SearchResult {
+	if filter == nil {
+		return s.Search(query, limit)
 	}
-	
-	// Sort by descending score
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].Score > results[j].Score
-	})
-	
-	// Limit the number of results
-	if limit > 0 && limit < len(results) {
-		results = results[:limit]
+	return (&flatIndex{store: s, filter: filter}).Search(query, limit)
+}
+
+// Similarity returns the cosine similarity between query and the vector with
+// the given ID, and false if there is no such vector
+func (s *Store) Similarity(query []float32, id string) (float64, bool) {
+	i, ok := s.position(id)
+	if !ok {
+		return 0, false
 	}
-	
-	return results
+	return cosineSimilarity(query, s.Items[i].Vector), true
+}
+
+// SearchExact searches for the most similar vectors by comparing the query
+// with every stored vector, even when an approximate index is available
+func (s *Store) SearchExact(query []float32, limit int) []SearchResult {
+	return (&flatIndex{store: s}).Search(query, limit)
 }
 
 // cosineSimilarity calculates the cosine similarity between two vectors
@@ -98,43 +244,132 @@ func cosineSimilarity(a, b []float32) float64 {
 	return dotProduct / (math.Sqrt(normA) * math.Sqrt(normB))
 }
 
-// Save saves the vector storage to a file
+// IndexPath returns the path of the HNSW index stored next to a vector storage file
+func IndexPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".hnsw"
+}
+
+// Save saves the vector storage to a file in the binary format, along with
+// its HNSW index when the storage is large enough to use one
 func (s *Store) Save(path string) error {
-	data, err := json.MarshalIndent(s, "", "  ")
-	if err != nil {
-		return fmt.Errorf("unable to serialize vector storage: %w", err)
-	}
-	
-	err = os.WriteFile(path, data, 0644)
+	err := s.writeBinary(path)
 	if err != nil {
 		return fmt.Errorf("unable to save vector storage: %w", err)
 	}
-	
+
+	index := s.UpdateIndex()
+	if index == nil {
+		if err := os.Remove(IndexPath(path)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove stale index: %w", err)
+		}
+		return nil
+	}
+	if err := index.Save(IndexPath(path)); err != nil {
+		return fmt.Errorf("unable to save vector index: %w", err)
+	}
+
 	return nil
 }
 
-// Load loads the vector storage from a file
+// UpdateIndex prepares the HNSW index for a save and returns it, or nil if
+// the storage is too small to use one
+func (s *Store) UpdateIndex() *HNSWIndex {
+	if len(s.Items) < MinIndexSize {
+		s.hnsw = nil
+		return nil
+	}
+
+	// Build the index when the storage crosses the threshold, and rebuild
+	// it once too many removed vectors slow the graph down
+	if s.hnsw == nil || s.hnsw.DeletedRatio() > 0.25 {
+		s.BuildIndex()
+	}
+	return s.hnsw
+}
+
+// SetIndex attaches an index read with ReadHNSWIndex from the vectors of the
+// storage
+func (s *Store) SetIndex(index *HNSWIndex) {
+	index.SetEfSearch(s.indexConfig.EfSearch)
+	s.hnsw = index
+}
+
+// Load loads the vector storage from a file. Binary files are memory-mapped;
+// files in the legacy JSON format are decoded in memory.
 func (s *Store) Load(path string) error {
 	// Check if the file exists
 	_, err := os.Stat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// File doesn't exist, use empty storage
+			s.Close()
 			s.Items = []VectorItem{}
+			s.positions = nil
+			s.hnsw = nil
 			return nil
 		}
 		return fmt.Errorf("unable to access vector storage file: %w", err)
 	}
-	
-	data, err := os.ReadFile(path)
+
+	data, err := mapFile(path)
 	if err != nil {
 		return fmt.Errorf("unable to read vector storage file: %w", err)
 	}
-	
-	err = json.Unmarshal(data, s)
-	if err != nil {
+
+	if !isBinaryStore(data) {
+		// Legacy JSON storage: decode it and release the mapping
+		var legacy Store
+		err = json.Unmarshal(data, &legacy)
+		unmapFile(data)
+		if err != nil {
+			return fmt.Errorf("unable to deserialize vector storage: %w", err)
+		}
+		// The previous vectors may point into a mapping of their own
+		s.Close()
+		s.Items = legacy.Items
+		if s.Items == nil {
+			s.Items = []VectorItem{}
+		}
+		s.Generation = 0
+		s.positions = nil
+		s.hnsw = nil
+		return nil
+	}
+
+	if err := s.readBinary(data); err != nil {
+		unmapFile(data)
 		return fmt.Errorf("unable to deserialize vector storage: %w", err)
 	}
-	
+
+	s.Close()
+	s.mapping = data
+	s.positions = nil
+	s.loadIndex(path)
 	return nil
-} 
\ No newline at end of file
+}
+
+// loadIndex loads the HNSW index stored next to path. A missing or outdated
+// index is ignored: searches fall back to brute force until the next save.
+func (s *Store) loadIndex(path string) {
+	s.hnsw = nil
+	if len(s.Items) < MinIndexSize {
+		return
+	}
+
+	index, err := LoadHNSWIndex(IndexPath(path), s.Items)
+	if err != nil {
+		return
+	}
+	s.SetIndex(index)
+}
+
+// Close releases the memory-mapped file backing the storage, if any.
+// Vectors loaded from that file must not be used afterwards.
+func (s *Store) Close() error {
+	if s.mapping == nil {
+		return nil
+	}
+	err := unmapFile(s.mapping)
+	s.mapping = nil
+	return err
+}
@@ -35,7 +35,7 @@ if [ "$OS" = "Darwin" ]; then
     brew install python
   fi
   
-  pip3 install pdfminer.six docx2txt xlsx2csv
+  pip3 install pdfminer.six
   
 # Linux
 elif [ "$OS" = "Linux" ]; then
@@ -46,14 +46,13 @@ elif [ "$OS" = "Linux" ]; then
     echo "Gestionnaire de paquets apt-get détecté"
     sudo apt-get update
     sudo apt-get install -y poppler-utils tesseract-ocr tesseract-ocr-fra python3-pip
-    sudo apt-get install -y catdoc unrtf
+    sudo apt-get install -y unrtf
   
   # Essayer yum (CentOS/RHEL)
   elif is_installed yum; then
     echo "Gestionnaire de paquets yum détecté"
     sudo yum update
     sudo yum install -y poppler-utils tesseract tesseract-langpack-fra python3-pip
-    sudo yum install -y catdoc
   
   # Essayer pacman (Arch Linux)
   elif is_installed pacman; then
@@ -72,7 +71,7 @@ elif [ "$OS" = "Linux" ]; then
   fi
   
   # Installer les packages Python
-  pip3 install --user pdfminer.six docx2txt xlsx2csv
+  pip3 install --user pdfminer.six
 
 # Windows (via WSL)
 elif [[ "$OS" == MINGW* ]] || [[ "$OS" == MSYS* ]] || [[ "$OS" == CYGWIN* ]]; then
@@ -80,11 +79,11 @@ elif [[ "$OS" == MINGW* ]] || [[ "$OS" == MSYS* ]] || [[ "$OS" == CYGWIN* ]]; th
   echo "Il est recommandé d'utiliser WSL (Windows Subsystem for Linux) pour de meilleures performances."
   echo "Vous pouvez installer les dépendances manuellement:"
   echo "1. Installez Python: https://www.python.org/downloads/windows/"
-  echo "2. Installez les packages Python: pip install pdfminer.six docx2txt xlsx2csv"
+  echo "2. Installez les packages Python: pip install pdfminer.six"
   echo "3. Pour l'OCR, installez Tesseract: https://github.com/UB-Mannheim/tesseract/wiki"
 fi
 
 echo "Installation des dépendances Python..."
-pip3 install --user pdfminer.six docx2txt xlsx2csv
+pip3 install --user pdfminer.six
 
 echo "Installation terminée!" 
\ No newline at end of file